@@ -0,0 +1,496 @@
+// Command bbcli drives a bot from terminal with arrow keys or WASD and shows
+// live telemetry. With -gamepad it drives with gamepad instead and with -script
+// by directions read from a file, for automated tests. With -mode it switches
+// the bot to autonomous mode, or back to manual, and exits. Similarly -mission
+// uploads and starts a mission and -mission-control pauses, resumes or aborts
+// it, while -behavior uploads and starts Starlark behavior script. Any client
+// can latch emergency stop with -estop, or e key while driving with keyboard,
+// and clear it with -estop-clear. With -profile it switches driving profile.
+// Features the bot lacks, as told by Hello, are refused before calling it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pawelkowalak/berrybot/discovery"
+	"github.com/pawelkowalak/berrybot/gamepad"
+	"github.com/pawelkowalak/berrybot/mission"
+	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/protocol"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"golang.org/x/term"
+	"google.golang.org/grpc"
+)
+
+var (
+	addr      = flag.String("addr", "", "Bot gRPC address, discovered by UDP broadcast if empty")
+	bcastPort = flag.String("bcast-port", discovery.DefaultPort, "UDP broadcast port used for discovery")
+	power     = flag.Int("power", 80, "Power used when driving with keys, between 0 and 100")
+	script    = flag.String("script", "", "Drive by script file instead of keyboard, - reads standard input")
+	padPath   = flag.String("gamepad", "", "Drive with gamepad joystick device (e.g. /dev/input/js0) instead of keyboard")
+	mode      = flag.String("mode", "", "Set driving mode (manual, wander, wall_follow) and exit")
+	missionF  = flag.String("mission", "", "Run mission from JSON file and exit")
+	missionC  = flag.String("mission-control", "", "Control running mission (pause, resume, abort) and exit")
+	behavior  = flag.String("behavior", "", "Run Starlark behavior script from file and exit")
+	estop     = flag.String("estop", "", "Latch emergency stop with given reason and exit")
+	estopClr  = flag.Bool("estop-clear", false, "Clear emergency stop and exit")
+	profile   = flag.String("profile", "", "Set driving profile (sport, indoor, kids) and exit")
+)
+
+const (
+	sendDur    = time.Millisecond * 100
+	refreshDur = time.Millisecond * 200
+	// Terminals send no key release, so key counts as held for a while after
+	// each press. It has to cover initial key repeat delay.
+	keyHold = time.Millisecond * 600
+)
+
+// Name sent in Hello, set with -ldflags "-X main.version=...".
+var version = "dev"
+
+// Client keeps connection to the bot and the latest telemetry.
+type client struct {
+	stream pb.Driver_DriveClient
+	cli    pb.DriverClient
+	hello  *pb.HelloResponse
+	caps   protocol.Set
+
+	mu   sync.Mutex
+	dir  pb.Direction
+	seq  uint64 // Sequence number of the last sent direction.
+	tel  *pb.Telemetry
+	err  error
+	help string // Controls shown on dashboard.
+}
+
+func main() {
+	flag.Parse()
+
+	if *addr == "" {
+		log.Infof("Listening on UDP/%s...", *bcastPort)
+		var err error
+		if *addr, err = discovery.Find(*bcastPort, time.Second*10); err != nil {
+			log.Fatal(err)
+		}
+	}
+	conn, err := grpc.Dial(*addr, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	cli := pb.NewDriverClient(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hello, err := sayHello(ctx, cli)
+	if err != nil {
+		log.Fatal(err)
+	}
+	caps := protocol.NewSet(hello.Capabilities)
+	// require exits when the bot lacks capability needed by a flag.
+	require := func(c, flag string) {
+		if !caps.Has(c) {
+			log.Fatalf("bot doesn't support %s, needed by -%s", c, flag)
+		}
+	}
+
+	if *mode != "" {
+		m, ok := pb.Mode_value[strings.ToUpper(*mode)]
+		if !ok {
+			log.Fatalf("unknown mode %q", *mode)
+		}
+		if pb.Mode(m) != pb.Mode_MANUAL {
+			require("mode."+strings.ToLower(*mode), "mode")
+		}
+		resp, err := cli.SetMode(ctx, &pb.ModeRequest{Mode: pb.Mode(m)})
+		if err != nil {
+			log.Fatalf("can't set mode: %v", err)
+		}
+		log.Infof("Mode set to %s", resp.Mode)
+		return
+	}
+	if *profile != "" {
+		p, ok := pb.Profile_value["PROFILE_"+strings.ToUpper(*profile)]
+		if !ok {
+			log.Fatalf("unknown profile %q", *profile)
+		}
+		require(protocol.Profiles, "profile")
+		resp, err := cli.SetProfile(ctx, &pb.ProfileRequest{Profile: pb.Profile(p)})
+		if err != nil {
+			log.Fatalf("can't set profile: %v", err)
+		}
+		log.Infof("Profile set to %s", resp.Profile)
+		return
+	}
+	if *missionF != "" {
+		require(protocol.ModeMission, "mission")
+		m, err := mission.ReadFile(*missionF)
+		if err != nil {
+			log.Fatal(err)
+		}
+		resp, err := cli.RunMission(ctx, m)
+		if err != nil {
+			log.Fatalf("can't run mission: %v", err)
+		}
+		log.Infof("Mode set to %s", resp.Mode)
+		return
+	}
+	if *estop != "" {
+		require(protocol.EmergencyStop, "estop")
+		st, err := cli.EmergencyStop(ctx, &pb.EmergencyStopRequest{Reason: *estop})
+		if err != nil {
+			log.Fatalf("can't stop: %v", err)
+		}
+		log.Infof("Emergency stop latched: %s", st.Reason)
+		return
+	}
+	if *estopClr {
+		require(protocol.EmergencyStop, "estop-clear")
+		if _, err := cli.ClearEmergencyStop(ctx, &pb.ClearEmergencyStopRequest{}); err != nil {
+			log.Fatalf("can't clear emergency stop: %v", err)
+		}
+		log.Info("Emergency stop cleared")
+		return
+	}
+	if *behavior != "" {
+		require(protocol.ModeScript, "behavior")
+		src, err := os.ReadFile(*behavior)
+		if err != nil {
+			log.Fatalf("can't read behavior script: %v", err)
+		}
+		resp, err := cli.RunScript(ctx, &pb.Script{Name: filepath.Base(*behavior), Source: string(src)})
+		if err != nil {
+			log.Fatalf("can't run behavior script: %v", err)
+		}
+		log.Infof("Mode set to %s", resp.Mode)
+		return
+	}
+	if *missionC != "" {
+		require(protocol.ModeMission, "mission-control")
+		a, ok := pb.MissionAction_value["MISSION_"+strings.ToUpper(*missionC)]
+		if !ok {
+			log.Fatalf("unknown mission action %q", *missionC)
+		}
+		resp, err := cli.ControlMission(ctx, &pb.MissionControl{Action: pb.MissionAction(a)})
+		if err != nil {
+			log.Fatalf("can't control mission: %v", err)
+		}
+		log.Infof("Sent mission %s, mode is %s", *missionC, resp.Mode)
+		return
+	}
+
+	stream, err := cli.Drive(ctx)
+	if err != nil {
+		log.Fatalf("%v.Drive(_) = _, %v", cli, err)
+	}
+	c := &client{stream: stream, cli: cli, hello: hello, caps: caps}
+	go c.receive()
+
+	if *script != "" {
+		if err := c.runScript(*script); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *padPath != "" {
+		if err := c.runGamepad(*padPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := c.runKeyboard(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// sayHello tells the bot client's protocol version and returns bot's version
+// and capabilities. Bots older than Hello get treated as speaking version 0,
+// with nothing but Drive.
+func sayHello(ctx context.Context, cli pb.DriverClient) (*pb.HelloResponse, error) {
+	hello, err := cli.Hello(ctx, &pb.HelloRequest{ProtocolVersion: protocol.Version, Client: "bbcli " + version})
+	if protocol.Unsupported(err) {
+		log.Warn("Bot doesn't know Hello, it's too old for anything but driving")
+		return &pb.HelloResponse{Build: "unknown"}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can't say hello: %v", err)
+	}
+	if hello.MinProtocolVersion > protocol.Version {
+		return nil, fmt.Errorf("bot needs protocol version %d, bbcli speaks %d, upgrade it", hello.MinProtocolVersion, protocol.Version)
+	}
+	log.Infof("Bot %s, protocol version %d, capabilities %v", hello.Build, hello.ProtocolVersion, hello.Capabilities)
+	return hello, nil
+}
+
+func (c *client) receive() {
+	for {
+		t, err := c.stream.Recv()
+		c.mu.Lock()
+		if err != nil {
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+		c.tel = t
+		c.mu.Unlock()
+		if *script != "" {
+			log.Infof("Telemetry: %v", t)
+		}
+	}
+}
+
+func (c *client) send(d pb.Direction) error {
+	c.mu.Lock()
+	c.seq++
+	d.Seq, d.Timestamp = c.seq, time.Now().UnixNano()
+	c.dir = d
+	c.mu.Unlock()
+	if err := c.stream.Send(&d); err != nil {
+		return fmt.Errorf("%v.Send(%v) = %v", c.stream, &d, err)
+	}
+	return nil
+}
+
+// Keys read from terminal in raw mode.
+type key int
+
+const (
+	keyNone key = iota
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyStop
+	keyEStop
+	keyQuit
+)
+
+// parseKeys turns raw terminal input into keys. Arrows come as escape
+// sequences, letters are case insensitive.
+func parseKeys(b []byte) []key {
+	var keys []key
+	for i := 0; i < len(b); i++ {
+		if b[i] == 0x1b && i+2 < len(b) && b[i+1] == '[' {
+			switch b[i+2] {
+			case 'A':
+				keys = append(keys, keyUp)
+			case 'B':
+				keys = append(keys, keyDown)
+			case 'C':
+				keys = append(keys, keyRight)
+			case 'D':
+				keys = append(keys, keyLeft)
+			}
+			i += 2
+			continue
+		}
+		switch strings.ToLower(string(b[i])) {
+		case "w":
+			keys = append(keys, keyUp)
+		case "s":
+			keys = append(keys, keyDown)
+		case "a":
+			keys = append(keys, keyLeft)
+		case "d":
+			keys = append(keys, keyRight)
+		case " ":
+			keys = append(keys, keyStop)
+		case "e":
+			keys = append(keys, keyEStop)
+		case "q", "\x03": // Ctrl-C doesn't send signal in raw mode.
+			keys = append(keys, keyQuit)
+		}
+	}
+	return keys
+}
+
+// runKeyboard drives with keys until quit, redrawing dashboard on the way.
+func (c *client) runKeyboard() error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("standard input is not a terminal, use -script")
+	}
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("can't set terminal raw mode: %v", err)
+	}
+	defer term.Restore(fd, old)
+	defer fmt.Print("\r\n")
+
+	keys := make(chan key)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				keys <- keyQuit
+				return
+			}
+			for _, k := range parseKeys(buf[:n]) {
+				keys <- k
+			}
+		}
+	}()
+
+	send := time.NewTicker(sendDur)
+	defer send.Stop()
+	refresh := time.NewTicker(refreshDur)
+	defer refresh.Stop()
+	var dx, dy int32
+	var dxAt, dyAt time.Time
+	for {
+		select {
+		case k := <-keys:
+			now := time.Now()
+			switch k {
+			case keyUp:
+				dy, dyAt = int32(*power), now
+			case keyDown:
+				dy, dyAt = -int32(*power), now
+			case keyRight:
+				dx, dxAt = int32(*power), now
+			case keyLeft:
+				dx, dxAt = -int32(*power), now
+			case keyStop:
+				dx, dy = 0, 0
+			case keyEStop:
+				dx, dy = 0, 0
+				if !c.caps.Has(protocol.EmergencyStop) {
+					break
+				}
+				if _, err := c.cli.EmergencyStop(context.Background(), &pb.EmergencyStopRequest{Reason: "bbcli"}); err != nil {
+					return fmt.Errorf("can't stop: %v", err)
+				}
+			case keyQuit:
+				return c.send(pb.Direction{})
+			}
+		case <-send.C:
+			if time.Since(dxAt) > keyHold {
+				dx = 0
+			}
+			if time.Since(dyAt) > keyHold {
+				dy = 0
+			}
+			if err := c.send(pb.Direction{Dx: dx, Dy: dy}); err != nil {
+				return err
+			}
+		case <-refresh.C:
+			c.draw(os.Stdout)
+		}
+	}
+}
+
+// runGamepad drives with gamepad until it's unplugged.
+func (c *client) runGamepad(path string) error {
+	pad, err := gamepad.Open(path, gamepad.DefaultMapping)
+	if err != nil {
+		return err
+	}
+	defer pad.Close()
+	padErr := make(chan error, 1)
+	go func() { padErr <- pad.Run() }()
+
+	send := time.NewTicker(sendDur)
+	defer send.Stop()
+	refresh := time.NewTicker(refreshDur)
+	defer refresh.Stop()
+	for {
+		select {
+		case err := <-padErr:
+			c.send(pb.Direction{})
+			return err
+		case a := <-pad.Actions():
+			if err := c.padAction(a); err != nil {
+				return err
+			}
+		case <-send.C:
+			if err := c.send(*pad.Direction()); err != nil {
+				return err
+			}
+		case <-refresh.C:
+			c.mu.Lock()
+			c.help = fmt.Sprintf("left stick drives, B emergency stop (%v), A slow mode (%v), Y next profile", pad.Stopped(), pad.Slow())
+			c.mu.Unlock()
+			c.draw(os.Stdout)
+		}
+	}
+}
+
+// padAction calls RPCs for gamepad buttons, on bots that support them. Stop
+// button zeroes directions of the pad anyway.
+func (c *client) padAction(a gamepad.Action) error {
+	ctx := context.Background()
+	switch {
+	case a == gamepad.ActionStop && c.caps.Has(protocol.EmergencyStop):
+		if _, err := c.cli.EmergencyStop(ctx, &pb.EmergencyStopRequest{Reason: "bbcli gamepad"}); err != nil {
+			return fmt.Errorf("can't stop: %v", err)
+		}
+	case a == gamepad.ActionReleaseStop && c.caps.Has(protocol.EmergencyStop):
+		if _, err := c.cli.ClearEmergencyStop(ctx, &pb.ClearEmergencyStopRequest{}); err != nil {
+			return fmt.Errorf("can't clear emergency stop: %v", err)
+		}
+	case a == gamepad.ActionProfile && c.caps.Has(protocol.Profiles):
+		var p pb.Profile
+		c.mu.Lock()
+		if c.tel != nil {
+			p = c.tel.Profile
+		}
+		c.mu.Unlock()
+		if _, err := c.cli.SetProfile(ctx, &pb.ProfileRequest{Profile: gamepad.NextProfile(p)}); err != nil {
+			return fmt.Errorf("can't set profile: %v", err)
+		}
+	}
+	return nil
+}
+
+// draw prints text dashboard, in raw mode lines need explicit carriage return.
+func (c *client) draw(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	help := c.help
+	if help == "" {
+		help = "arrows/WASD drive, space stops, e emergency stop, q quits"
+		if !c.caps.Has(protocol.EmergencyStop) {
+			help = "arrows/WASD drive, space stops, q quits"
+		}
+	}
+	fmt.Fprintf(&b, "BerryBot %s %s   %s\n\n", *addr, c.hello.Build, help)
+	fmt.Fprintf(&b, "Direction   dx %4d  dy %4d\n", c.dir.Dx, c.dir.Dy)
+	if c.err != nil {
+		fmt.Fprintf(&b, "\nConnection lost: %v\n", c.err)
+	}
+	if t := c.tel; t != nil {
+		fmt.Fprintf(&b, "Drive       %-10s left %4d  right %4d  speed %d  %s  %s\n", t.Cmd, t.LeftPower, t.RightPower, t.Speed, t.Mode, t.Profile)
+		fmt.Fprintf(&b, "Distance    front %4dcm  rear %4dcm\n", t.DistFront, t.DistRear)
+		fmt.Fprintf(&b, "Pose        x %6.1fcm  y %6.1fcm  heading %5.1f°\n", t.PosX, t.PosY, t.Heading)
+		if c.caps.Has(protocol.IMU) {
+			fmt.Fprintf(&b, "IMU         %s  %.2fg  %.1f°/s\n", t.ImuState, t.Accel, t.AngularRate)
+		}
+		if t.EmergencyStop {
+			fmt.Fprintf(&b, "EMERGENCY STOP  %s\n", t.EmergencyStopReason)
+		}
+		if c.caps.Has(protocol.Battery) {
+			fmt.Fprintf(&b, "Battery     %s  %.2fV  %d%%  %dmin\n", t.BatteryState, t.BatteryVoltage, t.BatteryPercent, t.BatteryMinutes)
+		}
+		if t.MissionSteps > 0 {
+			paused := ""
+			if t.MissionPaused {
+				paused = "  paused"
+			}
+			fmt.Fprintf(&b, "Mission     step %d/%d  %3.0f%%%s\n", t.MissionStep, t.MissionSteps, t.MissionStepDone*100, paused)
+		}
+	} else {
+		b.WriteString("Waiting for telemetry...\n")
+	}
+	fmt.Fprint(w, strings.Replace(b.String(), "\n", "\r\n", -1))
+}
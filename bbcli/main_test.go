@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want []key
+	}{
+		{"nothing", "", nil},
+		{"letters", "wasd", []key{keyUp, keyLeft, keyDown, keyRight}},
+		{"upper case", "WD", []key{keyUp, keyRight}},
+		{"stop and estop", " e", []key{keyStop, keyEStop}},
+		{"quit", "q", []key{keyQuit}},
+		{"ctrl-c", "\x03", []key{keyQuit}},
+		{"arrows", "\x1b[A\x1b[B\x1b[C\x1b[D", []key{keyUp, keyDown, keyRight, keyLeft}},
+		{"arrows and letters", "w\x1b[Cs", []key{keyUp, keyRight, keyDown}},
+		{"unknown escape", "\x1b[Zw", []key{keyUp}},
+		{"truncated escape", "w\x1b[", []key{keyUp}},
+		{"lone escape", "\x1bd", []key{keyRight}},
+		{"unknown keys", "xyz123\r\n", nil},
+	} {
+		if got := parseKeys([]byte(tc.in)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: parseKeys(%q) = %v, want %v", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Script step drives with direction for given time.
+type step struct {
+	dir pb.Direction
+	dur time.Duration
+}
+
+// parseScript reads steps, one per line as "dx dy duration", for example
+// "0 80 1.5s". Empty lines and lines starting with # are skipped.
+func parseScript(r io.Reader) ([]step, error) {
+	var steps []step
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) != 3 {
+			return nil, fmt.Errorf("line %d: want \"dx dy duration\", got %q", n, line)
+		}
+		dx, err := strconv.ParseInt(f[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad dx: %v", n, err)
+		}
+		dy, err := strconv.ParseInt(f[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad dy: %v", n, err)
+		}
+		if dx < -100 || dx > 100 || dy < -100 || dy > 100 {
+			return nil, fmt.Errorf("line %d: dx %d or dy %d out of range -100..100", n, dx, dy)
+		}
+		dur, err := time.ParseDuration(f[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad duration: %v", n, err)
+		}
+		if dur <= 0 {
+			return nil, fmt.Errorf("line %d: duration %v isn't positive", n, dur)
+		}
+		steps = append(steps, step{dir: pb.Direction{Dx: int32(dx), Dy: int32(dy)}, dur: dur})
+	}
+	return steps, sc.Err()
+}
+
+// runScript drives by script steps, repeating each direction often enough to
+// keep the bot from emergency stop, and stops at the end.
+func (c *client) runScript(name string) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	steps, err := parseScript(r)
+	if err != nil {
+		return fmt.Errorf("can't parse script: %v", err)
+	}
+	for _, s := range steps {
+		log.Infof("Driving dx=%d dy=%d for %v", s.dir.Dx, s.dir.Dy, s.dur)
+		end := time.Now().Add(s.dur)
+		for time.Now().Before(end) {
+			if err := c.send(s.dir); err != nil {
+				return err
+			}
+			wait := time.Until(end)
+			if wait > sendDur {
+				wait = sendDur
+			}
+			time.Sleep(wait)
+		}
+	}
+	return c.send(pb.Direction{})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+func TestParseScript(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		want []step
+		err  string // Part of error, empty if parsing succeeds.
+	}{
+		{"empty", "", nil, ""},
+		{"comments and blanks", "# square\n\n   \n", nil, ""},
+		{"steps", "0 80 1.5s\n  -50\t50 200ms  \n0 0 1s\n", []step{
+			{pb.Direction{Dy: 80}, time.Millisecond * 1500},
+			{pb.Direction{Dx: -50, Dy: 50}, time.Millisecond * 200},
+			{pb.Direction{}, time.Second},
+		}, ""},
+		{"extremes", "-100 100 1h", []step{{pb.Direction{Dx: -100, Dy: 100}, time.Hour}}, ""},
+		{"too few fields", "0 80\n", nil, "line 1"},
+		{"too many fields", "0 80 1s 2s\n", nil, "line 1"},
+		{"bad dx", "# ok\nx 80 1s\n", nil, "line 2: bad dx"},
+		{"bad dy", "0 8.5 1s\n", nil, "bad dy"},
+		{"dx out of range", "101 0 1s\n", nil, "out of range"},
+		{"dy overflow", "0 99999999999 1s\n", nil, "bad dy"},
+		{"missing unit", "0 80 2\n", nil, "bad duration"},
+		{"zero duration", "0 80 0s\n", nil, "isn't positive"},
+		{"negative duration", "0 80 -1s\n", nil, "isn't positive"},
+		{"error after steps", "0 80 1s\n0 80 1s\nstop\n", nil, "line 3"},
+	} {
+		got, err := parseScript(strings.NewReader(tc.src))
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want one with %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: parsed %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -1,17 +1,23 @@
 package main
 
 import (
-	"fmt"
+	"bytes"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
 	_ "image/png"
 	"io"
-	"math"
-	"net"
+	"sync"
+	"time"
 
+	"github.com/pawelkowalak/berrybot/discovery"
 	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/protocol"
 
 	"golang.org/x/mobile/asset"
 	"golang.org/x/mobile/event/size"
+	"golang.org/x/mobile/event/touch"
 	"golang.org/x/mobile/exp/f32"
 	"golang.org/x/mobile/exp/sprite"
 	"golang.org/x/mobile/exp/sprite/clock"
@@ -23,65 +29,52 @@ import (
 type App struct {
 	connected   bool
 	conn        *grpc.ClientConn
+	cli         pb.DriverClient
+	caps        protocol.Set // Capabilities of the bot, controls it lacks are hidden.
 	DriveStream pb.Driver_DriveClient
-	ctrl        struct {
-		x, midx float32
-		y, midy float32
+	driveSeq    uint64      // Sequence number of the last sent direction.
+	sticks      [2]joystick // The second one is used by tank layout only.
+	bot         struct {
+		x, y float32
 	}
-	stick struct {
-		x, midx float32
-		y, midy float32
+	radar    radar
+	feedback feedback
+	battery  struct {
+		x, y    float32
+		percent int32
+		state   pb.BatteryState
 	}
-	bot struct {
-		x, y        float32
-		front, rear *echo
+	profile struct {
+		x, y    float32
+		current pb.Profile
+		touch   touch.Sequence // Touch started on profile selector.
+		touched bool
 	}
-}
-
-type echo struct {
-	sm, md, lg int
-}
-
-func (e *echo) clear() {
-	e.sm = 0
-	e.md = 0
-	e.lg = 0
-}
-
-func (e *echo) far() {
-	e.sm = 1
-	e.md = 0
-	e.lg = 0
-}
-
-func (e *echo) mid() {
-	e.sm = 2
-	e.md = 1
-	e.lg = 0
-}
-
-func (e *echo) close() {
-	e.sm = 3
-	e.md = 2
-	e.lg = 1
-}
-
-func (e *echo) SetDist(d int32) {
-	switch {
-	case d >= 100:
-		e.far()
-	case d < 100 && d >= 50:
-		e.mid()
-	case d < 50:
-		e.close()
+	screen struct {
+		w, h float32 // Size in points.
+	}
+	video struct {
+		mu    sync.Mutex
+		frame image.Image // The latest frame, nil until the first one comes.
+		fresh bool        // Frame isn't uploaded to texture yet.
+		tex   sprite.Texture
+	}
+	gallery    gallery
+	tilt       tilt
+	settings   settings
+	settingsUI struct {
+		open    bool
+		dirty   bool           // Curve previews need redrawing.
+		touch   touch.Sequence // Touch started on settings button or screen.
+		touched bool
 	}
 }
 
 // NewApp creates new app.
 func NewApp() *App {
 	a := App{}
-	a.bot.front = &echo{}
-	a.bot.rear = &echo{}
+	a.settings = loadSettings()
+	a.settingsUI.dirty = true
 
 	go func() {
 		for {
@@ -96,117 +89,178 @@ func NewApp() *App {
 			if err != nil {
 				return
 			}
-			a.bot.front.SetDist(t.DistFront)
-			a.bot.rear.SetDist(t.DistRear)
+			a.SetRanges(t)
+			a.SetMoving(t)
+			a.SetBattery(t)
+			a.profile.current = t.Profile
 		}
 	}()
 	return &a
 }
 
-const defaultBcastPort = "8032"
+// SetBattery updates battery gauge and warns about battery state changes.
+func (a *App) SetBattery(t *pb.Telemetry) {
+	a.battery.percent = t.BatteryPercent
+	if t.BatteryState == a.battery.state {
+		return
+	}
+	a.battery.state = t.BatteryState
+	switch t.BatteryState {
+	case pb.BatteryState_BATTERY_LOW:
+		if t.BatteryMinutes > 0 {
+			log.Printf("Battery low: %d%%, ~%d min left", t.BatteryPercent, t.BatteryMinutes)
+		} else {
+			log.Printf("Battery low: %d%%, power limited", t.BatteryPercent)
+		}
+	case pb.BatteryState_BATTERY_EMPTY:
+		log.Printf("Battery empty: %.1fV, bot stopped", t.BatteryVoltage)
+	}
+}
 
 // discoverBot listens for UDP broadcasts on port 8032 and tries to connect to
 // the first server it finds. This function blocks.
 func (a *App) discoverBot() {
 	// Listen for bots on broadcast.
-	log.Printf("Listening on UDP/%s...", defaultBcastPort)
-	c, err := net.ListenPacket("udp", ":"+defaultBcastPort)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer c.Close()
-	port := make([]byte, 512)
-	_, peer, err := c.ReadFrom(port)
+	log.Printf("Listening on UDP/%s...", discovery.DefaultPort)
+	addr, err := discovery.Find(discovery.DefaultPort, 0)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Received port broadcast from %s", peer)
-	host, _, err := net.SplitHostPort(peer.String())
-	if err != nil {
-		log.Fatalf("can't parse peer IP address %v", err)
-	}
+	log.Printf("Received port broadcast from %s", addr)
 
 	// Connect to first discovered bot via GRPC.
-	a.conn, err = grpc.Dial(fmt.Sprintf("%s:%s", host, string(port)), grpc.WithInsecure())
+	a.conn, err = grpc.Dial(addr, grpc.WithInsecure())
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
 	cli := pb.NewDriverClient(a.conn)
+	a.cli = cli
+	a.caps = hello(cli)
 
 	a.DriveStream, err = cli.Drive(context.Background())
 	if err != nil {
 		log.Fatalf("%v.Drive(_) = _, %v", cli, err)
 	}
 
-	// a.VideoStream, err = cli.GetImage(context.Background(), &pb.Image{Live: true})
-	// if err != nil {
-	// 	log.Fatalf("%v.GetImage(_) = _, %v", cli, err)
-	// }
+	if a.caps.Has(protocol.Camera) {
+		go a.receiveVideo(cli)
+	}
 
 	a.connected = true
 	log.Print("Connected")
 }
 
+// hello exchanges protocol versions with the bot and returns its capabilities.
+// Bots older than Hello can only drive, so their set is empty.
+func hello(cli pb.DriverClient) protocol.Set {
+	resp, err := cli.Hello(context.Background(), &pb.HelloRequest{ProtocolVersion: protocol.Version, Client: "berrybot app"})
+	if err != nil {
+		if !protocol.Unsupported(err) {
+			log.Printf("Can't say hello: %v", err)
+		}
+		log.Print("Old bot, only driving works")
+		return nil
+	}
+	if resp.MinProtocolVersion > protocol.Version {
+		log.Fatalf("Bot needs protocol version %d, app speaks %d, upgrade the app", resp.MinProtocolVersion, protocol.Version)
+	}
+	log.Printf("Bot %s, protocol version %d, capabilities %v", resp.Build, resp.ProtocolVersion, resp.Capabilities)
+	return protocol.NewSet(resp.Capabilities)
+}
+
+// receiveVideo decodes live video frames, drawn behind the controls. Bots
+// without camera refuse to stream and the background stays black.
+func (a *App) receiveVideo(cli pb.DriverClient) {
+	stream, err := cli.GetImage(context.Background(), &pb.Image{Live: true})
+	if err != nil {
+		log.Printf("No video: %v", err)
+		return
+	}
+	for {
+		f, err := stream.Recv()
+		if err != nil {
+			log.Printf("No video: %v", err)
+			return
+		}
+		m, err := jpeg.Decode(bytes.NewReader(f.Jpeg))
+		if err != nil {
+			continue
+		}
+		a.video.mu.Lock()
+		a.video.frame = m
+		a.video.fresh = true
+		a.video.mu.Unlock()
+	}
+}
+
 // Size of controller and stick inside in points.
 const (
 	ctrlSize      = 80
 	ctrlStickSize = 35
 	botSize       = 60
+	batteryW      = 40
+	batteryH      = 14
+	profileBarW   = 12
+	profileBarH   = 24 // Height of the tallest bar.
+	profileGap    = 6
 )
 
+// Profiles in order of bars in profile selector, from the gentlest.
+var profileBars = []pb.Profile{pb.Profile_PROFILE_KIDS, pb.Profile_PROFILE_INDOOR, pb.Profile_PROFILE_SPORT}
+
 // Reset sets default positions, should be used after orientation change, etc.
 func (a *App) Reset(sz size.Event) {
 	if sz.PixelsPerPt == 0 || sz.HeightPt == 0 || sz.WidthPt == 0 {
 		return
 	}
-	a.ctrl.x = float32(sz.WidthPt)/2 - ctrlSize/2
-	a.ctrl.y = 3*float32(sz.HeightPt)/4 - ctrlSize/2
-	a.ctrl.midx = a.ctrl.x + ctrlSize/2
-	a.ctrl.midy = a.ctrl.y + ctrlSize/2
-	a.stick.x = float32(sz.WidthPt)/2 - ctrlStickSize/2
-	a.stick.y = 3*float32(sz.HeightPt)/4 - ctrlStickSize/2
-	a.calcStickMids()
 	a.bot.x = float32(sz.WidthPt)/2 - botSize/2
 	a.bot.y = float32(sz.HeightPt)/3 - botSize/2
+	a.battery.x = float32(sz.WidthPt) - batteryW - 10
+	a.battery.y = 10
+	a.profile.x = 10
+	a.profile.y = 10
+	a.screen.w = float32(sz.WidthPt)
+	a.screen.h = float32(sz.HeightPt)
 }
 
-func (a *App) calcStickMids() {
-	a.stick.midx = a.stick.x + ctrlStickSize/2
-	a.stick.midy = a.stick.y + ctrlStickSize/2
-}
-
+// Radius of stick movement in points.
 const ctrlRadius = 21
 
-// SetStick sets new position of controller stick.
-func (a *App) SetStick(sz size.Event, x, y float32) {
-	if sz.PixelsPerPt == 0 {
-		return
+// TouchProfile handles touches of profile selector, where tapping a bar sets
+// its profile. It returns false for touches which started elsewhere.
+func (a *App) TouchProfile(sz size.Event, e touch.Event) bool {
+	if a.profile.touched && e.Sequence == a.profile.touch {
+		if e.Type == touch.TypeEnd {
+			a.profile.touched = false
+		}
+		return true
+	}
+	if e.Type != touch.TypeBegin || sz.PixelsPerPt == 0 || !a.caps.Has(protocol.Profiles) {
+		return false
 	}
-	xp := x / sz.PixelsPerPt
-	yp := y / sz.PixelsPerPt
-	xc := a.ctrl.x + ctrlSize/2
-	yc := a.ctrl.y + ctrlSize/2
-	d2 := math.Pow(float64(xp-xc), 2) + math.Pow(float64(yp-yc), 2)
-	if d2 < float64(ctrlRadius*ctrlRadius) {
-		a.stick.x = xp - ctrlStickSize/2
-		a.stick.y = yp - ctrlStickSize/2
-	} else {
-		a.stick.x = xc + ctrlRadius*((xp-xc)/float32(math.Sqrt(d2))) - ctrlStickSize/2
-		a.stick.y = yc + ctrlRadius*((yp-yc)/float32(math.Sqrt(d2))) - ctrlStickSize/2
-	}
-	a.calcStickMids()
-	a.SendDrive()
+	xp := e.X/sz.PixelsPerPt - a.profile.x
+	yp := e.Y/sz.PixelsPerPt - a.profile.y
+	if xp < 0 || yp < 0 || yp > profileBarH || xp >= float32(len(profileBars))*(profileBarW+profileGap) {
+		return false
+	}
+	a.profile.touch = e.Sequence
+	a.profile.touched = true
+	go a.SetProfile(profileBars[int(xp/(profileBarW+profileGap))])
+	return true
 }
 
-// ResetStick sets stick to rest position.
-func (a *App) ResetStick(sz size.Event) {
-	if sz.PixelsPerPt == 0 || sz.HeightPt == 0 || sz.WidthPt == 0 {
+// SetProfile switches driving profile of the bot.
+func (a *App) SetProfile(p pb.Profile) {
+	if !a.connected {
+		return
+	}
+	resp, err := a.cli.SetProfile(context.Background(), &pb.ProfileRequest{Profile: p})
+	if err != nil {
+		log.Printf("Can't set profile: %v", err)
 		return
 	}
-	a.stick.x = float32(sz.WidthPt)/2 - ctrlStickSize/2
-	a.stick.y = 3*float32(sz.HeightPt)/4 - ctrlStickSize/2
-	a.calcStickMids()
-	a.SendDrive()
+	a.profile.current = resp.Profile
+	log.Printf("Profile %s", resp.Profile)
 }
 
 // SendDrive sends drive message over gRPC.
@@ -214,9 +268,9 @@ func (a *App) SendDrive() {
 	if !a.connected {
 		return
 	}
-	d := new(pb.Direction)
-	d.Dx = int32((a.stick.midx - a.ctrl.midx) * 100 / ctrlRadius)
-	d.Dy = int32((a.ctrl.midy - a.stick.midy) * 100 / ctrlRadius)
+	d := a.stickDirection()
+	a.driveSeq++
+	d.Seq, d.Timestamp = a.driveSeq, time.Now().UnixNano()
 	if err := a.DriveStream.Send(d); err != nil {
 		log.Fatalf("%v.Send(%v) = %v", a.DriveStream, d, err)
 	}
@@ -225,6 +279,8 @@ func (a *App) SendDrive() {
 // Scene creates and returns a new app scene.
 func (a *App) Scene(eng sprite.Engine, sz size.Event) *sprite.Node {
 	texs := loadTextures(eng)
+	cols := loadColors(eng)
+	icons := loadIcons(eng)
 	scene := &sprite.Node{}
 	eng.Register(scene)
 	eng.SetTransform(scene, f32.Affine{
@@ -238,25 +294,45 @@ func (a *App) Scene(eng sprite.Engine, sz size.Event) *sprite.Node {
 		scene.AppendChild(n)
 	}
 
-	// Controller boundaries.
+	// Video, first so it's behind everything else. It fills the screen width,
+	// centered vertically.
 	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
-		eng.SetSubTex(n, texs[texCtrl])
+		a.video.mu.Lock()
+		m, fresh := a.video.frame, a.video.fresh
+		a.video.fresh = false
+		a.video.mu.Unlock()
+		if m == nil {
+			return
+		}
+		b := m.Bounds()
+		if fresh && a.video.tex != nil {
+			if w, h := a.video.tex.Bounds(); w == b.Dx() && h == b.Dy() {
+				a.video.tex.Upload(b, m)
+			} else {
+				a.video.tex.Release()
+				a.video.tex = nil
+			}
+		}
+		if a.video.tex == nil {
+			tex, err := eng.LoadTexture(m)
+			if err != nil {
+				log.Printf("Can't load video frame: %v", err)
+				return
+			}
+			a.video.tex = tex
+		}
+		h := a.screen.w * float32(b.Dy()) / float32(b.Dx())
+		eng.SetSubTex(n, sprite.SubTex{T: a.video.tex, R: image.Rect(0, 0, b.Dx(), b.Dy())})
 		eng.SetTransform(n, f32.Affine{
-			{ctrlSize, 0, a.ctrl.x},
-			{0, ctrlSize, a.ctrl.y},
+			{a.screen.w, 0, 0},
+			{0, h, (a.screen.h - h) / 2},
 		})
 	})
 
-	// Controller stick.
-	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
-		eng.SetSubTex(n, texs[texStick])
-		eng.SetTransform(n, f32.Affine{
-			{ctrlStickSize, 0, a.stick.x},
-			{0, ctrlStickSize, a.stick.y},
-		})
-	})
+	a.stickNodes(newNode, texs, icons)
+	a.radarNodes(eng, newNode)
 
-	// Bot.
+	// Bot, on top of radar.
 	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
 		eng.SetSubTex(n, texs[texBot])
 		eng.SetTransform(n, f32.Affine{
@@ -265,65 +341,55 @@ func (a *App) Scene(eng sprite.Engine, sz size.Event) *sprite.Node {
 		})
 	})
 
-	// Proximity small.
-	const (
-		smSizeW = 15
-		smSizeH = 5
-	)
+	// Battery gauge, frame and fill depending on charge.
 	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
-		eng.SetSubTex(n, texs[texProxSmGrey+a.bot.front.sm])
+		eng.SetSubTex(n, cols[colGrey])
 		eng.SetTransform(n, f32.Affine{
-			{smSizeW, 0, a.bot.x + botSize/2 - smSizeW/2},
-			{0, smSizeH, a.bot.y - 2*smSizeH},
+			{batteryW, 0, a.battery.x},
+			{0, batteryH, a.battery.y},
 		})
 	})
 	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
-		eng.SetSubTex(n, texs[texProxSmGrey+a.bot.rear.sm])
+		col := colGreen
+		switch a.battery.state {
+		case pb.BatteryState_BATTERY_UNKNOWN:
+			col = colGrey
+		case pb.BatteryState_BATTERY_LOW:
+			col = colOrange
+		case pb.BatteryState_BATTERY_EMPTY:
+			col = colRed
+		}
+		eng.SetSubTex(n, cols[col])
 		eng.SetTransform(n, f32.Affine{
-			{smSizeW, 0, a.bot.x + botSize/2 - smSizeW/2},
-			{0, -smSizeH, a.bot.y + botSize + 2*smSizeH},
+			{(batteryW - 4) * float32(a.battery.percent) / 100, 0, a.battery.x + 2},
+			{0, batteryH - 4, a.battery.y + 2},
 		})
 	})
 
-	// Proximity medium.
-	const (
-		mdSizeW = 25
-		mdSizeH = 7
-	)
-	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
-		eng.SetSubTex(n, texs[texProxMdGrey+a.bot.front.md])
-		eng.SetTransform(n, f32.Affine{
-			{mdSizeW, 0, a.bot.x + botSize/2 - mdSizeW/2},
-			{0, mdSizeH, a.bot.y - 3*mdSizeH},
-		})
-	})
-	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
-		eng.SetSubTex(n, texs[texProxMdGrey+a.bot.rear.md])
-		eng.SetTransform(n, f32.Affine{
-			{mdSizeW, 0, a.bot.x + botSize/2 - mdSizeW/2},
-			{0, -mdSizeH, a.bot.y + botSize + 3*mdSizeH},
+	// Profile selector, bars up to the current profile are lit.
+	for i := range profileBars {
+		h := float32(profileBarH * (i + 1) / len(profileBars))
+		newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+			if !a.caps.Has(protocol.Profiles) {
+				eng.SetSubTex(n, sprite.SubTex{})
+				return
+			}
+			col := colGrey
+			for j, p := range profileBars {
+				if p == a.profile.current && i <= j {
+					col = colGreen
+				}
+			}
+			eng.SetSubTex(n, cols[col])
+			eng.SetTransform(n, f32.Affine{
+				{profileBarW, 0, a.profile.x + float32(i)*(profileBarW+profileGap)},
+				{0, h, a.profile.y + profileBarH - h},
+			})
 		})
-	})
+	}
 
-	// Proximity large
-	const (
-		lgSizeW = 35
-		lgSizeH = 10
-	)
-	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
-		eng.SetSubTex(n, texs[texProxLgGrey+a.bot.front.lg])
-		eng.SetTransform(n, f32.Affine{
-			{lgSizeW, 0, a.bot.x + botSize/2 - lgSizeW/2},
-			{0, lgSizeH, a.bot.y - 3.2*lgSizeH},
-		})
-	})
-	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
-		eng.SetSubTex(n, texs[texProxLgGrey+a.bot.rear.lg])
-		eng.SetTransform(n, f32.Affine{
-			{lgSizeW, 0, a.bot.x + botSize/2 - lgSizeW/2},
-			{0, -lgSizeH, a.bot.y + botSize + 3.2*lgSizeH},
-		})
-	})
+	a.galleryNodes(newNode, icons, cols)
+	a.settingsNodes(eng, newNode, icons, cols)
 
 	return scene
 }
@@ -336,15 +402,6 @@ const (
 	texCtrl = iota
 	texStick
 	texBot
-	texProxSmGrey
-	texProxSmGreen
-	texProxSmOrange
-	texProxSmRed
-	texProxMdGrey
-	texProxMdOrange
-	texProxMdRed
-	texProxLgGrey
-	texProxLgRed
 )
 
 func loadTextures(eng sprite.Engine) []sprite.SubTex {
@@ -368,17 +425,42 @@ func loadTextures(eng sprite.Engine) []sprite.SubTex {
 	// adjacent textures leaking into a given texture.
 	// See: http://stackoverflow.com/questions/19611745/opengl-black-lines-in-between-tiles
 	return []sprite.SubTex{
-		texCtrl:         {T: t, R: image.Rect(0, 0, 320, 320)},
-		texStick:        {T: t, R: image.Rect(320, 0, 440, 120)},
-		texBot:          {T: t, R: image.Rect(0, 320, 300, 610)},
-		texProxSmGrey:   {T: t, R: image.Rect(300, 320, 300+50, 320+15)},
-		texProxSmGreen:  {T: t, R: image.Rect(300, 320+15, 300+50, 320+30)},
-		texProxSmOrange: {T: t, R: image.Rect(300, 320+30, 300+50, 320+45)},
-		texProxSmRed:    {T: t, R: image.Rect(300, 320+45, 300+50, 320+60)},
-		texProxMdGrey:   {T: t, R: image.Rect(300, 320+60, 300+78, 320+80)},
-		texProxMdOrange: {T: t, R: image.Rect(300, 320+80, 300+78, 320+100)},
-		texProxMdRed:    {T: t, R: image.Rect(300, 320+100, 300+78, 320+120)},
-		texProxLgGrey:   {T: t, R: image.Rect(300, 320+120, 300+110, 320+147)},
-		texProxLgRed:    {T: t, R: image.Rect(300, 320+147, 300+110, 320+174)},
+		texCtrl:  {T: t, R: image.Rect(0, 0, 320, 320)},
+		texStick: {T: t, R: image.Rect(320, 0, 440, 120)},
+		texBot:   {T: t, R: image.Rect(0, 320, 300, 610)},
+	}
+}
+
+// Solid colors for gauges, generated at runtime instead of drawn in assets.
+const (
+	colGrey = iota
+	colGreen
+	colOrange
+	colRed
+	colBlack
+)
+
+func loadColors(eng sprite.Engine) []sprite.SubTex {
+	cols := []color.RGBA{
+		colGrey:   {0x60, 0x60, 0x60, 0xff},
+		colGreen:  {0x4c, 0xaf, 0x50, 0xff},
+		colOrange: {0xff, 0x98, 0x00, 0xff},
+		colRed:    {0xf4, 0x43, 0x36, 0xff},
+		colBlack:  {0x00, 0x00, 0x00, 0xff},
+	}
+	const n = 4
+	m := image.NewRGBA(image.Rect(0, 0, n*len(cols), n))
+	for i, c := range cols {
+		draw.Draw(m, image.Rect(i*n, 0, i*n+n, n), &image.Uniform{c}, image.Point{}, draw.Src)
+	}
+	t, err := eng.LoadTexture(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+	texs := make([]sprite.SubTex, len(cols))
+	for i := range cols {
+		// Use inner pixels only to prevent colors leaking from neighbours.
+		texs[i] = sprite.SubTex{T: t, R: image.Rect(i*n+1, 1, i*n+n-1, n-1)}
 	}
+	return texs
 }
@@ -0,0 +1,375 @@
+// Command bbreplay replays recorded driving sessions against a bot, real or
+// simulated with bbserver -sim, and compares resulting telemetry and drive
+// decisions with the recording.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pawelkowalak/berrybot/discovery"
+	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/sessionlog"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+var (
+	addr      = flag.String("addr", "", "Bot gRPC address, discovered by UDP broadcast if empty")
+	bcastPort = flag.String("bcast-port", discovery.DefaultPort, "UDP broadcast port used for discovery")
+	speed     = flag.Float64("speed", 1, "Replay speed factor, 2 replays twice as fast")
+	distTol   = flag.Int("dist-tolerance", 10, "Allowed difference of distances in cm, negative skips comparing distances")
+	settle    = flag.Duration("settle", time.Millisecond*50, "Time after sending direction before its outcome is compared")
+	ramp      = flag.Duration("ramp", time.Second, "Time engines may take to ramp up to recorded power, e.g. with indoor and kids profiles")
+	tail      = flag.Duration("tail", time.Second, "Time to keep receiving telemetry after last direction")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] session.bblog...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || *speed <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *addr == "" {
+		log.Infof("Listening on UDP/%s...", *bcastPort)
+		var err error
+		if *addr, err = discovery.Find(*bcastPort, time.Second*10); err != nil {
+			log.Fatal(err)
+		}
+	}
+	conn, err := grpc.Dial(*addr, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+	cli := pb.NewDriverClient(conn)
+
+	var failed bool
+	for _, name := range flag.Args() {
+		recs, err := sessionlog.ReadFile(name)
+		if err != nil {
+			log.Fatalf("Can't read %s: %v", name, err)
+		}
+		log.Infof("Replaying %s (%d records) on %s", name, len(recs), *addr)
+		res, err := replay(cli, recs)
+		if err != nil {
+			log.Fatalf("Replay of %s failed: %v", name, err)
+		}
+		log.Infof("%s: sent %d directions, received %d telemetry, %d drive and %d telemetry mismatches",
+			name, res.sent, res.received, res.driveDiffs, res.telemetryDiffs)
+		if res.driveDiffs > 0 || res.telemetryDiffs > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+type result struct {
+	sent, received             int
+	driveDiffs, telemetryDiffs int
+}
+
+// Readings fresher than rangeFresh were measured right before telemetry was
+// sent, older ones are repeated from earlier telemetry. Bot measures every
+// 250ms while driving, readings further apart than rangeGap have a slow
+// measurement or a failure between them and can't be interpolated.
+const (
+	rangeFresh = 50 * time.Millisecond
+	rangeGap   = 600 * time.Millisecond
+)
+
+// Bot stops on its own after that long without directions, so recorded
+// command of the last direction holds no longer.
+const driveTimeout = time.Second
+
+// measurement is a fresh distance reading at session time.
+type measurement struct {
+	ts   int64
+	dist int32
+}
+
+// Recorded session split into directions and telemetry, both sorted by time,
+// with fresh distance readings of each sensor.
+type session struct {
+	start, end int64
+	directions []*pb.Record
+	telemetry  []*pb.Record
+	ranges     map[string][]measurement
+}
+
+func newSession(recs []*pb.Record) *session {
+	var s session
+	for _, r := range recs {
+		switch {
+		case r.Direction != nil:
+			s.directions = append(s.directions, r)
+		case r.Telemetry != nil:
+			s.telemetry = append(s.telemetry, r)
+		}
+	}
+	byTime := func(rs []*pb.Record) func(i, j int) bool {
+		return func(i, j int) bool { return rs[i].Timestamp < rs[j].Timestamp }
+	}
+	sort.SliceStable(s.directions, byTime(s.directions))
+	sort.SliceStable(s.telemetry, byTime(s.telemetry))
+	if len(recs) > 0 {
+		s.start, s.end = recs[0].Timestamp, recs[0].Timestamp
+	}
+	for _, r := range recs {
+		s.start = min(s.start, r.Timestamp)
+		s.end = max(s.end, r.Timestamp)
+	}
+	s.ranges = make(map[string][]measurement)
+	for _, r := range s.telemetry {
+		for _, rg := range r.Telemetry.Ranges {
+			if m, ok := fresh(rg, r.Timestamp); ok {
+				s.ranges[rg.Name] = append(s.ranges[rg.Name], m)
+			}
+		}
+	}
+	return &s
+}
+
+// fresh returns measurement of range in telemetry sent at ts, or false if
+// it's invalid or repeated from earlier telemetry.
+func fresh(r *pb.Range, ts int64) (measurement, bool) {
+	age := time.Duration(r.Age) * time.Millisecond
+	if !r.Valid || age > rangeFresh {
+		return measurement{}, false
+	}
+	return measurement{ts: ts - int64(age), dist: r.Dist}, true
+}
+
+// inWindow tells whether session time ts is between the first direction and
+// the end of recording, where recorded outcomes can be compared.
+func (s *session) inWindow(ts int64) bool {
+	return len(s.directions) > 0 && ts >= s.directions[0].Timestamp && ts <= s.end
+}
+
+// recordedDist returns distance the named sensor measured at session time ts,
+// interpolated between the recorded measurements around it, or false when
+// there are none close enough.
+func (s *session) recordedDist(name string, ts int64) (int32, bool) {
+	ms := s.ranges[name]
+	i := sort.Search(len(ms), func(i int) bool { return ms[i].ts >= ts })
+	if i == len(ms) {
+		return 0, false
+	}
+	if ms[i].ts == ts {
+		return ms[i].dist, true
+	}
+	if i == 0 || ms[i].ts-ms[i-1].ts > int64(float64(rangeGap)*(*speed)) {
+		return 0, false
+	}
+	a, b := ms[i-1], ms[i]
+	f := float64(ts-a.ts) / float64(b.ts-a.ts)
+	return a.dist + int32(math.Round(f*float64(b.dist-a.dist))), true
+}
+
+// straight tells whether telemetry shows the bot driving straight or standing.
+func straight(t *pb.Telemetry) bool {
+	return t.LeftPower == t.RightPower
+}
+
+// distDiffs returns fresh distances in t differing from recorded ones by more
+// than tolerance, described for logging. Each measurement is compared once,
+// seen keeps the last one compared for each sensor. During turns small timing
+// differences turn the bot a few degrees more or less and sensors see other
+// obstacles, so distances are compared only when both the replay and the
+// recording drive straight.
+func (s *session) distDiffs(t *pb.Telemetry, ts int64, tol int32, seen map[string]int64) []string {
+	if rt := nearest(s.telemetry, ts); !straight(t) || rt == nil || !straight(rt.Telemetry) {
+		return nil
+	}
+	var diffs []string
+	for _, r := range t.Ranges {
+		m, ok := fresh(r, ts)
+		if !ok {
+			continue
+		}
+		// Age is in real time, session time may run faster.
+		m.ts = ts - int64(float64(ts-m.ts)*(*speed))
+		if abs64(m.ts-seen[r.Name]) < int64(float64(rangeFresh)*(*speed)) {
+			continue
+		}
+		seen[r.Name] = m.ts
+		want, ok := s.recordedDist(r.Name, m.ts)
+		if ok && abs(m.dist-want) > tol {
+			diffs = append(diffs, fmt.Sprintf("%s %dcm, recorded %dcm", r.Name, m.dist, want))
+		}
+	}
+	return diffs
+}
+
+// lastBefore returns the last record with timestamp not after ts.
+func lastBefore(rs []*pb.Record, ts int64) *pb.Record {
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].Timestamp > ts })
+	if i == 0 {
+		return nil
+	}
+	return rs[i-1]
+}
+
+// nearest returns the record with timestamp closest to ts, or nil when ts is
+// outside of records.
+func nearest(rs []*pb.Record, ts int64) *pb.Record {
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].Timestamp >= ts })
+	switch {
+	case len(rs) == 0 || i == len(rs):
+		return nil
+	case rs[i].Timestamp == ts:
+		return rs[i]
+	case i == 0:
+		return nil
+	case ts-rs[i-1].Timestamp < rs[i].Timestamp-ts:
+		return rs[i-1]
+	}
+	return rs[i]
+}
+
+// powerSince returns session time from which recorded engine powers at ts are
+// unchanged, i.e. since when engines ramp up to them.
+func powerSince(rs []*pb.Record, ts int64) int64 {
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].Timestamp > ts }) - 1
+	if i < 0 {
+		return ts
+	}
+	for ; i > 0; i-- {
+		a, b := rs[i-1], rs[i]
+		if signed(a.LeftPower, a.LeftForward) != signed(b.LeftPower, b.LeftForward) ||
+			signed(a.RightPower, a.RightForward) != signed(b.RightPower, b.RightForward) {
+			break
+		}
+	}
+	return rs[i].Timestamp
+}
+
+// rampingTo reports whether engine at power pwr can be ramping up to want,
+// i.e. it's lower and in the same direction. Ramp starts from standstill when
+// direction changes.
+func rampingTo(pwr, want int32) bool {
+	return pwr == 0 || pwr*want > 0 && abs(pwr) < abs(want)
+}
+
+func signed(pwr int32, fwd bool) int32 {
+	if fwd {
+		return pwr
+	}
+	return -pwr
+}
+
+func abs(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// replay sends recorded directions with original timing scaled by speed and
+// compares received telemetry with what was recorded at the same point of
+// the session. Only telemetry between the first direction and the end of
+// recording is compared, distances only when freshly measured.
+func replay(cli pb.DriverClient, recs []*pb.Record) (result, error) {
+	var res result
+	s := newSession(recs)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := cli.Drive(ctx)
+	if err != nil {
+		return res, fmt.Errorf("%v.Drive(_) = _, %v", cli, err)
+	}
+	begin := time.Now()
+	// Session time corresponding to now.
+	sessionNow := func() int64 {
+		return s.start + int64(float64(time.Since(begin))*(*speed))
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]int64) // Session time of the last measurement compared.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			t, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			ts := sessionNow()
+			mu.Lock()
+			res.received++
+			if !s.inWindow(ts) {
+				mu.Unlock()
+				continue
+			}
+			d := lastBefore(s.directions, ts)
+			if since := ts - d.Timestamp; since >= int64(float64(*settle)*(*speed)) && since < int64(float64(driveTimeout)*(*speed)) {
+				l, r := signed(d.LeftPower, d.LeftForward), signed(d.RightPower, d.RightForward)
+				// Recorded powers are the ones engines ramp up to.
+				ramping := ts-powerSince(s.directions, ts) < int64(float64(*ramp)*(*speed))
+				matches := func(pwr, want int32) bool {
+					return pwr == want || ramping && rampingTo(pwr, want)
+				}
+				if t.Cmd != d.Cmd || !matches(t.LeftPower, l) || !matches(t.RightPower, r) {
+					res.driveDiffs++
+					log.Warnf("%v: drive %s (%d, %d), recorded %s (%d, %d) for %v",
+						time.Duration(ts-s.start), t.Cmd, t.LeftPower, t.RightPower, d.Cmd, l, r, d.Direction)
+				}
+			}
+			if rt := nearest(s.telemetry, ts); rt != nil {
+				want := rt.Telemetry
+				if t.Speed != want.Speed || t.ImuState != want.ImuState {
+					res.telemetryDiffs++
+					log.Warnf("%v: telemetry %v, recorded %v", time.Duration(ts-s.start), t, want)
+				}
+			}
+			if *distTol >= 0 {
+				if diffs := s.distDiffs(t, ts, int32(*distTol), seen); len(diffs) > 0 {
+					res.telemetryDiffs++
+					log.Warnf("%v: telemetry dist %s", time.Duration(ts-s.start), strings.Join(diffs, ", "))
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	for _, d := range s.directions {
+		wait := time.Duration(float64(d.Timestamp-s.start)/(*speed)) - time.Since(begin)
+		time.Sleep(wait)
+		// Recorded client time would count as huge latency.
+		dir := *d.Direction
+		dir.Timestamp = 0
+		if err := stream.Send(&dir); err != nil {
+			return res, fmt.Errorf("%v.Send(%v) = %v", stream, &dir, err)
+		}
+		mu.Lock()
+		res.sent++
+		mu.Unlock()
+	}
+	time.Sleep(*tail)
+	stream.CloseSend()
+	cancel()
+	<-done
+	return res, nil
+}
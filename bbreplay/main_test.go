@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+func telemetryAt(ts int64, t *pb.Telemetry) *pb.Record {
+	return &pb.Record{Timestamp: ts, Telemetry: t}
+}
+
+func TestNearestOutsideRecords(t *testing.T) {
+	rs := []*pb.Record{telemetryAt(10, &pb.Telemetry{}), telemetryAt(20, &pb.Telemetry{}), telemetryAt(30, &pb.Telemetry{})}
+	for _, tc := range []struct {
+		ts   int64
+		want int64 // Timestamp of the record, 0 for none.
+	}{
+		{5, 0},
+		{10, 10},
+		{14, 10},
+		{16, 20},
+		{30, 30},
+		{31, 0},
+	} {
+		got := nearest(rs, tc.ts)
+		switch {
+		case tc.want == 0 && got != nil:
+			t.Errorf("nearest(%d) = %d, want none", tc.ts, got.Timestamp)
+		case tc.want != 0 && (got == nil || got.Timestamp != tc.want):
+			t.Errorf("nearest(%d) = %v, want %d", tc.ts, got, tc.want)
+		}
+	}
+}
+
+func TestRecordedDist(t *testing.T) {
+	ms := int64(time.Millisecond)
+	front := func(dist, age int32, valid bool) *pb.Telemetry {
+		return &pb.Telemetry{Ranges: []*pb.Range{{Name: "front", Dist: dist, Age: age, Valid: valid}}}
+	}
+	s := newSession([]*pb.Record{
+		{Timestamp: 0, Direction: &pb.Direction{Dy: 100}},
+		telemetryAt(100*ms, front(100, 0, true)),
+		telemetryAt(200*ms, front(100, 100, true)), // Repeated, not fresh.
+		telemetryAt(350*ms, front(90, 0, true)),
+		telemetryAt(400*ms, front(0, 0, false)),
+		telemetryAt(2000*ms, front(50, 0, true)),
+	})
+	for _, tc := range []struct {
+		ts   int64
+		want int32
+		ok   bool
+	}{
+		{50 * ms, 0, false},
+		{100 * ms, 100, true},
+		{225 * ms, 95, true},
+		{350 * ms, 90, true},
+		{1000 * ms, 0, false}, // Measurements too far apart.
+		{2500 * ms, 0, false},
+	} {
+		got, ok := s.recordedDist("front", tc.ts)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("recordedDist(%v) = %d, %v, want %d, %v", time.Duration(tc.ts), got, ok, tc.want, tc.ok)
+		}
+	}
+	if !s.inWindow(0) || !s.inWindow(2000*ms) || s.inWindow(2001*ms) {
+		t.Error("window isn't from the first direction to the end of recording")
+	}
+}
+
+func TestPowerSince(t *testing.T) {
+	dir := func(ts int64, left, right int32, fwd bool) *pb.Record {
+		return &pb.Record{Timestamp: ts, Direction: &pb.Direction{}, LeftPower: left, RightPower: right, LeftForward: fwd, RightForward: fwd}
+	}
+	rs := []*pb.Record{
+		dir(10, 40, 40, true),
+		dir(20, 40, 40, true),
+		dir(30, 40, 0, true),
+		dir(40, 40, 40, false),
+		dir(50, 40, 40, false),
+	}
+	for _, tc := range []struct {
+		ts, want int64
+	}{
+		{5, 5},
+		{10, 10},
+		{25, 10},
+		{30, 30},
+		{45, 40},
+		{99, 40},
+	} {
+		if got := powerSince(rs, tc.ts); got != tc.want {
+			t.Errorf("powerSince(%d) = %d, want %d", tc.ts, got, tc.want)
+		}
+	}
+}
+
+func TestRampingTo(t *testing.T) {
+	for _, tc := range []struct {
+		pwr, want int32
+		ok        bool
+	}{
+		{0, 40, true},
+		{0, -40, true},
+		{20, 40, true},
+		{-20, -40, true},
+		{40, 40, false}, // Equal powers are compared as such.
+		{60, 40, false},
+		{20, -40, false},
+		{-20, 40, false},
+		{20, 0, false},
+	} {
+		if got := rampingTo(tc.pwr, tc.want); got != tc.ok {
+			t.Errorf("rampingTo(%d, %d) = %v, want %v", tc.pwr, tc.want, got, tc.ok)
+		}
+	}
+}
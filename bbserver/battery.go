@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"github.com/kidoman/embd"
+	log "github.com/sirupsen/logrus"
+)
+
+// ADS1115 registers and single-shot config reading AIN0 with ±6.144V range.
+const (
+	adsDefaultAddr   = 0x48
+	adsRegConversion = 0x00
+	adsRegConfig     = 0x01
+	adsConfigHi      = 0xC1
+	adsConfigLo      = 0x83
+	adsVoltsPerLSB   = 6.144 / 32768
+)
+
+const (
+	defaultBatteryDur = time.Second
+	batteryLowPower   = 40   // Power limit when battery is low, half PWM duty.
+	batteryHysteresis = 0.05 // Volts per cell above threshold to leave a state.
+	batterySmoothing  = 0.2  // Weight of new reading, voltage sags under load.
+	batteryRecharged  = 0.2  // Voltage jump in volts treated as new pack.
+	batteryEstimateAt = 2.0  // Minimum percent drop before estimating time left.
+)
+
+// LiPo single cell voltage to charge percentage, interpolated in between.
+var cellCurve = []struct {
+	volts   float64
+	percent float64
+}{
+	{3.27, 0}, {3.61, 5}, {3.69, 10}, {3.71, 15}, {3.73, 20}, {3.75, 30},
+	{3.77, 40}, {3.79, 50}, {3.82, 60}, {3.87, 70}, {3.93, 80}, {4.00, 90},
+	{4.20, 100},
+}
+
+func cellPercent(v float64) float64 {
+	if v <= cellCurve[0].volts {
+		return 0
+	}
+	for i := 1; i < len(cellCurve); i++ {
+		lo, hi := cellCurve[i-1], cellCurve[i]
+		if v < hi.volts {
+			return lo.percent + (v-lo.volts)/(hi.volts-lo.volts)*(hi.percent-lo.percent)
+		}
+	}
+	return 100
+}
+
+// Battery pack voltage monitor using ADS1115 ADC on I2C bus, connected through
+// a voltage divider.
+type battery struct {
+	bus         embd.I2CBus
+	addr        byte
+	divider     float64 // Pack voltage to ADC input voltage ratio.
+	cells       int
+	low, cutoff float64 // Pack voltage thresholds.
+	waitc       chan struct{}
+	send        chan bool
+	onState     func(pb.BatteryState)
+	health      *subsystem
+
+	mu        sync.Mutex
+	volts     float64
+	percent   float64
+	minutes   float64
+	state     pb.BatteryState
+	since     time.Time // Start of current discharge estimate.
+	sincePerc float64
+}
+
+func newBattery(bus embd.I2CBus, addr byte, divider float64, cells int, low, cutoff float64) (*battery, error) {
+	if cells <= 0 {
+		return nil, fmt.Errorf("battery must have at least one cell, got %d", cells)
+	}
+	if !(divider > 0) {
+		return nil, fmt.Errorf("voltage divider ratio %v must be positive", divider)
+	}
+	if cutoff >= low {
+		return nil, fmt.Errorf("cutoff voltage %.2fV must be below low voltage %.2fV", cutoff, low)
+	}
+	var b battery
+	b.bus = bus
+	b.addr = addr
+	b.divider = divider
+	b.cells = cells
+	b.low = low
+	b.cutoff = cutoff
+	b.waitc = make(chan struct{})
+	b.send = make(chan bool)
+	b.health = newSubsystem("battery", defaultBatteryDur*3)
+	return &b, nil
+}
+
+// Start single-shot conversion and read pack voltage.
+func (b *battery) read() (float64, error) {
+	if err := b.bus.WriteToReg(b.addr, adsRegConfig, []byte{adsConfigHi, adsConfigLo}); err != nil {
+		return 0, fmt.Errorf("can't start ADC conversion: %v", err)
+	}
+	time.Sleep(time.Millisecond * 10) // Conversion takes ~8ms at 128SPS.
+	buf := make([]byte, 2)
+	if err := b.bus.ReadFromReg(b.addr, adsRegConversion, buf); err != nil {
+		return 0, fmt.Errorf("can't read ADC: %v", err)
+	}
+	raw := int16(binary.BigEndian.Uint16(buf))
+	return float64(raw) * adsVoltsPerLSB * b.divider, nil
+}
+
+// Goroutine measuring battery voltage in an infinite loop.
+func (b *battery) runBattery() {
+	ticker := time.NewTicker(defaultBatteryDur)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.waitc:
+			return
+		case now := <-ticker.C:
+			v, err := b.read()
+			if err != nil {
+				log.Warn(err)
+				b.health.fail(err)
+				continue
+			}
+			b.health.ok()
+			b.update(v, now)
+		}
+	}
+}
+
+func (b *battery) update(v float64, now time.Time) {
+	b.mu.Lock()
+	if b.volts == 0 || v-b.volts > batteryRecharged {
+		b.volts = v
+		b.since = time.Time{}
+	} else {
+		b.volts += batterySmoothing * (v - b.volts)
+	}
+	b.percent = cellPercent(b.volts / float64(b.cells))
+
+	// Estimate time left from average discharge rate since start.
+	if b.since.IsZero() {
+		b.since = now
+		b.sincePerc = b.percent
+		b.minutes = 0
+	}
+	if used := b.sincePerc - b.percent; used >= batteryEstimateAt {
+		b.minutes = b.percent / used * now.Sub(b.since).Minutes()
+	}
+
+	// Voltage recovers a bit once motors stop, so leaving low states takes a
+	// margin above threshold, otherwise the state would flap around it.
+	prev := b.state
+	margin := batteryHysteresis * float64(b.cells)
+	switch {
+	case b.volts < b.cutoff,
+		prev == pb.BatteryState_BATTERY_EMPTY && b.volts < b.cutoff+margin:
+		b.state = pb.BatteryState_BATTERY_EMPTY
+	case b.volts < b.low,
+		(prev == pb.BatteryState_BATTERY_LOW || prev == pb.BatteryState_BATTERY_EMPTY) && b.volts < b.low+margin:
+		b.state = pb.BatteryState_BATTERY_LOW
+	default:
+		b.state = pb.BatteryState_BATTERY_OK
+	}
+	state := b.state
+	b.mu.Unlock()
+
+	if state == prev {
+		return
+	}
+	log.Warnf("Battery: state changed from %s to %s at %.2fV", prev, state, v)
+	if b.onState != nil {
+		b.onState(state)
+	}
+	select {
+	case b.send <- true:
+	default:
+	}
+}
+
+// batteryChanged stops or caps engines as battery drains, under the same lock
+// as drive.
+func (s *server) batteryChanged(st pb.BatteryState) {
+	s.driveMu.Lock()
+	defer s.driveMu.Unlock()
+	switch st {
+	case pb.BatteryState_BATTERY_EMPTY:
+		s.driver.stop()
+		s.cmd = cmdStop
+		log.Warn("Battery empty, motors stopped")
+	case pb.BatteryState_BATTERY_LOW:
+		s.driver.setPowerLimit(batteryLowPower)
+		log.Warnf("Battery low, power limited to %d", batteryLowPower)
+	default:
+		s.driver.setPowerLimit(0)
+	}
+}
+
+func (b *battery) State() pb.BatteryState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *battery) fill(t *pb.Telemetry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t.BatteryVoltage = float32(b.volts)
+	t.BatteryPercent = int32(b.percent)
+	t.BatteryMinutes = int32(b.minutes)
+	t.BatteryState = b.state
+}
+
+// close stops monitoring. The bus is shared with other devices and closed
+// in main.
+func (b *battery) close() {
+	close(b.waitc)
+}
+
+// Fake ADS1115 on a fake I2C bus, used to run bbserver off-hardware. It
+// reports a pack draining linearly from full to empty in given time.
+type fakeADS struct {
+	embd.I2CBus
+
+	start       time.Time
+	full, empty float64 // Volts at the ADC input.
+	drain       time.Duration
+}
+
+func newFakeADS(cells int, divider float64, drain time.Duration) *fakeADS {
+	return &fakeADS{
+		start: time.Now(),
+		full:  cellCurve[len(cellCurve)-1].volts * float64(cells) / divider,
+		empty: cellCurve[0].volts * float64(cells) / divider,
+		drain: drain,
+	}
+}
+
+func (f *fakeADS) ReadFromReg(addr, reg byte, value []byte) error {
+	if reg != adsRegConversion || len(value) != 2 {
+		return fmt.Errorf("fake I2C: can't read %d bytes from register 0x%x", len(value), reg)
+	}
+	used := float64(time.Since(f.start)) / float64(f.drain)
+	if used > 1 {
+		used = 1
+	}
+	v := f.full - (f.full-f.empty)*used
+	binary.BigEndian.PutUint16(value, uint16(int16(v/adsVoltsPerLSB)))
+	return nil
+}
+
+func (f *fakeADS) WriteToReg(addr, reg byte, value []byte) error {
+	if reg != adsRegConfig {
+		return fmt.Errorf("fake I2C: can't write register 0x%x", reg)
+	}
+	return nil
+}
+
+func (f *fakeADS) Close() error { return nil }
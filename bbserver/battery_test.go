@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+func TestCellPercent(t *testing.T) {
+	for _, tc := range []struct {
+		volts, want float64
+	}{
+		{0, 0},
+		{3.0, 0},
+		{3.27, 0},
+		{3.44, 2.5},
+		{3.69, 10},
+		{3.76, 35},
+		{3.79, 50},
+		{4.1, 95},
+		{4.2, 100},
+		{4.35, 100},
+	} {
+		if got := cellPercent(tc.volts); math.Abs(got-tc.want) > 0.01 {
+			t.Errorf("cellPercent(%.2f) = %.2f, want %.2f", tc.volts, got, tc.want)
+		}
+	}
+}
+
+func TestCellPercentMonotonic(t *testing.T) {
+	prev := 0.0
+	for v := 3.0; v < 4.4; v += 0.005 {
+		p := cellPercent(v)
+		if p < prev {
+			t.Fatalf("cellPercent(%.3f) = %.2f, below %.2f at lower voltage", v, p, prev)
+		}
+		prev = p
+	}
+}
+
+// setPack makes fake ADC read given pack voltage.
+func setPack(f *fakeADS, divider, v float64) {
+	used := (f.full - v/divider) / (f.full - f.empty)
+	f.start = time.Now().Add(-time.Duration(used * float64(f.drain)))
+}
+
+func TestBatteryRead(t *testing.T) {
+	f := newFakeADS(2, 2, time.Hour)
+	b, err := newBattery(f, adsDefaultAddr, 2, 2, 7.0, 6.6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []float64{8.4, 7.6, 6.6} {
+		setPack(f, 2, want)
+		v, err := b.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(v-want) > 0.01 {
+			t.Errorf("read %.3fV, want %.3fV", v, want)
+		}
+	}
+}
+
+func TestBatteryStates(t *testing.T) {
+	f := newFakeADS(2, 2, time.Hour)
+	b, err := newBattery(f, adsDefaultAddr, 2, 2, 7.0, 6.6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []pb.BatteryState
+	b.onState = func(s pb.BatteryState) { events = append(events, s) }
+
+	// Hysteresis is 0.1V for two cells.
+	for _, tc := range []struct {
+		volts float64
+		want  pb.BatteryState
+	}{
+		{7.5, pb.BatteryState_BATTERY_OK},
+		{6.95, pb.BatteryState_BATTERY_LOW},
+		{7.05, pb.BatteryState_BATTERY_LOW},
+		{7.15, pb.BatteryState_BATTERY_OK},
+		{6.5, pb.BatteryState_BATTERY_EMPTY},
+		{6.65, pb.BatteryState_BATTERY_EMPTY},
+		{6.75, pb.BatteryState_BATTERY_LOW},
+		{6.95, pb.BatteryState_BATTERY_LOW},
+		{7.2, pb.BatteryState_BATTERY_OK},
+	} {
+		setPack(f, 2, tc.volts)
+		// Let smoothed voltage settle.
+		for i := 0; i < 20; i++ {
+			v, err := b.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.update(v, time.Now())
+		}
+		if got := b.State(); got != tc.want {
+			t.Errorf("at %.2fV state %s, want %s", tc.volts, got, tc.want)
+		}
+	}
+	want := []pb.BatteryState{
+		pb.BatteryState_BATTERY_OK,
+		pb.BatteryState_BATTERY_LOW,
+		pb.BatteryState_BATTERY_OK,
+		pb.BatteryState_BATTERY_LOW,
+		pb.BatteryState_BATTERY_EMPTY,
+		pb.BatteryState_BATTERY_LOW,
+		pb.BatteryState_BATTERY_OK,
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("state changes %v, want %v", events, want)
+	}
+}
+
+func TestBatteryNoise(t *testing.T) {
+	b, err := newBattery(newFakeADS(2, 2, time.Hour), adsDefaultAddr, 2, 2, 7.0, 6.6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := 0
+	b.onState = func(pb.BatteryState) { changes++ }
+	// Readings jumping around low threshold don't flap the state.
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		v := 6.96
+		if i%2 == 1 {
+			v = 7.04
+		}
+		b.update(v, now)
+		now = now.Add(defaultBatteryDur)
+	}
+	if changes != 1 {
+		t.Errorf("%d state changes, want just the first", changes)
+	}
+}
+
+func TestNewBatteryArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		divider     float64
+		cells       int
+		low, cutoff float64
+		ok          bool
+	}{
+		{"2S", 2, 2, 7.0, 6.6, true},
+		{"1S without divider", 1, 1, 3.5, 3.3, true},
+		{"cutoff above low", 2, 2, 6.6, 7.0, false},
+		{"no cells", 2, 0, 7.0, 6.6, false},
+		{"negative cells", 2, -2, 7.0, 6.6, false},
+		{"zero divider", 0, 2, 7.0, 6.6, false},
+		{"negative divider", -2, 2, 7.0, 6.6, false},
+		{"NaN divider", math.NaN(), 2, 7.0, 6.6, false},
+	} {
+		_, err := newBattery(newFakeADS(2, 2, time.Hour), adsDefaultAddr, tc.divider, tc.cells, tc.low, tc.cutoff)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: newBattery error %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestBatteryChanged(t *testing.T) {
+	s := &server{driver: &driver{left: testEngine("left"), right: testEngine("right")}}
+	e := s.driver.left
+	e.set(100, true)
+	s.batteryChanged(pb.BatteryState_BATTERY_LOW)
+	if e.pwr != batteryLowPower {
+		t.Errorf("running engine at %d on low battery, want %d", e.pwr, batteryLowPower)
+	}
+	e.set(100, true)
+	if e.pwr != batteryLowPower {
+		t.Errorf("engine set to %d on low battery, want %d", e.pwr, batteryLowPower)
+	}
+	s.batteryChanged(pb.BatteryState_BATTERY_EMPTY)
+	if e.pwr != 0 || s.cmd != cmdStop {
+		t.Errorf("engine at %d, command %s on empty battery, want stopped", e.pwr, s.cmd)
+	}
+	s.batteryChanged(pb.BatteryState_BATTERY_OK)
+	e.set(100, true)
+	if e.pwr != 100 {
+		t.Errorf("engine set to %d after charging, want 100", e.pwr)
+	}
+}
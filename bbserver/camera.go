@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"github.com/blackjack/webcam"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// frameSource captures JPEG frames.
+type frameSource interface {
+	// read blocks until the next frame is captured.
+	read() ([]byte, error)
+	// still captures a frame at full resolution.
+	still() ([]byte, error)
+	close() error
+}
+
+// V4L2 pixel formats of compressed frames, in order of preference.
+var v4l2Formats = []webcam.PixelFormat{
+	0x47504a4d, // MJPG
+	0x4745504a, // JPEG
+}
+
+// Frames skipped after switching to full resolution, while auto exposure
+// settles.
+const stillSkip = 5
+
+// v4l2Source captures frames from V4L2 camera, e.g. USB webcam or Raspberry
+// Pi camera with bcm2835-v4l2 driver.
+type v4l2Source struct {
+	path          string
+	width, height uint32
+	rate          float64
+	format        webcam.PixelFormat
+	cam           *webcam.Webcam
+}
+
+// checkCameraSize returns error unless frame size and rate are positive.
+// Sources would otherwise fail later with an obscure error or spin without
+// any delay between frames.
+func checkCameraSize(width, height int, rate float64) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("frame size %dx%d isn't positive", width, height)
+	}
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		return fmt.Errorf("frame rate %v isn't a positive number", rate)
+	}
+	return nil
+}
+
+func newV4L2Source(path string, width, height int, rate float64) (*v4l2Source, error) {
+	v := &v4l2Source{path: path, width: uint32(width), height: uint32(height), rate: rate}
+	if err := v.open(v.width, v.height); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// open starts streaming frames of given size. Buffers of running stream can't
+// be resized, so changing size takes closing and opening the device again.
+func (v *v4l2Source) open(width, height uint32) error {
+	cam, err := webcam.Open(v.path)
+	if err != nil {
+		return fmt.Errorf("can't open camera: %v", err)
+	}
+	if v.format == 0 {
+		supported := cam.GetSupportedFormats()
+		for _, f := range v4l2Formats {
+			if _, ok := supported[f]; ok {
+				v.format = f
+				break
+			}
+		}
+		if v.format == 0 {
+			cam.Close()
+			return fmt.Errorf("camera supports neither MJPEG nor JPEG, only %v", supported)
+		}
+	}
+	if _, _, _, err := cam.SetImageFormat(v.format, width, height); err != nil {
+		cam.Close()
+		return fmt.Errorf("can't set camera format: %v", err)
+	}
+	if err := cam.SetFramerate(float32(v.rate)); err != nil {
+		// Many cameras have fixed rate, frames are dropped to the rate then.
+		log.Warnf("Can't set camera frame rate: %v", err)
+	}
+	if err := cam.StartStreaming(); err != nil {
+		cam.Close()
+		return fmt.Errorf("can't start camera: %v", err)
+	}
+	v.cam = cam
+	return nil
+}
+
+func (v *v4l2Source) still() ([]byte, error) {
+	if v.cam == nil {
+		if err := v.open(v.width, v.height); err != nil {
+			return nil, err
+		}
+	}
+	var width, height uint32
+	for _, s := range v.cam.GetSupportedFrameSizes(v.format) {
+		if s.MaxWidth*s.MaxHeight > width*height {
+			width, height = s.MaxWidth, s.MaxHeight
+		}
+	}
+	v.cam.Close()
+	v.cam = nil
+	defer func() {
+		if v.cam != nil {
+			v.cam.Close()
+		}
+		// Failed restart is retried by read.
+		v.cam = nil
+		if err := v.open(v.width, v.height); err != nil {
+			log.Errorf("Can't restart camera after photo: %v", err)
+		}
+	}()
+	if err := v.open(width, height); err != nil {
+		return nil, err
+	}
+	var b []byte
+	for i := 0; i <= stillSkip; i++ {
+		var err error
+		if b, err = v.read(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (v *v4l2Source) read() ([]byte, error) {
+	if v.cam == nil {
+		if err := v.open(v.width, v.height); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.cam.WaitForFrame(uint32(cameraTimeout.Seconds())); err != nil {
+		return nil, fmt.Errorf("can't wait for frame: %v", err)
+	}
+	b, err := v.cam.ReadFrame()
+	if err != nil {
+		return nil, fmt.Errorf("can't read frame: %v", err)
+	}
+	// Buffer is reused by the driver.
+	return fixMJPEG(append([]byte(nil), b...)), nil
+}
+
+func (v *v4l2Source) close() error {
+	if v.cam == nil {
+		return nil
+	}
+	err := v.cam.Close()
+	v.cam = nil
+	return err
+}
+
+// Huffman tables segment of JPEG files written by image/jpeg, which uses the
+// standard tables from JPEG specification, same as MJPEG assumes.
+var dhtSegment = func() []byte {
+	var b bytes.Buffer
+	jpeg.Encode(&b, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil)
+	seg, _ := jpegSegment(b.Bytes(), 0xc4)
+	return seg
+}()
+
+// jpegSegment returns segment with marker m, or if there is none, offset of
+// start of scan, before which missing segment can be inserted.
+func jpegSegment(b []byte, m byte) ([]byte, int) {
+	for i := 2; i+4 <= len(b) && b[i] == 0xff; {
+		n := int(b[i+2])<<8 | int(b[i+3])
+		switch {
+		case b[i+1] == m && i+2+n <= len(b):
+			return b[i : i+2+n], i
+		case b[i+1] == 0xda:
+			return nil, i
+		}
+		i += 2 + n
+	}
+	return nil, -1
+}
+
+// fixMJPEG adds Huffman tables left out of MJPEG frames by many USB cameras,
+// without which frames aren't valid JPEG images.
+func fixMJPEG(b []byte) []byte {
+	seg, sos := jpegSegment(b, 0xc4)
+	if seg != nil || sos < 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b)+len(dhtSegment))
+	out = append(out, b[:sos]...)
+	out = append(out, dhtSegment...)
+	return append(out, b[sos:]...)
+}
+
+// fakeSource generates moving test pattern, for running off-hardware. Its
+// full resolution is double the video size.
+type fakeSource struct {
+	img, full *image.RGBA
+	period    time.Duration
+	next      time.Time
+	n         int
+}
+
+func newFakeSource(width, height int, rate float64) *fakeSource {
+	return &fakeSource{
+		img:    image.NewRGBA(image.Rect(0, 0, width, height)),
+		full:   image.NewRGBA(image.Rect(0, 0, width*2, height*2)),
+		period: time.Duration(float64(time.Second) / rate),
+		next:   time.Now(),
+	}
+}
+
+func (f *fakeSource) read() ([]byte, error) {
+	time.Sleep(time.Until(f.next))
+	f.next = f.next.Add(f.period)
+	return f.draw(f.img)
+}
+
+func (f *fakeSource) still() ([]byte, error) {
+	return f.draw(f.full)
+}
+
+// draw renders gradient with bar sweeping across, so frozen video is easy to
+// spot.
+func (f *fakeSource) draw(img *image.RGBA) ([]byte, error) {
+	b := img.Bounds()
+	bar := f.n * b.Dx() / f.img.Bounds().Dx() % b.Dx()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.RGBA{uint8(x * 255 / b.Dx()), uint8(y * 255 / b.Dy()), 0x80, 0xff}
+			if x >= bar && x < bar+b.Dx()/16 {
+				c = color.RGBA{0xff, 0xff, 0xff, 0xff}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	f.n += 4
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, fmt.Errorf("can't encode frame: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (f *fakeSource) close() error {
+	return nil
+}
+
+const cameraTimeout = time.Second * 2
+
+// camera captures frames from source in the background and hands the latest
+// one to any number of watchers.
+type camera struct {
+	srcMu  sync.Mutex // Serializes video, photos and closing.
+	src    frameSource
+	health *subsystem
+
+	mu    sync.Mutex
+	frame *pb.Frame
+	fresh chan struct{} // Closed when new frame arrives.
+	done  bool
+}
+
+func newCamera(src frameSource) *camera {
+	return &camera{
+		src:    src,
+		health: newSubsystem("camera", cameraTimeout),
+		fresh:  make(chan struct{}),
+	}
+}
+
+func (c *camera) run() {
+	for {
+		c.srcMu.Lock()
+		if c.closed() {
+			c.srcMu.Unlock()
+			return
+		}
+		b, err := c.src.read()
+		c.srcMu.Unlock()
+		if c.closed() {
+			return
+		}
+		if err != nil {
+			c.health.fail(err)
+			log.Warnf("Camera: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		f := &pb.Frame{Timestamp: time.Now().UnixNano(), Jpeg: b}
+		if cfg, err := jpeg.DecodeConfig(bytes.NewReader(b)); err == nil {
+			f.Width, f.Height = int32(cfg.Width), int32(cfg.Height)
+		}
+		c.health.ok()
+		c.mu.Lock()
+		c.frame = f
+		close(c.fresh)
+		c.fresh = make(chan struct{})
+		c.mu.Unlock()
+	}
+}
+
+// next waits for frame captured after time given in Unix nanoseconds.
+func (c *camera) next(ctx context.Context, after int64) (*pb.Frame, error) {
+	for {
+		c.mu.Lock()
+		f, fresh := c.frame, c.fresh
+		c.mu.Unlock()
+		if f != nil && f.Timestamp > after {
+			return f, nil
+		}
+		select {
+		case <-fresh:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// still captures full resolution frame, pausing video meanwhile.
+func (c *camera) still() ([]byte, error) {
+	c.srcMu.Lock()
+	defer c.srcMu.Unlock()
+	if c.closed() {
+		return nil, fmt.Errorf("camera is closed")
+	}
+	return c.src.still()
+}
+
+func (c *camera) closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done
+}
+
+// close stops capturing, waiting for frame being read.
+func (c *camera) close() {
+	c.mu.Lock()
+	c.done = true
+	c.mu.Unlock()
+	c.srcMu.Lock()
+	defer c.srcMu.Unlock()
+	c.src.close()
+}
+
+func (s *server) GetImage(in *pb.Image, stream pb.Driver_GetImageServer) error {
+	if s.camera == nil {
+		return status.Errorf(codes.Unavailable, "no camera, see -camera")
+	}
+	rate := *camRate
+	if in.Rate > 0 && float64(in.Rate) < rate {
+		rate = float64(in.Rate)
+	}
+	period := time.Duration(float64(time.Second) / rate)
+	ctx := stream.Context()
+	var last int64
+	next := time.Now()
+	for {
+		f, err := s.camera.next(ctx, last)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(f); err != nil {
+			return err
+		}
+		if !in.Live {
+			return nil
+		}
+		last = f.Timestamp
+		// Frames coming faster than the rate are dropped.
+		next = next.Add(period)
+		if now := time.Now(); next.Before(now) {
+			next = now
+		}
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
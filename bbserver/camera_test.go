@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestCheckCameraSize(t *testing.T) {
+	for _, tc := range []struct {
+		w, h int
+		rate float64
+		ok   bool
+	}{
+		{640, 480, 10, true},
+		{1, 1, 0.5, true},
+		{0, 480, 10, false},
+		{640, -1, 10, false},
+		{640, 480, 0, false},
+		{640, 480, -5, false},
+		{640, 480, math.NaN(), false},
+		{640, 480, math.Inf(1), false},
+	} {
+		if err := checkCameraSize(tc.w, tc.h, tc.rate); (err == nil) != tc.ok {
+			t.Errorf("checkCameraSize(%d, %d, %v) = %v, want ok %v", tc.w, tc.h, tc.rate, err, tc.ok)
+		}
+	}
+}
+
+// testJPEG returns a small JPEG image and the same image without Huffman
+// tables, like MJPEG frames of USB cameras.
+func testJPEG(t *testing.T) (full, mjpeg []byte) {
+	t.Helper()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+	full = b.Bytes()
+	seg, i := jpegSegment(full, 0xc4)
+	if seg == nil {
+		t.Fatal("encoded JPEG has no Huffman tables")
+	}
+	mjpeg = append(append([]byte{}, full[:i]...), full[i+len(seg):]...)
+	return full, mjpeg
+}
+
+func TestJPEGSegment(t *testing.T) {
+	full, mjpeg := testJPEG(t)
+	for _, tc := range []struct {
+		name   string
+		b      []byte
+		m      byte
+		found  bool
+		offset bool // Offset of segment or start of scan is returned.
+	}{
+		{"tables", full, 0xc4, true, true},
+		{"quantization", full, 0xdb, true, true},
+		{"missing tables", mjpeg, 0xc4, false, true},
+		{"empty", nil, 0xc4, false, false},
+		{"just SOI", full[:2], 0xc4, false, false},
+		{"not JPEG", []byte("GIF89a, definitely not a JPEG"), 0xc4, false, false},
+		{"truncated header", full[:20], 0xc4, false, false},
+	} {
+		seg, i := jpegSegment(tc.b, tc.m)
+		if (seg != nil) != tc.found || (i >= 0) != tc.offset {
+			t.Errorf("%s: jpegSegment found %v at %d, want found %v, offset %v", tc.name, seg != nil, i, tc.found, tc.offset)
+		}
+		if seg != nil && (seg[0] != 0xff || seg[1] != tc.m) {
+			t.Errorf("%s: segment starts with % x, want ff %x", tc.name, seg[:2], tc.m)
+		}
+	}
+}
+
+func TestFixMJPEG(t *testing.T) {
+	full, mjpeg := testJPEG(t)
+	if _, err := jpeg.Decode(bytes.NewReader(mjpeg)); err == nil {
+		t.Fatal("frame without Huffman tables decodes, test is broken")
+	}
+	for _, tc := range []struct {
+		name    string
+		b       []byte
+		changed bool
+	}{
+		{"complete", full, false},
+		{"mjpeg", mjpeg, true},
+		{"not JPEG", []byte("not a JPEG"), false},
+		{"empty", nil, false},
+	} {
+		got := fixMJPEG(tc.b)
+		if changed := !bytes.Equal(got, tc.b); changed != tc.changed {
+			t.Errorf("%s: fixMJPEG changed frame %v, want %v", tc.name, changed, tc.changed)
+		}
+		if !tc.changed {
+			continue
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+			t.Errorf("%s: fixed frame doesn't decode: %v", tc.name, err)
+		}
+	}
+}
+
+// closeSource notes reads racing with closing, which crash V4L2 devices.
+type closeSource struct {
+	mu      sync.Mutex
+	reading bool
+	closed  bool
+	races   int
+}
+
+func (s *closeSource) read() ([]byte, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.races++
+	}
+	s.reading = true
+	s.mu.Unlock()
+	time.Sleep(time.Millisecond * 5)
+	s.mu.Lock()
+	s.reading = false
+	s.mu.Unlock()
+	return []byte("frame"), nil
+}
+
+func (s *closeSource) still() ([]byte, error) {
+	return s.read()
+}
+
+func (s *closeSource) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reading {
+		s.races++
+	}
+	s.closed = true
+	return nil
+}
+
+func TestCameraClose(t *testing.T) {
+	src := &closeSource{}
+	c := newCamera(src)
+	go c.run()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.next(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.close()
+	time.Sleep(time.Millisecond * 20)
+	src.mu.Lock()
+	races := src.races
+	src.mu.Unlock()
+	if races != 0 {
+		t.Errorf("source read %d times while closing or closed", races)
+	}
+	if _, err := c.still(); err == nil {
+		t.Error("photo taken with closed camera")
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Directions a client may send per second and in a burst. Apps send on every
+// touch move, well below it.
+const (
+	directionRate  = 50
+	directionBurst = 20
+)
+
+// directionGuard stands between drive stream of a client and driving. It
+// clamps values out of range, rejects invalid and stale directions and drops
+// floods, counting all the violations.
+type directionGuard struct {
+	driveStream
+	order  directionOrder
+	tokens float64
+	last   time.Time
+	counts map[string]int // Violations by kind.
+}
+
+func newDirectionGuard(stream driveStream) *directionGuard {
+	return &directionGuard{
+		driveStream: stream,
+		tokens:      directionBurst,
+		last:        time.Now(),
+		counts:      make(map[string]int),
+	}
+}
+
+// Recv returns the next direction fit for driving.
+func (g *directionGuard) Recv() (*pb.Direction, error) {
+	for {
+		d, err := g.driveStream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if fields := clampDirection(d); len(fields) > 0 {
+			g.violation("clamped", "Clamped %s of direction from client", strings.Join(fields, ", "))
+		}
+		if err := validateDirection(d); err != nil {
+			g.violation("invalid", "Rejected direction from client: %v", err)
+			continue
+		}
+		if g.order.stale(d) {
+			g.violation("stale", "Rejected direction %d from client after %d", d.Seq, g.order.seq)
+			continue
+		}
+		if !g.allow(d, now) {
+			g.violation("flood", "Client sends over %d directions per second, dropping them", directionRate)
+			continue
+		}
+		if l, ok := directionLatency(d, now); ok {
+			directionLatencies.Observe(l.Seconds())
+		}
+		return d, nil
+	}
+}
+
+// violation counts violation of given kind, logging only the first one of the
+// stream, as clients tend to repeat them many times per second.
+func (g *directionGuard) violation(kind, format string, args ...interface{}) {
+	directionViolations.WithLabelValues(kind).Inc()
+	if g.counts[kind] == 0 {
+		log.Warnf(format, args...)
+	}
+	g.counts[kind]++
+}
+
+// summary lists violation counts of the stream, empty if there were none.
+func (g *directionGuard) summary() string {
+	var kinds []string
+	for k, n := range g.counts {
+		kinds = append(kinds, fmt.Sprintf("%s %d", k, n))
+	}
+	sort.Strings(kinds)
+	return strings.Join(kinds, ", ")
+}
+
+// allow takes token for direction from bucket refilled at directionRate.
+// Stopping is never refused.
+func (g *directionGuard) allow(d *pb.Direction, now time.Time) bool {
+	g.tokens += now.Sub(g.last).Seconds() * directionRate
+	if g.tokens > directionBurst {
+		g.tokens = directionBurst
+	}
+	g.last = now
+	stop := d.Dx == 0 && d.Dy == 0 && d.Left == 0 && d.Right == 0
+	if g.tokens < 1 && !stop {
+		return false
+	}
+	if g.tokens >= 1 {
+		g.tokens--
+	}
+	return true
+}
+
+// clampDirection brings values of d into their ranges and returns names of
+// fields which were out of them. Extremes like math.MinInt32 would otherwise
+// overflow on the way to engine power.
+func clampDirection(d *pb.Direction) []string {
+	var fields []string
+	clamp := func(name string, v *int32, min, max int32) {
+		switch {
+		case *v < min:
+			*v = min
+		case *v > max:
+			*v = max
+		default:
+			return
+		}
+		fields = append(fields, name)
+	}
+	clamp("dx", &d.Dx, -100, 100)
+	clamp("dy", &d.Dy, -100, 100)
+	clamp("left", &d.Left, -100, 100)
+	clamp("right", &d.Right, -100, 100)
+	clamp("throttle", &d.Throttle, 0, 100)
+	if _, ok := pb.Modifier_name[int32(d.Modifier)]; !ok {
+		d.Modifier = pb.Modifier_MODIFIER_NONE
+		fields = append(fields, "modifier")
+	}
+	return fields
+}
+
+// validateDirection rejects directions which can't be made sense of, even
+// with values clamped.
+func validateDirection(d *pb.Direction) error {
+	switch {
+	case d.Tank && (d.Dx != 0 || d.Dy != 0):
+		return fmt.Errorf("tank direction with dx %d and dy %d", d.Dx, d.Dy)
+	case !d.Tank && (d.Left != 0 || d.Right != 0):
+		return fmt.Errorf("left %d and right %d without tank", d.Left, d.Right)
+	}
+	return nil
+}
+
+// power returns engine power for stick deflection v, scaled by throttle and
+// modifier of direction.
+func power(d *pb.Direction, v int32) int32 {
+	t := d.Throttle
+	if t == 0 || d.Modifier == pb.Modifier_MODIFIER_BOOST {
+		t = 100
+	}
+	if d.Modifier == pb.Modifier_MODIFIER_PRECISION {
+		t /= 2
+	}
+	return v * t / 100
+}
+
+// directionOrder spots directions of a stream arriving out of order. Clients
+// not numbering directions are never out of order.
+type directionOrder struct {
+	seq uint64 // The last sequence number seen.
+}
+
+// stale tells whether d comes after a newer direction.
+func (o *directionOrder) stale(d *pb.Direction) bool {
+	if d.Seq == 0 {
+		return false
+	}
+	if d.Seq <= o.seq {
+		return true
+	}
+	o.seq = d.Seq
+	return false
+}
+
+// directionLatency returns time since client sent d, as far as clocks of
+// client and bot agree, or false if client didn't tell.
+func directionLatency(d *pb.Direction, now time.Time) (time.Duration, bool) {
+	if d.Timestamp == 0 {
+		return 0, false
+	}
+	l := now.Sub(time.Unix(0, d.Timestamp))
+	return l, l >= 0
+}
+
+func abs(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
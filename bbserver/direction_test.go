@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+func FuzzDirection(f *testing.F) {
+	f.Add(int32(0), int32(80), int32(0), int32(0), int32(0), int32(0), false)
+	f.Add(int32(0), int32(0), int32(-50), int32(50), int32(60), int32(1), true)
+	f.Add(int32(math.MinInt32), int32(math.MaxInt32), int32(0), int32(0), int32(-1), int32(7), false)
+	f.Add(int32(30), int32(-101), int32(math.MinInt32), int32(200), int32(150), int32(2), true)
+	s := &server{
+		front:  &echo{name: "front"},
+		rear:   &echo{name: "rear", angle: 180},
+		driver: &driver{left: testEngine("left"), right: testEngine("right")},
+	}
+	f.Fuzz(func(t *testing.T, dx, dy, left, right, throttle, modifier int32, tank bool) {
+		d := &pb.Direction{Dx: dx, Dy: dy, Left: left, Right: right, Throttle: throttle, Modifier: pb.Modifier(modifier), Tank: tank}
+		clampDirection(d)
+		for _, v := range []int32{d.Dx, d.Dy, d.Left, d.Right} {
+			if v < -100 || v > 100 {
+				t.Fatalf("clamped direction %v has %d out of range", d, v)
+			}
+			if p := power(d, v); p < -100 || p > 100 {
+				t.Fatalf("power of %d in %v is %d", v, d, p)
+			}
+		}
+		if d.Throttle < 0 || d.Throttle > 100 {
+			t.Fatalf("clamped throttle %d", d.Throttle)
+		}
+		if fields := clampDirection(d); len(fields) > 0 {
+			t.Fatalf("clamping %v again changed %v", d, fields)
+		}
+		if validateDirection(d) != nil {
+			return
+		}
+		s.drive(d)
+		for _, e := range []*engine{s.driver.left, s.driver.right} {
+			if e.pwr < 0 || e.pwr > 100 {
+				t.Fatalf("%v drives %s engine at %d", d, e.name, e.pwr)
+			}
+		}
+	})
+}
+
+func TestClampDirection(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		in     pb.Direction
+		want   pb.Direction
+		fields int
+	}{
+		{"in range", pb.Direction{Dx: -100, Dy: 100, Throttle: 50}, pb.Direction{Dx: -100, Dy: 100, Throttle: 50}, 0},
+		{"extremes", pb.Direction{Dx: math.MinInt32, Dy: math.MaxInt32}, pb.Direction{Dx: -100, Dy: 100}, 2},
+		{"tank", pb.Direction{Tank: true, Left: 101, Right: -101}, pb.Direction{Tank: true, Left: 100, Right: -100}, 2},
+		{"throttle", pb.Direction{Dy: 50, Throttle: -5}, pb.Direction{Dy: 50}, 1},
+		{"modifier", pb.Direction{Dy: 50, Modifier: 99}, pb.Direction{Dy: 50}, 1},
+	} {
+		fields := clampDirection(&tc.in)
+		if len(fields) != tc.fields || tc.in.Dx != tc.want.Dx || tc.in.Dy != tc.want.Dy || tc.in.Left != tc.want.Left ||
+			tc.in.Right != tc.want.Right || tc.in.Throttle != tc.want.Throttle || tc.in.Modifier != tc.want.Modifier {
+			t.Errorf("%s: clamped to %v changing %v, want %v changing %d fields", tc.name, &tc.in, fields, &tc.want, tc.fields)
+		}
+	}
+}
+
+func TestDirectionFlood(t *testing.T) {
+	move := &pb.Direction{Dy: 80}
+	stop := &pb.Direction{}
+	start := time.Now()
+	g := newDirectionGuard(nil)
+	g.last = start
+	for _, tc := range []struct {
+		name  string
+		n     int // Directions sent at once.
+		d     *pb.Direction
+		after time.Duration // Since start.
+		want  int           // Allowed.
+	}{
+		{"burst", directionBurst, move, 0, directionBurst},
+		{"over burst", 5, move, 0, 0},
+		{"stops in flood", 5, stop, 0, 5},
+		{"refilled a bit", 5, move, time.Second / directionRate * 3, 3},
+		{"stops with empty bucket", 3, stop, time.Second / directionRate * 3, 3},
+		{"refilled", directionBurst + 5, move, time.Second * 2, directionBurst},
+	} {
+		allowed := 0
+		for i := 0; i < tc.n; i++ {
+			if g.allow(tc.d, start.Add(tc.after)) {
+				allowed++
+			}
+		}
+		if allowed != tc.want {
+			t.Errorf("%s: allowed %d of %d, want %d", tc.name, allowed, tc.n, tc.want)
+		}
+	}
+}
+
+func TestDirectionOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		seqs  []uint64
+		stale []bool
+	}{
+		{"in order", []uint64{1, 2, 3, 10}, []bool{false, false, false, false}},
+		{"unnumbered", []uint64{0, 0, 0}, []bool{false, false, false}},
+		{"repeated", []uint64{1, 2, 2}, []bool{false, false, true}},
+		{"reordered", []uint64{1, 3, 2, 4}, []bool{false, false, true, false}},
+		{"unnumbered between", []uint64{5, 0, 4, 6}, []bool{false, false, true, false}},
+		{"huge", []uint64{math.MaxUint64, 1}, []bool{false, true}},
+	} {
+		var o directionOrder
+		for i, seq := range tc.seqs {
+			if got := o.stale(&pb.Direction{Seq: seq}); got != tc.stale[i] {
+				t.Errorf("%s: direction %d stale %v, want %v", tc.name, seq, got, tc.stale[i])
+			}
+		}
+	}
+}
+
+// fakeDriveStream replays directions, then ends.
+type fakeDriveStream struct {
+	dirs []*pb.Direction
+}
+
+func (f *fakeDriveStream) Send(*pb.Telemetry) error { return nil }
+
+func (f *fakeDriveStream) Recv() (*pb.Direction, error) {
+	if len(f.dirs) == 0 {
+		return nil, io.EOF
+	}
+	d := f.dirs[0]
+	f.dirs = f.dirs[1:]
+	return d, nil
+}
+
+func TestDirectionGuard(t *testing.T) {
+	var dirs []*pb.Direction
+	for i := 1; i <= 60; i++ {
+		d := &pb.Direction{Dy: 60, Seq: uint64(i)}
+		switch {
+		case i%10 == 0:
+			d.Dy = 0
+		case i == 7:
+			d.Left = 50 // Without tank.
+		case i == 8:
+			d.Seq = 3
+		}
+		dirs = append(dirs, d)
+	}
+	g := newDirectionGuard(&fakeDriveStream{dirs: dirs})
+	var got []*pb.Direction
+	for {
+		d, err := g.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d)
+	}
+	stops := 0
+	for _, d := range got {
+		if d.Dy == 0 {
+			stops++
+		}
+	}
+	if stops != 6 {
+		t.Errorf("got %d stops, want all 6", stops)
+	}
+	if g.counts["invalid"] != 1 || g.counts["stale"] != 1 || g.counts["flood"] == 0 {
+		t.Errorf("violations %s, want invalid 1, stale 1 and some flood", g.summary())
+	}
+	if len(got)+g.counts["invalid"]+g.counts["stale"]+g.counts["flood"] != len(dirs) {
+		t.Errorf("passed %d directions with violations %s, want all %d accounted for", len(got), g.summary(), len(dirs))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/peer"
+)
+
+// estop is latched emergency stop. It's safe for concurrent use and its zero
+// value is not stopped.
+type estop struct {
+	mu      sync.Mutex
+	stopped bool
+	reason  string
+	since   time.Time
+}
+
+// set latches the stop, keeping the reason of the first request.
+func (e *estop) set(reason string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		return
+	}
+	e.stopped = true
+	e.reason = reason
+	e.since = time.Now()
+}
+
+func (e *estop) clear() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = false
+	e.reason = ""
+	e.since = time.Time{}
+}
+
+func (e *estop) active() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stopped
+}
+
+func (e *estop) state() *pb.EmergencyStopState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := &pb.EmergencyStopState{Stopped: e.stopped, Reason: e.reason}
+	if e.stopped {
+		st.Since = e.since.UnixNano()
+	}
+	return st
+}
+
+func (e *estop) fill(t *pb.Telemetry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	t.EmergencyStop = e.stopped
+	t.EmergencyStopReason = e.reason
+}
+
+// emergencyStop latches the stop, stops motors and ends autonomous mode.
+func (s *server) emergencyStop(reason string) {
+	s.driveMu.Lock()
+	s.estop.set(reason)
+	s.driver.stop()
+	s.driveMu.Unlock()
+	s.setMode(pb.Mode_MANUAL)
+	log.Warnf("Emergency stop: %s", reason)
+}
+
+// clearEmergencyStop releases the stop, requested by from.
+func (s *server) clearEmergencyStop(from string) {
+	s.estop.clear()
+	log.Infof("Emergency stop cleared (from %s)", from)
+}
+
+// clientAddr returns address of gRPC client calling with ctx.
+func clientAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+func (s *server) EmergencyStop(ctx context.Context, in *pb.EmergencyStopRequest) (*pb.EmergencyStopState, error) {
+	reason := in.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+	s.emergencyStop(fmt.Sprintf("%s (from %s)", reason, clientAddr(ctx)))
+	return s.estop.state(), nil
+}
+
+func (s *server) ClearEmergencyStop(ctx context.Context, in *pb.ClearEmergencyStopRequest) (*pb.EmergencyStopState, error) {
+	s.clearEmergencyStop(clientAddr(ctx))
+	return s.estop.state(), nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// subsystem tracks health of a single part of the bot, e.g. front echo, from
+// its successes and failures. It's serving when it succeeded recently and
+// didn't fail since.
+type subsystem struct {
+	name  string
+	stale time.Duration // Time without success after which it's not serving, 0 never goes stale.
+
+	mu      sync.Mutex
+	lastOK  time.Time
+	lastErr error
+	errAt   time.Time
+}
+
+func newSubsystem(name string, stale time.Duration) *subsystem {
+	return &subsystem{name: name, stale: stale}
+}
+
+func (s *subsystem) ok() {
+	s.mu.Lock()
+	s.lastOK = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *subsystem) fail(err error) {
+	s.mu.Lock()
+	s.lastErr = err
+	s.errAt = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *subsystem) serving(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastOK.IsZero() || s.errAt.After(s.lastOK) {
+		return false
+	}
+	return s.stale == 0 || now.Sub(s.lastOK) < s.stale
+}
+
+func (s *subsystem) status(now time.Time) *pb.SubsystemStatus {
+	st := &pb.SubsystemStatus{Name: s.name, Serving: s.serving(now)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.lastOK.IsZero() {
+		st.LastOk = s.lastOK.UnixNano()
+	}
+	if s.lastErr != nil {
+		st.LastError = s.lastErr.Error()
+		st.LastErrorTime = s.errAt.UnixNano()
+	}
+	return st
+}
+
+const defaultHealthDur = time.Second
+
+// healthCheck publishes subsystem health through standard gRPC health service,
+// with subsystem names as service names and empty name for the whole bot.
+type healthCheck struct {
+	srv        *health.Server
+	subsystems []*subsystem
+}
+
+func newHealthCheck(subsystems ...*subsystem) *healthCheck {
+	return &healthCheck{srv: health.NewServer(), subsystems: subsystems}
+}
+
+// Goroutine updating health service statuses in an infinite loop.
+func (h *healthCheck) run() {
+	ticker := time.NewTicker(defaultHealthDur)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		h.update(now)
+	}
+}
+
+func (h *healthCheck) update(now time.Time) {
+	all := healthpb.HealthCheckResponse_SERVING
+	for _, s := range h.subsystems {
+		st := healthpb.HealthCheckResponse_SERVING
+		if !s.serving(now) {
+			st = healthpb.HealthCheckResponse_NOT_SERVING
+			all = st
+		}
+		h.srv.SetServingStatus(s.name, st)
+	}
+	h.srv.SetServingStatus("", all)
+}
+
+func (h *healthCheck) statuses() []*pb.SubsystemStatus {
+	now := time.Now()
+	var sts []*pb.SubsystemStatus
+	for _, s := range h.subsystems {
+		sts = append(sts, s.status(now))
+	}
+	return sts
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/protocol"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// capabilities lists what the bot can do with its hardware and flags.
+func (s *server) capabilities() []string {
+	caps := []string{
+		protocol.EchoFront,
+		protocol.EchoRear,
+		protocol.Profiles,
+		protocol.TankDrive,
+		protocol.EmergencyStop,
+		protocol.ModeWander,
+		protocol.ModeMission,
+		protocol.ModeScript,
+	}
+	if s.side != nil {
+		caps = append(caps, protocol.EchoSide, protocol.ModeWallFollow)
+	}
+	if s.imu != nil {
+		caps = append(caps, protocol.IMU)
+	}
+	if s.battery != nil {
+		caps = append(caps, protocol.Battery)
+	}
+	if s.camera != nil {
+		caps = append(caps, protocol.Camera, protocol.Photos)
+	}
+	if *padPath != "" {
+		caps = append(caps, protocol.Gamepad)
+	}
+	return caps
+}
+
+// checkClientVersion returns error when client protocol version v is older
+// than min.
+func checkClientVersion(v, min int32) error {
+	if v < min {
+		return fmt.Errorf("protocol version %d is too old, need at least %d", v, min)
+	}
+	return nil
+}
+
+// Clients remembered from Hello, the oldest are forgotten past that many.
+const maxClients = 64
+
+// clients keeps what clients told about themselves in Hello, by address, for
+// logs of their later calls, e.g. Drive.
+type clients struct {
+	mu    sync.Mutex
+	hello map[string]*pb.HelloRequest
+	addrs []string // Oldest first.
+}
+
+func (c *clients) add(addr string, in *pb.HelloRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hello == nil {
+		c.hello = make(map[string]*pb.HelloRequest)
+	}
+	if _, ok := c.hello[addr]; !ok {
+		c.addrs = append(c.addrs, addr)
+		if len(c.addrs) > maxClients {
+			delete(c.hello, c.addrs[0])
+			c.addrs = c.addrs[1:]
+		}
+	}
+	c.hello[addr] = in
+}
+
+// describe returns client at addr with name, version and capabilities.
+func (c *clients) describe(addr string) string {
+	c.mu.Lock()
+	in, ok := c.hello[addr]
+	c.mu.Unlock()
+	if !ok {
+		return addr + " without Hello, protocol version 0"
+	}
+	caps := "none"
+	if len(in.Capabilities) > 0 {
+		caps = strings.Join(protocol.NewSet(in.Capabilities).List(), ", ")
+	}
+	return fmt.Sprintf("%s %q, protocol version %d, capabilities: %s", addr, in.Client, in.ProtocolVersion, caps)
+}
+
+func (s *server) Hello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloResponse, error) {
+	if err := checkClientVersion(in.ProtocolVersion, protocol.MinVersion); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	addr := clientAddr(ctx)
+	s.clients.add(addr, in)
+	log.Infof("Hello from %s", s.clients.describe(addr))
+	return &pb.HelloResponse{
+		ProtocolVersion:    protocol.Version,
+		MinProtocolVersion: protocol.MinVersion,
+		Build:              version,
+		Capabilities:       s.capabilities(),
+	}, nil
+}
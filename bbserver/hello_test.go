@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/protocol"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/peer"
+)
+
+func TestCheckClientVersion(t *testing.T) {
+	for _, tc := range []struct {
+		v, min int32
+		ok     bool
+	}{
+		{0, 0, true},
+		{1, 0, true},
+		{protocol.Version, protocol.MinVersion, true},
+		{1, 2, false},
+		{0, 2, false},
+		{2, 2, true},
+		{3, 2, true},
+		{-1, 0, false},
+	} {
+		if err := checkClientVersion(tc.v, tc.min); (err == nil) != tc.ok {
+			t.Errorf("checkClientVersion(%d, %d) = %v, want ok %v", tc.v, tc.min, err, tc.ok)
+		}
+	}
+}
+
+func TestHelloKeepsClient(t *testing.T) {
+	s := newSimBot(t, "room", 0).srv
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 5000}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	in := &pb.HelloRequest{ProtocolVersion: protocol.Version, Client: "bbcli test", Capabilities: []string{"b", "a"}}
+	resp, err := s.Hello(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtocolVersion != protocol.Version || resp.MinProtocolVersion != protocol.MinVersion {
+		t.Errorf("Hello answered versions %d and %d, want %d and %d", resp.ProtocolVersion, resp.MinProtocolVersion, protocol.Version, protocol.MinVersion)
+	}
+	if got := s.clients.describe(addr.String()); !strings.Contains(got, `"bbcli test"`) || !strings.Contains(got, "capabilities: a, b") {
+		t.Errorf("client described as %q, want its name and capabilities", got)
+	}
+	if got := s.clients.describe("10.0.0.8:5000"); !strings.Contains(got, "without Hello") {
+		t.Errorf("unknown client described as %q", got)
+	}
+	if _, err := s.Hello(ctx, &pb.HelloRequest{ProtocolVersion: -1}); err == nil {
+		t.Error("Hello accepted negative protocol version")
+	}
+}
+
+func TestClientsForgetOldest(t *testing.T) {
+	var c clients
+	for i := 0; i < maxClients+10; i++ {
+		c.add(fmt.Sprintf("client%d", i), &pb.HelloRequest{Client: "bbcli"})
+	}
+	c.add("client20", &pb.HelloRequest{Client: "app"})
+	if len(c.hello) != maxClients || len(c.addrs) != maxClients {
+		t.Errorf("kept %d clients in %d addresses, want %d", len(c.hello), len(c.addrs), maxClients)
+	}
+	for addr, known := range map[string]bool{"client0": false, "client9": false, "client10": true, "client20": true} {
+		if _, ok := c.hello[addr]; ok != known {
+			t.Errorf("%s kept %v, want %v", addr, ok, known)
+		}
+	}
+	if c.hello["client20"].Client != "app" {
+		t.Errorf("client20 is %q after second Hello, want app", c.hello["client20"].Client)
+	}
+}
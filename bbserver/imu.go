@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"github.com/kidoman/embd"
+	log "github.com/sirupsen/logrus"
+)
+
+// MPU-6050 registers and scales for default ranges (±2g, ±250°/s).
+const (
+	mpuDefaultAddr = 0x68
+	mpuRegAccel    = 0x3B
+	mpuRegPwrMgmt1 = 0x6B
+	mpuRegWhoAmI   = 0x75
+	mpuAccelScale  = 16384.0
+	mpuGyroScale   = 131.0
+)
+
+const (
+	defaultIMUDur     = time.Millisecond * 20
+	defaultTipHold    = time.Millisecond * 500
+	defaultImpactHold = time.Second // IMPACT lasts that long after the last jolt.
+	imuCalibSamples   = 50
+	imuFilterGyroPart = 0.98
+)
+
+// Inertial measurement unit (MPU-6050) on I2C bus.
+type imu struct {
+	bus       embd.I2CBus
+	addr      byte
+	impact    float64 // Acceleration deviation from 1g, in g, treated as impact.
+	tipAngle  float64 // Tilt from vertical, in degrees, treated as tip-over.
+	waitc     chan struct{}
+	send      chan bool
+	onEvent   func(pb.ImuState)
+	gyroBiasZ float64
+	health    *subsystem
+
+	mu          sync.Mutex
+	heading     float64
+	rate        float64
+	accel       float64
+	pitch, roll float64
+	state       pb.ImuState
+	tiltSince   time.Time
+	impactAt    time.Time // The last jolt.
+}
+
+func newIMU(bus embd.I2CBus, addr byte, impact, tipAngle float64) (*imu, error) {
+	var m imu
+	m.bus = bus
+	m.addr = addr
+	m.impact = impact
+	m.tipAngle = tipAngle
+	m.waitc = make(chan struct{})
+	m.send = make(chan bool)
+	m.health = newSubsystem("imu", time.Second)
+
+	who, err := bus.ReadByteFromReg(addr, mpuRegWhoAmI)
+	if err != nil {
+		return nil, fmt.Errorf("can't read IMU identity: %v", err)
+	}
+	if who != mpuDefaultAddr {
+		return nil, fmt.Errorf("unexpected IMU identity 0x%x", who)
+	}
+	// Wake up from sleep mode.
+	if err := bus.WriteByteToReg(addr, mpuRegPwrMgmt1, 0); err != nil {
+		return nil, fmt.Errorf("can't wake up IMU: %v", err)
+	}
+	return &m, nil
+}
+
+// Raw reading converted to g and degrees per second.
+type imuSample struct {
+	ax, ay, az float64
+	gx, gy, gz float64
+}
+
+func (m *imu) read() (imuSample, error) {
+	buf := make([]byte, 14)
+	if err := m.bus.ReadFromReg(m.addr, mpuRegAccel, buf); err != nil {
+		return imuSample{}, fmt.Errorf("can't read IMU: %v", err)
+	}
+	word := func(i int) float64 { return float64(int16(binary.BigEndian.Uint16(buf[i:]))) }
+	// Bytes 6 and 7 hold temperature, which we don't need.
+	return imuSample{
+		ax: word(0) / mpuAccelScale,
+		ay: word(2) / mpuAccelScale,
+		az: word(4) / mpuAccelScale,
+		gx: word(8) / mpuGyroScale,
+		gy: word(10) / mpuGyroScale,
+		gz: word(12) / mpuGyroScale,
+	}, nil
+}
+
+// Average gyro Z drift while the bot is standing still, so heading doesn't
+// wander away on its own.
+func (m *imu) calibrate() error {
+	var sum float64
+	for i := 0; i < imuCalibSamples; i++ {
+		s, err := m.read()
+		if err != nil {
+			return err
+		}
+		sum += s.gz
+		time.Sleep(defaultIMUDur)
+	}
+	m.gyroBiasZ = sum / imuCalibSamples
+	log.Infof("IMU: gyro Z bias %.3f deg/s", m.gyroBiasZ)
+	return nil
+}
+
+// Goroutine reading IMU in an infinite loop, integrating heading and detecting
+// impacts and tip-overs.
+func (m *imu) runIMU() {
+	if err := m.calibrate(); err != nil {
+		log.Warnf("IMU calibration failed: %v", err)
+	}
+	ticker := time.NewTicker(defaultIMUDur)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-m.waitc:
+			return
+		case now := <-ticker.C:
+			s, err := m.read()
+			if err != nil {
+				log.Warn(err)
+				m.health.fail(err)
+				continue
+			}
+			m.health.ok()
+			m.update(s, now.Sub(last).Seconds(), now)
+			last = now
+		}
+	}
+}
+
+func (m *imu) update(s imuSample, dt float64, now time.Time) {
+	m.mu.Lock()
+	// Gyro Z grows counterclockwise looking from above, heading clockwise
+	// like a compass.
+	m.rate = -(s.gz - m.gyroBiasZ)
+	m.heading = math.Mod(m.heading+m.rate*dt+360, 360)
+	m.accel = math.Sqrt(s.ax*s.ax + s.ay*s.ay + s.az*s.az)
+
+	// Complementary filter: gyro is smooth but drifts, accelerometer is noisy
+	// but knows where the ground is.
+	accPitch := math.Atan2(-s.ax, math.Sqrt(s.ay*s.ay+s.az*s.az)) * 180 / math.Pi
+	accRoll := math.Atan2(s.ay, s.az) * 180 / math.Pi
+	m.pitch = imuFilterGyroPart*(m.pitch+s.gy*dt) + (1-imuFilterGyroPart)*accPitch
+	m.roll = imuFilterGyroPart*(m.roll+s.gx*dt) + (1-imuFilterGyroPart)*accRoll
+	tilt := math.Acos(math.Cos(m.pitch*math.Pi/180)*math.Cos(m.roll*math.Pi/180)) * 180 / math.Pi
+
+	prev := m.state
+	switch {
+	case tilt > m.tipAngle:
+		if m.tiltSince.IsZero() {
+			m.tiltSince = now
+		}
+		if now.Sub(m.tiltSince) >= defaultTipHold {
+			m.state = pb.ImuState_TIPPED
+		}
+	case math.Abs(m.accel-1) > m.impact:
+		m.tiltSince = time.Time{}
+		m.impactAt = now
+		m.state = pb.ImuState_IMPACT
+	default:
+		m.tiltSince = time.Time{}
+		// Held for a while, so directions sent just before crash don't
+		// drive into the obstacle again.
+		if m.state != pb.ImuState_IMPACT || now.Sub(m.impactAt) >= defaultImpactHold {
+			m.state = pb.ImuState_UPRIGHT
+		}
+	}
+	state := m.state
+	m.mu.Unlock()
+
+	if state == prev {
+		return
+	}
+	log.Warnf("IMU: state changed from %s to %s", prev, state)
+	if state != pb.ImuState_UPRIGHT && m.onEvent != nil {
+		m.onEvent(state)
+	}
+	select {
+	case m.send <- true:
+	default:
+	}
+}
+
+func (m *imu) Heading() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.heading
+}
+
+func (m *imu) fill(t *pb.Telemetry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.Heading = float32(m.heading)
+	t.AngularRate = float32(m.rate)
+	t.Accel = float32(m.accel)
+	t.ImuState = m.state
+}
+
+func (m *imu) State() pb.ImuState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// imuStop stops motors on impact or tip-over, under the same lock as drive.
+// Driving stays refused while IMU reports it, see canDrive.
+func (s *server) imuStop(st pb.ImuState) {
+	s.driveMu.Lock()
+	s.driver.stop()
+	s.cmd = cmdStop
+	s.driveMu.Unlock()
+	log.Warnf("IMU detected %s, motors stopped", st)
+}
+
+// close stops reading IMU. The bus is shared with other devices and closed
+// in main.
+func (m *imu) close() {
+	close(m.waitc)
+}
+
+// Fake MPU-6050 on a fake I2C bus, used to run bbserver off-hardware. It
+// reports a bot standing still on a flat surface, with a bit of sensor noise,
+// unless told otherwise with set. Only register access is implemented, plain
+// byte reads and writes are left to the embedded nil bus and panic.
+type fakeMPU struct {
+	embd.I2CBus
+
+	mu   sync.Mutex
+	regs [128]byte
+}
+
+func newFakeMPU() *fakeMPU {
+	var f fakeMPU
+	f.regs[mpuRegWhoAmI] = mpuDefaultAddr
+	f.regs[mpuRegPwrMgmt1] = 0x40 // Sleep bit set after power on.
+	f.set(imuSample{az: 1})
+	return &f
+}
+
+func (f *fakeMPU) set(s imuSample) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	put := func(reg int, v float64) {
+		binary.BigEndian.PutUint16(f.regs[reg:], uint16(int16(v)))
+	}
+	put(mpuRegAccel, s.ax*mpuAccelScale)
+	put(mpuRegAccel+2, s.ay*mpuAccelScale)
+	put(mpuRegAccel+4, s.az*mpuAccelScale)
+	put(mpuRegAccel+8, s.gx*mpuGyroScale)
+	put(mpuRegAccel+10, s.gy*mpuGyroScale)
+	put(mpuRegAccel+12, s.gz*mpuGyroScale)
+}
+
+func (f *fakeMPU) ReadFromReg(addr, reg byte, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if int(reg)+len(value) > len(f.regs) {
+		return fmt.Errorf("fake I2C: register 0x%x out of range", reg)
+	}
+	copy(value, f.regs[reg:])
+	if reg == mpuRegAccel {
+		for i := range value {
+			if i%2 == 1 {
+				value[i] ^= byte(rand.Intn(4))
+			}
+		}
+	}
+	return nil
+}
+
+func (f *fakeMPU) ReadByteFromReg(addr, reg byte) (byte, error) {
+	b := make([]byte, 1)
+	err := f.ReadFromReg(addr, reg, b)
+	return b[0], err
+}
+
+func (f *fakeMPU) ReadWordFromReg(addr, reg byte) (uint16, error) {
+	b := make([]byte, 2)
+	err := f.ReadFromReg(addr, reg, b)
+	return binary.BigEndian.Uint16(b), err
+}
+
+func (f *fakeMPU) WriteToReg(addr, reg byte, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if int(reg)+len(value) > len(f.regs) {
+		return fmt.Errorf("fake I2C: register 0x%x out of range", reg)
+	}
+	copy(f.regs[reg:], value)
+	return nil
+}
+
+func (f *fakeMPU) WriteByteToReg(addr, reg, value byte) error {
+	return f.WriteToReg(addr, reg, []byte{value})
+}
+
+func (f *fakeMPU) WriteWordToReg(addr, reg byte, value uint16) error {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, value)
+	return f.WriteToReg(addr, reg, b)
+}
+
+func (f *fakeMPU) Close() error { return nil }
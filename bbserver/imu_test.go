@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+// testIMU returns IMU on a fake MPU, with events it reports collected in
+// events.
+func testIMU(t *testing.T) (*imu, *fakeMPU, *[]pb.ImuState) {
+	t.Helper()
+	f := newFakeMPU()
+	m, err := newIMU(f, mpuDefaultAddr, 0.8, 45)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []pb.ImuState
+	m.onEvent = func(s pb.ImuState) { events = append(events, s) }
+	return m, f, &events
+}
+
+// feed makes IMU read sample s from fake MPU for duration d, at IMU rate.
+func feed(t *testing.T, m *imu, f *fakeMPU, s imuSample, d time.Duration, now *time.Time) {
+	t.Helper()
+	f.set(s)
+	for end := now.Add(d); now.Before(end); {
+		*now = now.Add(defaultIMUDur)
+		r, err := m.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.update(r, defaultIMUDur.Seconds(), *now)
+	}
+}
+
+func TestIMUImpact(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		s     imuSample
+		state pb.ImuState
+	}{
+		{"still", imuSample{az: 1}, pb.ImuState_UPRIGHT},
+		{"bump", imuSample{ay: 0.5, az: 1.2}, pb.ImuState_UPRIGHT},
+		{"crash", imuSample{ay: -1.6, az: 1}, pb.ImuState_IMPACT},
+		{"free fall", imuSample{}, pb.ImuState_IMPACT},
+	} {
+		m, f, events := testIMU(t)
+		now := time.Now()
+		feed(t, m, f, tc.s, defaultIMUDur, &now)
+		if got := m.State(); got != tc.state {
+			t.Errorf("%s: state %s, want %s", tc.name, got, tc.state)
+		}
+		if tc.state != pb.ImuState_UPRIGHT && (len(*events) != 1 || (*events)[0] != tc.state) {
+			t.Errorf("%s: events %v, want [%s]", tc.name, *events, tc.state)
+		}
+	}
+}
+
+func TestIMUImpactClears(t *testing.T) {
+	m, f, events := testIMU(t)
+	now := time.Now()
+	jolt := imuSample{ax: 1.9, az: 0.5}
+	feed(t, m, f, jolt, defaultIMUDur, &now)
+	feed(t, m, f, imuSample{az: 1}, defaultImpactHold/2, &now)
+	if got := m.State(); got != pb.ImuState_IMPACT {
+		t.Errorf("state %s right after impact, want IMPACT", got)
+	}
+	// Another jolt extends the hold.
+	feed(t, m, f, jolt, defaultIMUDur, &now)
+	feed(t, m, f, imuSample{az: 1}, defaultImpactHold*3/4, &now)
+	if got := m.State(); got != pb.ImuState_IMPACT {
+		t.Errorf("state %s after the second jolt, want IMPACT", got)
+	}
+	feed(t, m, f, imuSample{az: 1}, defaultImpactHold/2, &now)
+	if got := m.State(); got != pb.ImuState_UPRIGHT {
+		t.Errorf("state %s after impact hold, want UPRIGHT", got)
+	}
+	if len(*events) != 1 {
+		t.Errorf("events %v, want a single IMPACT", *events)
+	}
+}
+
+func TestIMUStopsDriving(t *testing.T) {
+	s := newSimBot(t, "room", 0).srv
+	m, f, _ := testIMU(t)
+	s.imu = m
+	m.onEvent = s.imuStop
+	s.drive(&pb.Direction{Dy: 80})
+	now := time.Now()
+	feed(t, m, f, imuSample{ay: -1.6, az: 1}, defaultIMUDur, &now)
+	if l, r := s.driver.left.pwr, s.driver.right.pwr; l != 0 || r != 0 {
+		t.Errorf("engines at %d and %d after impact, want stopped", l, r)
+	}
+	if cmd := s.drive(&pb.Direction{Dy: 80}); cmd != cmdStop {
+		t.Errorf("drove %s during impact hold", cmd)
+	}
+	feed(t, m, f, imuSample{az: 1}, defaultImpactHold, &now)
+	if cmd := s.drive(&pb.Direction{Dy: 80}); cmd != cmdForward {
+		t.Errorf("drove %s after impact hold, want forward", cmd)
+	}
+}
+
+func TestIMUTipped(t *testing.T) {
+	// On its side, a bit more than 70 degrees.
+	lying := imuSample{ax: 0.95, az: 0.3}
+	for _, tc := range []struct {
+		name  string
+		d     time.Duration
+		state pb.ImuState
+	}{
+		// Filtered tilt needs a while to pass tip angle, then tip hold.
+		{"short tilt", time.Millisecond * 600, pb.ImuState_UPRIGHT},
+		{"past angle within hold", time.Millisecond * 1200, pb.ImuState_UPRIGHT},
+		{"lying", time.Second * 3, pb.ImuState_TIPPED},
+	} {
+		m, f, events := testIMU(t)
+		now := time.Now()
+		feed(t, m, f, lying, tc.d, &now)
+		if got := m.State(); got != tc.state {
+			t.Errorf("%s: state %s, want %s", tc.name, got, tc.state)
+		}
+		if tc.state == pb.ImuState_TIPPED && (len(*events) != 1 || (*events)[0] != pb.ImuState_TIPPED) {
+			t.Errorf("%s: events %v, want [TIPPED]", tc.name, *events)
+		}
+	}
+}
+
+func TestIMUTipHold(t *testing.T) {
+	m, f, _ := testIMU(t)
+	now := time.Now()
+	lying := imuSample{ax: 0.95, az: 0.3}
+	// Find when filtered tilt passes tip angle.
+	for -m.pitch <= m.tipAngle {
+		feed(t, m, f, lying, defaultIMUDur, &now)
+	}
+	crossed := now
+	for m.State() != pb.ImuState_TIPPED {
+		feed(t, m, f, lying, defaultIMUDur, &now)
+		if now.Sub(crossed) > time.Second {
+			t.Fatal("not TIPPED a second after passing tip angle")
+		}
+	}
+	if d := now.Sub(crossed); d < defaultTipHold {
+		t.Errorf("TIPPED %v after passing tip angle, want at least %v", d, defaultTipHold)
+	}
+}
+
+func TestIMUHeading(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		gz   float64 // Counterclockwise seen from above.
+		want float64
+	}{
+		{"still", 0, 0},
+		{"clockwise", -90, 90},
+		{"counterclockwise", 90, 270},
+	} {
+		m, f, _ := testIMU(t)
+		now := time.Now()
+		feed(t, m, f, imuSample{az: 1, gz: tc.gz}, time.Second, &now)
+		got := m.Heading()
+		if d := math.Abs(math.Mod(got-tc.want+540, 360) - 180); d > 2 {
+			t.Errorf("%s: heading %.1f, want %.1f", tc.name, got, tc.want)
+		}
+	}
+}
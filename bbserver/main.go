@@ -12,37 +12,71 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/pawelkowalak/berrybot/gamepad"
 	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/sessionlog"
 
 	"github.com/kidoman/embd"
 	_ "github.com/kidoman/embd/host/rpi" // RaspberryPI driver
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// Version reported by GetStatus, set at build time with
+// -ldflags "-X main.version=...".
+var version = "dev"
+
 // Server is used to implement steering.DriverServer.
 type server struct {
 	front, rear *echo
+	side        *echo // Optional, nil when there is no side sensor.
 	driver      *driver
+	imu         *imu               // Optional, nil when there is no IMU.
+	battery     *battery           // Optional, nil when there is no battery monitor.
+	rec         *sessionlog.Writer // Optional, nil when not recording.
+	camera      *camera            // Optional, nil when there is no camera.
+	photos      *photoStore        // Nil when there is no camera.
+	odo         *odometry
+	cmd         driveCmd // Last executed drive command.
+	health      *healthCheck
+	started     time.Time
+	clients     clients
+
+	modeMu             sync.Mutex
+	mode               pb.Mode
+	modeStop, modeDone chan struct{} // Running autonomous mode, nil in manual mode.
+	mission            *missionRun   // Running mission, nil in other modes.
+
+	estop   estop
+	driveMu sync.Mutex // Serializes drive with latching emergency stop.
+	profile pb.Profile // Guarded by driveMu.
 }
 
 // Proximity sensor.
 type echo struct {
 	name    string
+	angle   float64 // Mounting angle in degrees, clockwise from the front.
 	echo    embd.DigitalPin
 	trig    embd.DigitalPin
 	waitc   chan struct{}
+	mu      sync.Mutex // Guards dist and last, read by behaviors.
 	dist    int64
 	last    time.Time
 	enabled bool
 	send    chan bool
+	health  *subsystem
 }
 
-func newEcho(name string, trigPin, echoPin int) (*echo, error) {
+func newEcho(name string, angle float64, trigPin, echoPin int) (*echo, error) {
 	var e echo
 	e.name = name
+	e.angle = angle
 	e.waitc = make(chan struct{})
 	e.send = make(chan bool)
+	e.health = newSubsystem("echo."+name, defaultSlowDur*3)
 	var err error
 	e.trig, err = embd.NewDigitalPin(trigPin)
 	if err != nil {
@@ -64,7 +98,16 @@ func newEcho(name string, trigPin, echoPin int) (*echo, error) {
 }
 
 // Try to TimePulse proximity sensor to calculate distance.
-func (e *echo) measure() error {
+func (e *echo) measure() (err error) {
+	defer func() {
+		if err != nil {
+			measureErrors.WithLabelValues(e.name).Inc()
+			e.health.fail(err)
+			return
+		}
+		e.health.ok()
+	}()
+	start := time.Now()
 	if err := e.trig.Write(embd.High); err != nil {
 		return fmt.Errorf("can't set trigger to high: %v", err)
 	}
@@ -76,9 +119,19 @@ func (e *echo) measure() error {
 	if err != nil {
 		return fmt.Errorf("can't time pulse: %v", err)
 	}
-	log.Infof("%s: distance: %dcm", e.name, dur.Nanoseconds()/1000*34/1000/2)
-	e.dist = dur.Nanoseconds() / 1000 * 34 / 1000 / 2
-	e.send <- true
+	dist := dur.Nanoseconds() / 1000 * 34 / 1000 / 2
+	log.Infof("%s: distance: %dcm", e.name, dist)
+	e.mu.Lock()
+	e.dist = dist
+	e.last = time.Now()
+	e.mu.Unlock()
+	measureLatency.WithLabelValues(e.name).Observe(time.Since(start).Seconds())
+	distanceGauge.WithLabelValues(e.name).Set(float64(dist))
+	// Don't block when no client is connected, sensor keeps measuring anyway.
+	select {
+	case e.send <- true:
+	default:
+	}
 	return nil
 }
 
@@ -116,6 +169,26 @@ func (e *echo) runDistancer() {
 	}
 }
 
+// latest returns the last distance in cm and when it was measured, zero time
+// if never.
+func (e *echo) latest() (int64, time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dist, e.last
+}
+
+// reading returns the latest distance for telemetry. Readings of 0 cm are
+// glitches rather than obstacles touching the sensor.
+func (e *echo) reading() *pb.Range {
+	dist, last := e.latest()
+	r := &pb.Range{Name: e.name, Angle: float32(e.angle), Dist: int32(dist)}
+	if !last.IsZero() && dist > 0 {
+		r.Valid = true
+		r.Age = int32(time.Since(last) / time.Millisecond)
+	}
+	return r
+}
+
 func (e *echo) close() {
 	close(e.waitc)
 	e.echo.Close()
@@ -136,6 +209,7 @@ func (d *driver) safetyStop() {
 		if d.moving && d.last.Add(time.Second).Before(time.Now()) {
 			d.mu.Unlock()
 			d.stop()
+			emergencyStops.Inc()
 			log.Warn("Emergency stop!")
 			continue
 		}
@@ -151,75 +225,80 @@ func (d *driver) setMoving(moving bool) {
 }
 
 func (d *driver) stop() {
-	d.left.pwr = 0
-	d.right.pwr = 0
+	d.left.halt()
+	d.right.halt()
 	d.setMoving(false)
 }
 
 func (d *driver) forward(pwr int32) {
-	d.left.pwr = pwr
-	d.left.fwdPin.Write(embd.High)
-	d.right.pwr = pwr
-	d.right.fwdPin.Write(embd.High)
+	d.left.set(pwr, true)
+	d.right.set(pwr, true)
 	d.setMoving(true)
 }
 
 func (d *driver) backward(pwr int32) {
-	d.left.pwr = pwr
-	d.left.fwdPin.Write(embd.Low)
-	d.right.pwr = pwr
-	d.right.fwdPin.Write(embd.Low)
+	d.left.set(pwr, false)
+	d.right.set(pwr, false)
 	d.setMoving(true)
 }
 
 func (d *driver) sharpRight(pwr int32) {
-	d.left.pwr = pwr
-	d.left.fwdPin.Write(embd.High)
-	d.right.pwr = pwr
-	d.right.fwdPin.Write(embd.Low)
+	d.left.set(pwr, true)
+	d.right.set(pwr, false)
 	d.setMoving(true)
 }
 
 func (d *driver) sharpLeft(pwr int32) {
-	d.left.pwr = pwr
-	d.left.fwdPin.Write(embd.Low)
-	d.right.pwr = pwr
-	d.right.fwdPin.Write(embd.High)
+	d.left.set(pwr, false)
+	d.right.set(pwr, true)
+	d.setMoving(true)
+}
+
+func (d *driver) fwdRight(pwr int32) {
+	d.left.set(pwr, true)
+	d.right.set(0, true)
 	d.setMoving(true)
 }
 
-func (d *driver) fwdRight() {
-	d.left.pwr = 100
-	d.left.fwdPin.Write(embd.High)
-	d.right.pwr = 0
-	d.right.fwdPin.Write(embd.High)
+func (d *driver) fwdLeft(pwr int32) {
+	d.left.set(0, true)
+	d.right.set(pwr, true)
 	d.setMoving(true)
 }
 
-func (d *driver) fwdLeft() {
-	d.left.pwr = 0
-	d.left.fwdPin.Write(embd.High)
-	d.right.pwr = 100
-	d.right.fwdPin.Write(embd.High)
+func (d *driver) backRight(pwr int32) {
+	d.left.set(pwr, false)
+	d.right.set(0, false)
 	d.setMoving(true)
 }
 
-func (d *driver) backRight() {
-	d.left.pwr = 100
-	d.left.fwdPin.Write(embd.Low)
-	d.right.pwr = 0
-	d.right.fwdPin.Write(embd.Low)
+func (d *driver) backLeft(pwr int32) {
+	d.left.set(0, false)
+	d.right.set(pwr, false)
 	d.setMoving(true)
 }
 
-func (d *driver) backLeft() {
-	d.left.pwr = 0
-	d.left.fwdPin.Write(embd.Low)
-	d.right.pwr = 100
-	d.right.fwdPin.Write(embd.Low)
+// tank drives each wheel with its own power, negative goes backward.
+func (d *driver) tank(left, right int32) {
+	d.left.set(abs(left), left >= 0)
+	d.right.set(abs(right), right >= 0)
 	d.setMoving(true)
 }
 
+// setPowerLimit caps power of both engines, 0 removes the limit.
+func (d *driver) setPowerLimit(limit int32) {
+	d.left.setLimit(limit)
+	d.right.setLimit(limit)
+}
+
+// setProfile applies power cap and ramp of driving profile to both engines.
+func (d *driver) setProfile(p profile) {
+	d.left.setProfile(p.maxPower, p.ramp)
+	d.right.setProfile(p.maxPower, p.ramp)
+}
+
+// Dead zone of directions, manual driving uses dead zone of driving profile
+// instead.
 const driveDeadZone = 15
 
 // driveCmd represents a single driving intent derived from joystick (Dx, Dy).
@@ -235,68 +314,150 @@ const (
 	cmdFwdLeft
 	cmdBackRight
 	cmdBackLeft
+	cmdTank
 )
 
-// driveRule maps a condition (dir) to a drive command. First match wins.
+var driveCmdNames = []string{
+	cmdStop:       "stop",
+	cmdForward:    "forward",
+	cmdBackward:   "backward",
+	cmdSharpRight: "sharpRight",
+	cmdSharpLeft:  "sharpLeft",
+	cmdFwdRight:   "fwdRight",
+	cmdFwdLeft:    "fwdLeft",
+	cmdBackRight:  "backRight",
+	cmdBackLeft:   "backLeft",
+	cmdTank:       "tank",
+}
+
+func (c driveCmd) String() string {
+	if int(c) < len(driveCmdNames) {
+		return driveCmdNames[c]
+	}
+	return fmt.Sprintf("driveCmd(%d)", int(c))
+}
+
+// driveRule maps a condition (dir and dead zone dz) to a drive command. First
+// match wins.
 type driveRule struct {
-	pred func(*pb.Direction) bool
+	pred func(d *pb.Direction, dz int32) bool
 	cmd  driveCmd
 }
 
 var driveTable = []driveRule{
-	{func(d *pb.Direction) bool { return d.Dy > driveDeadZone && d.Dx > -driveDeadZone && d.Dx < driveDeadZone }, cmdForward},
-	{func(d *pb.Direction) bool { return d.Dy < -driveDeadZone && d.Dx > -driveDeadZone && d.Dx < driveDeadZone }, cmdBackward},
-	{func(d *pb.Direction) bool { return d.Dx > driveDeadZone && d.Dy > -driveDeadZone && d.Dy < driveDeadZone }, cmdSharpRight},
-	{func(d *pb.Direction) bool { return d.Dx < -driveDeadZone && d.Dy > -driveDeadZone && d.Dy < driveDeadZone }, cmdSharpLeft},
-	{func(d *pb.Direction) bool { return d.Dx > driveDeadZone && d.Dy > driveDeadZone }, cmdFwdRight},
-	{func(d *pb.Direction) bool { return d.Dx < -driveDeadZone && d.Dy > driveDeadZone }, cmdFwdLeft},
-	{func(d *pb.Direction) bool { return d.Dx > driveDeadZone && d.Dy < -driveDeadZone }, cmdBackRight},
-	{func(d *pb.Direction) bool { return d.Dx < -driveDeadZone && d.Dy < -driveDeadZone }, cmdBackLeft},
+	{func(d *pb.Direction, dz int32) bool { return d.Tank && (abs(d.Left) > dz || abs(d.Right) > dz) }, cmdTank},
+	{func(d *pb.Direction, dz int32) bool { return d.Dy > dz && d.Dx > -dz && d.Dx < dz }, cmdForward},
+	{func(d *pb.Direction, dz int32) bool { return d.Dy < -dz && d.Dx > -dz && d.Dx < dz }, cmdBackward},
+	{func(d *pb.Direction, dz int32) bool { return d.Dx > dz && d.Dy > -dz && d.Dy < dz }, cmdSharpRight},
+	{func(d *pb.Direction, dz int32) bool { return d.Dx < -dz && d.Dy > -dz && d.Dy < dz }, cmdSharpLeft},
+	{func(d *pb.Direction, dz int32) bool { return d.Dx > dz && d.Dy > dz }, cmdFwdRight},
+	{func(d *pb.Direction, dz int32) bool { return d.Dx < -dz && d.Dy > dz }, cmdFwdLeft},
+	{func(d *pb.Direction, dz int32) bool { return d.Dx > dz && d.Dy < -dz }, cmdBackRight},
+	{func(d *pb.Direction, dz int32) bool { return d.Dx < -dz && d.Dy < -dz }, cmdBackLeft},
 }
 
-func classifyDirection(dir *pb.Direction) driveCmd {
+func classifyDirection(dir *pb.Direction, deadZone int32) driveCmd {
 	for _, r := range driveTable {
-		if r.pred(dir) {
+		if r.pred(dir, deadZone) {
 			return r.cmd
 		}
 	}
 	return cmdStop
 }
 
-func (s *server) drive(dir *pb.Direction) {
-	switch classifyDirection(dir) {
+// canDrive checks whether any safety condition forbids moving.
+func (s *server) canDrive() bool {
+	if s.estop.active() {
+		return false
+	}
+	if s.imu != nil && s.imu.State() != pb.ImuState_UPRIGHT {
+		return false
+	}
+	if s.battery != nil && s.battery.State() == pb.BatteryState_BATTERY_EMPTY {
+		return false
+	}
+	return true
+}
+
+// drive executes direction and returns resulting command.
+func (s *server) drive(dir *pb.Direction) driveCmd {
+	return s.driveDeadZone(dir, driveDeadZone)
+}
+
+// driveDeadZone executes direction treating stick deflection up to deadZone
+// as centered.
+func (s *server) driveDeadZone(dir *pb.Direction, deadZone int32) driveCmd {
+	s.driveMu.Lock()
+	defer s.driveMu.Unlock()
+	if !s.canDrive() {
+		s.driver.stop()
+		s.cmd = cmdStop
+		return cmdStop
+	}
+	cmd := classifyDirection(dir, deadZone)
+	s.cmd = cmd
+	driveCommands.WithLabelValues(cmd.String()).Inc()
+	switch cmd {
 	case cmdForward:
 		s.front.enabled = true
-		s.driver.forward(dir.Dy)
+		s.driver.forward(power(dir, dir.Dy))
 	case cmdBackward:
 		s.rear.enabled = true
-		s.driver.backward(-dir.Dy)
+		s.driver.backward(power(dir, -dir.Dy))
 	case cmdSharpRight:
-		s.driver.sharpRight(dir.Dx)
+		s.driver.sharpRight(power(dir, dir.Dx))
 	case cmdSharpLeft:
-		s.driver.sharpLeft(-dir.Dx)
+		s.driver.sharpLeft(power(dir, -dir.Dx))
 	case cmdFwdRight:
-		s.driver.fwdRight()
+		s.driver.fwdRight(power(dir, 100))
 	case cmdFwdLeft:
-		s.driver.fwdLeft()
+		s.driver.fwdLeft(power(dir, 100))
 	case cmdBackRight:
-		s.driver.backRight()
+		s.driver.backRight(power(dir, 100))
 	case cmdBackLeft:
-		s.driver.backLeft()
+		s.driver.backLeft(power(dir, 100))
+	case cmdTank:
+		s.front.enabled = dir.Left > 0 || dir.Right > 0
+		s.rear.enabled = dir.Left < 0 || dir.Right < 0
+		s.driver.tank(power(dir, dir.Left), power(dir, dir.Right))
 	default:
 		s.front.enabled = false
 		s.rear.enabled = false
 		s.driver.stop()
 	}
+	return cmd
+}
+
+// record writes record to session log of a client, nil when not recording.
+func record(sess *sessionlog.Session, r *pb.Record) {
+	if sess == nil {
+		return
+	}
+	if err := sess.Write(r); err != nil {
+		log.Warnf("can't record session: %v", err)
+	}
 }
 
 type engine struct {
+	name           string
 	fwdPin, pwrPin embd.DigitalPin
 	pwr            int32
+	fwd            bool
+	health         *subsystem
+
+	// Power caps, driving profile settings and ramp state, see rampUp.
+	mu       sync.Mutex
+	limit    int32   // Power cap of low battery, 0 means no limit.
+	maxPower int32   // Power cap of driving profile, 0 means no limit.
+	ramp     float64 // Power increase per second, 0 means instant.
+	target   int32   // Power reached by ramp.
+	rampAcc  float64 // Fraction of power increase carried to the next tick.
 }
 
-func newEngine(pwrPin, fwdPin int) (*engine, error) {
+func newEngine(name string, pwrPin, fwdPin int) (*engine, error) {
 	var e engine
+	e.name = name
+	e.health = newSubsystem("engine."+name, time.Second)
 	var err error
 	e.pwrPin, err = embd.NewDigitalPin(pwrPin)
 	if err != nil {
@@ -318,28 +479,183 @@ func newEngine(pwrPin, fwdPin int) (*engine, error) {
 	return &e, nil
 }
 
+func (e *engine) set(pwr int32, fwd bool) {
+	e.mu.Lock()
+	if c := e.powerCap(); c > 0 && pwr > c {
+		pwr = c
+	}
+	if fwd != e.fwd {
+		// Ramp up in the new direction from standstill.
+		e.pwr = 0
+	}
+	e.target = pwr
+	// Slowing down is never delayed.
+	if e.ramp == 0 || pwr < e.pwr {
+		e.pwr = pwr
+	}
+	e.fwd = fwd
+	e.mu.Unlock()
+	val := embd.Low
+	if fwd {
+		val = embd.High
+	}
+	if err := e.fwdPin.Write(val); err != nil {
+		e.health.fail(fmt.Errorf("can't set forward pin: %v", err))
+	}
+}
+
+// halt stops engine right away, ramp doesn't apply.
+func (e *engine) halt() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pwr = 0
+	e.target = 0
+	e.rampAcc = 0
+}
+
+// setLimit caps power, slowing running engine down right away.
+func (e *engine) setLimit(limit int32) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.limit = limit
+	e.applyCap()
+}
+
+func (e *engine) setProfile(maxPower int32, ramp float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.maxPower = maxPower
+	e.ramp = ramp
+	e.applyCap()
+}
+
+// powerCap returns the lower of power caps, 0 when there's none. It's called
+// with mu held.
+func (e *engine) powerCap() int32 {
+	switch {
+	case e.limit == 0:
+		return e.maxPower
+	case e.maxPower == 0:
+		return e.limit
+	}
+	return min(e.limit, e.maxPower)
+}
+
+// applyCap slows running engine down to power cap. It's called with mu held.
+func (e *engine) applyCap() {
+	if c := e.powerCap(); c > 0 {
+		e.target = min(e.target, c)
+		e.pwr = min(e.pwr, c)
+	}
+}
+
+// rampUp moves power towards target set with ramp, for period dt, and returns
+// the power.
+func (e *engine) rampUp(dt time.Duration) int32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pwr >= e.target {
+		e.rampAcc = 0
+		return e.pwr
+	}
+	e.rampAcc += e.ramp * dt.Seconds()
+	step := int32(e.rampAcc)
+	e.rampAcc -= float64(step)
+	e.pwr += step
+	if e.pwr > e.target {
+		e.pwr = e.target
+	}
+	return e.pwr
+}
+
+// power returns current power and direction of engine.
+func (e *engine) power() (int32, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.pwr, e.fwd
+}
+
+// setting returns power engine runs at once ramp is over, and its direction.
+func (e *engine) setting() (int32, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.target, e.fwd
+}
+
+// Power with sign of direction, negative when going backward.
+func (e *engine) signedPwr() int32 {
+	pwr, fwd := e.power()
+	if fwd {
+		return pwr
+	}
+	return -pwr
+}
+
+// Fraction of full speed resulting from PWM in startPWM, negative when going
+// backward.
+func (e *engine) speed() float64 {
+	pwr, fwd := e.power()
+	var v float64
+	switch {
+	case pwr < pwmHalf:
+		return 0
+	case pwr < pwmFull:
+		v = 0.5
+	default:
+		v = 1
+	}
+	if !fwd {
+		v = -v
+	}
+	return v
+}
+
 func (e *engine) close() {
 	e.pwrPin.Close()
 	e.fwdPin.Close()
 }
 
+// Software PWM has only three levels: engine is off below pwmHalf power,
+// runs at half duty below pwmFull and at full duty from there up. Power caps
+// only slow the bot down when they fall below pwmFull.
+const (
+	pwmHalf = 15
+	pwmFull = 50
+)
+
 func (e *engine) startPWM() {
-	ticker := time.NewTicker(time.Millisecond * 25)
+	const period = time.Millisecond * 25
+	ticker := time.NewTicker(period)
 	flap := embd.Low
-	for range ticker.C {
+	last := time.Now()
+	for now := range ticker.C {
+		jitter := now.Sub(last) - period
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		pwmJitter.WithLabelValues(e.name).Observe(jitter.Seconds())
+		powerGauge.WithLabelValues(e.name).Set(float64(e.signedPwr()))
+		last = now
+		pwr := e.rampUp(period)
+		var err error
 		switch {
-		case e.pwr < 15:
-			e.pwrPin.Write(embd.Low)
-		case e.pwr < 50:
-			e.pwrPin.Write(flap)
+		case pwr < pwmHalf:
+			err = e.pwrPin.Write(embd.Low)
+		case pwr < pwmFull:
+			err = e.pwrPin.Write(flap)
 			if flap == embd.Low {
 				flap = embd.High
 			} else {
 				flap = embd.Low
 			}
 		default:
-			e.pwrPin.Write(embd.High)
+			err = e.pwrPin.Write(embd.High)
+		}
+		if err != nil {
+			e.health.fail(fmt.Errorf("can't set power pin: %v", err))
+			continue
 		}
+		e.health.ok()
 	}
 }
 
@@ -349,39 +665,163 @@ const (
 	sensorRear
 )
 
-func (s *server) sendTelemetry(stream pb.Driver_DriveServer) error {
+// driveStream carries directions from and telemetry to a single client. It's
+// implemented by gRPC Drive stream and web socket of the web control panel.
+type driveStream interface {
+	Send(*pb.Telemetry) error
+	Recv() (*pb.Direction, error)
+}
+
+func (s *server) sendTelemetry(stream driveStream, sess *sessionlog.Session) error {
 	var speed int32
 	if s.driver.moving {
 		speed = 100
 	}
 	log.Info("Sending telemetry!")
-	return stream.Send(&pb.Telemetry{Speed: speed, DistFront: int32(s.front.dist), DistRear: int32(s.rear.dist)})
+	t := &pb.Telemetry{Speed: speed, DistFront: int32(s.front.dist), DistRear: int32(s.rear.dist)}
+	if s.side != nil {
+		t.DistSide = int32(s.side.dist)
+	}
+	for _, e := range []*echo{s.front, s.rear, s.side} {
+		if e != nil {
+			t.Ranges = append(t.Ranges, e.reading())
+		}
+	}
+	t.Cmd = s.cmd.String()
+	t.LeftPower = s.driver.left.signedPwr()
+	t.RightPower = s.driver.right.signedPwr()
+	t.Mode = s.currentMode()
+	t.Profile = s.currentProfile()
+	s.estop.fill(t)
+	if m := s.runningMission(); m != nil {
+		m.fill(t)
+	}
+	s.odo.fill(t)
+	if s.imu != nil {
+		s.imu.fill(t)
+	}
+	if s.battery != nil {
+		s.battery.fill(t)
+	}
+	record(sess, &pb.Record{Telemetry: t})
+	return stream.Send(t)
+}
+
+// Channel notifying about battery state changes, nil (blocking forever)
+// without battery monitor.
+func (s *server) batterySend() chan bool {
+	if s.battery == nil {
+		return nil
+	}
+	return s.battery.send
+}
+
+// Channel notifying about side distance, nil (blocking forever) without side
+// sensor.
+func (s *server) sideSend() chan bool {
+	if s.side == nil {
+		return nil
+	}
+	return s.side.send
+}
 
+// Channel notifying about IMU state changes, nil (blocking forever) without IMU.
+func (s *server) imuSend() chan bool {
+	if s.imu == nil {
+		return nil
+	}
+	return s.imu.send
 }
 
 func (s *server) Drive(stream pb.Driver_DriveServer) error {
+	log.Infof("Drive from %s", s.clients.describe(clientAddr(stream.Context())))
+	return s.serveDrive(stream)
+}
+
+func (s *server) GetStatus(ctx context.Context, in *pb.StatusRequest) (*pb.Status, error) {
+	st := &pb.Status{
+		Version:    version,
+		Uptime:     int64(time.Since(s.started).Seconds()),
+		Config:     make(map[string]string),
+		Subsystems: s.health.statuses(),
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		st.Config[f.Name] = f.Value.String()
+	})
+	return st, nil
+}
+
+// serveDrive drives with directions from stream and sends back telemetry until
+// client goes away.
+func (s *server) serveDrive(stream driveStream) error {
+	streamsGauge.Inc()
+	defer streamsGauge.Dec()
+	// Each client is recorded to its own session log, so concurrent ones
+	// don't mix.
+	var sess *sessionlog.Session
+	if s.rec != nil {
+		sess = s.rec.NewSession()
+		defer func() {
+			if err := sess.Close(); err != nil {
+				log.Warnf("can't close session log: %v", err)
+			}
+		}()
+	}
 	waitc := make(chan struct{})
+	guard := newDirectionGuard(stream)
 	go func() {
 		for {
-			d, err := stream.Recv()
+			d, err := guard.Recv()
 			if err != nil {
 				log.Warnf("ERR from client: %v", err)
+				if v := guard.summary(); v != "" {
+					log.Warnf("Client violations: %s", v)
+				}
 				close(waitc)
 				return
 			}
-			s.drive(d)
+			if s.currentMode() != pb.Mode_MANUAL {
+				continue
+			}
+			cmd := s.driveManual(d)
+			// Powers engines ramp up to, telemetry catches up with them.
+			left, leftFwd := s.driver.left.setting()
+			right, rightFwd := s.driver.right.setting()
+			record(sess, &pb.Record{
+				Direction:    d,
+				Cmd:          cmd.String(),
+				LeftPower:    left,
+				RightPower:   right,
+				LeftForward:  leftFwd,
+				RightForward: rightFwd,
+			})
 		}
 	}()
 
 	for {
 		select {
 		case <-s.front.send:
-			if err := s.sendTelemetry(stream); err != nil {
+			if err := s.sendTelemetry(stream, sess); err != nil {
 				log.Errorf("can't send telemetry: %v", err)
 				return err
 			}
 		case <-s.rear.send:
-			if err := s.sendTelemetry(stream); err != nil {
+			if err := s.sendTelemetry(stream, sess); err != nil {
+				log.Errorf("can't send telemetry: %v", err)
+				return err
+			}
+		case <-s.sideSend():
+			if err := s.sendTelemetry(stream, sess); err != nil {
+				log.Errorf("can't send telemetry: %v", err)
+				return err
+			}
+		case <-s.imuSend():
+			if err := s.sendTelemetry(stream, sess); err != nil {
+				log.Errorf("can't send telemetry: %v", err)
+				return err
+			}
+		case <-s.batterySend():
+			if err := s.sendTelemetry(stream, sess); err != nil {
 				log.Errorf("can't send telemetry: %v", err)
 				return err
 			}
@@ -392,41 +832,147 @@ func (s *server) Drive(stream pb.Driver_DriveServer) error {
 	}
 }
 
+// driveGamepad drives with gamepad connected to the bot until it's unplugged.
+// Idle pad doesn't interfere with remote clients, but its stop button latches
+// emergency stop of the whole bot, which also ends autonomous mode.
+func (s *server) driveGamepad(pad *gamepad.Pad) {
+	defer pad.Close()
+	padErr := make(chan error, 1)
+	go func() { padErr <- pad.Run() }()
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+	var last pb.Direction
+	for {
+		select {
+		case err := <-padErr:
+			log.Warnf("Gamepad lost: %v", err)
+			s.drive(&pb.Direction{})
+			return
+		case a := <-pad.Actions():
+			switch a {
+			case gamepad.ActionStop:
+				s.emergencyStop("stop button (from gamepad)")
+			case gamepad.ActionReleaseStop:
+				s.clearEmergencyStop("gamepad")
+			case gamepad.ActionProfile:
+				if err := s.setProfile(gamepad.NextProfile(s.currentProfile())); err != nil {
+					log.Warnf("can't switch profile: %v", err)
+				}
+			}
+		case <-ticker.C:
+			if s.currentMode() != pb.Mode_MANUAL {
+				continue
+			}
+			d := pad.Direction()
+			idle := d.Dx == 0 && d.Dy == 0
+			if !pad.Stopped() && idle && last.Dx == 0 && last.Dy == 0 {
+				continue
+			}
+			s.driveManual(d)
+			last = *d
+		}
+	}
+}
+
 var (
 	grpcPort  = flag.String("grpc-port", "31337", "gRPC listen port")
 	bcastPort = flag.String("bcast-port", "8032", "UDP broadcast port used by clients for discovery")
+	i2cBus    = flag.Int("i2c-bus", 1, "I2C bus number of IMU and battery ADC")
+	imuOn     = flag.Bool("imu", false, "Use MPU-6050 IMU on I2C bus for heading and crash detection")
+	imuFake   = flag.Bool("imu-fake", false, "Use fake IMU on a fake I2C bus, for running off-hardware")
+	imuImpact = flag.Float64("imu-impact", 2, "Acceleration change in g treated as impact")
+	imuTilt   = flag.Float64("imu-tilt", 60, "Tilt in degrees treated as tip-over")
+
+	batOn      = flag.Bool("battery", false, "Monitor battery voltage with ADS1115 ADC on I2C bus")
+	batFake    = flag.Bool("battery-fake", false, "Use fake battery ADC on a fake I2C bus, for running off-hardware")
+	batCells   = flag.Int("battery-cells", 2, "Number of LiPo cells in battery pack")
+	batDivider = flag.Float64("battery-divider", 2, "Ratio of voltage divider between battery and ADC input")
+	batLow     = flag.Float64("battery-low", 7.0, "Pack voltage below which engine power is limited")
+	batCutoff  = flag.Float64("battery-cutoff", 6.6, "Pack voltage below which bot stops and refuses to drive")
+
+	recDir      = flag.String("record-dir", "", "Record driving sessions to this directory, disabled if empty")
+	recMaxSize  = flag.Int64("record-max-size", 10<<20, "Maximum size in bytes of a single session log file")
+	recMaxFiles = flag.Int("record-max-files", 20, "Maximum number of session log files to keep, 0 keeps all")
+
+	sideTrig = flag.Int("side-trig", 0, "Trigger pin of optional side echo used for wall following, disabled if 0")
+	sideEcho = flag.Int("side-echo", 0, "Echo pin of optional side echo used for wall following")
+	wallLeft = flag.Bool("wall-left", false, "Side echo is mounted on the left, not right")
+	wallDist = flag.Float64("wall-dist", 30, "Distance in cm from side echo to wall kept when following it")
+
+	profileName = flag.String("profile", "sport", "Driving profile at start: sport, indoor or kids")
+
+	scriptMaxTime = flag.Duration("script-max-time", time.Minute*10, "Running time after which behavior script is stopped")
+
+	simMap = flag.String("sim", "", "Run simulated bot in built-in map (room, corridor) or JSON map file instead of using GPIO")
+
+	camPath   = flag.String("camera", "", "V4L2 camera device streaming video, e.g. /dev/video0, disabled if empty")
+	camFake   = flag.Bool("camera-fake", false, "Stream test pattern from fake camera, for running off-hardware")
+	camWidth  = flag.Int("camera-width", 640, "Camera frame width, camera may pick the closest it supports")
+	camHeight = flag.Int("camera-height", 480, "Camera frame height")
+	camRate   = flag.Float64("camera-rate", 10, "Maximum camera frames per second")
+	photoDir  = flag.String("photo-dir", "photos", "Directory storing photos taken with camera")
+
+	padPath = flag.String("gamepad", "", "Drive with gamepad joystick device connected to the bot, e.g. /dev/input/js0")
 )
 
 func main() {
 	flag.Parse()
-
-	go http.ListenAndServe(":9191", nil)
+	started := time.Now()
 
 	// Initialize GPIO.
+	gpio := newSubsystem("gpio", 0)
 	var err error
+	if *simMap != "" {
+		if sim, err = loadSimWorld(*simMap); err != nil {
+			log.Fatalf("Can't init simulation: %v", err)
+		}
+		embd.SetHost(hostSim, 0)
+		log.Infof("Simulating bot in %s", *simMap)
+	}
 	if err = embd.InitGPIO(); err != nil {
 		log.Fatalf("Can't init GPIO: %v", err)
 	}
 	defer embd.CloseGPIO()
-	front, err := newEcho("front", 9, 10)
+	gpio.ok()
+	front, err := newEcho("front", 0, 9, 10)
 	if err != nil {
 		log.Fatalf("Can't init front echo: %v", err)
 	}
 	defer front.close()
-	rear, err := newEcho("rear", 19, 20)
+	rear, err := newEcho("rear", 180, 19, 20)
 	if err != nil {
 		log.Fatalf("Can't init rear echo: %v", err)
 	}
 	defer rear.close()
+	if sim != nil {
+		sim.addEcho(front.echo.N(), front.angle)
+		sim.addEcho(rear.echo.N(), rear.angle)
+	}
 	go front.runDistancer()
 	go rear.runDistancer()
+	var side *echo
+	if *sideTrig != 0 {
+		angle := 90.0
+		if *wallLeft {
+			angle = 270
+		}
+		side, err = newEcho("side", angle, *sideTrig, *sideEcho)
+		if err != nil {
+			log.Fatalf("Can't init side echo: %v", err)
+		}
+		defer side.close()
+		if sim != nil {
+			sim.addEcho(side.echo.N(), side.angle)
+		}
+		go side.runDistancer()
+	}
 
-	left, err := newEngine(23, 4)
+	left, err := newEngine("left", 23, 4)
 	if err != nil {
 		log.Fatalf("Can't init left engine: %v", err)
 	}
 	defer left.close()
-	right, err := newEngine(24, 17)
+	right, err := newEngine("right", 24, 17)
 	if err != nil {
 		log.Fatalf("Can't init right engine: %v", err)
 	}
@@ -442,9 +988,121 @@ func main() {
 	drv := driver{left: left, right: right}
 	go drv.safetyStop()
 
-	srv := server{front: front, rear: rear, driver: &drv}
+	srv := server{front: front, rear: rear, side: side, driver: &drv, started: started}
+	prof, err := parseProfile(*profileName)
+	if err != nil {
+		log.Fatalf("Can't set driving profile: %v", err)
+	}
+	if err := srv.setProfile(prof); err != nil {
+		log.Fatalf("Can't set driving profile: %v", err)
+	}
+	bcastHealth := newSubsystem("discovery", time.Second*3)
+	subsystems := []*subsystem{gpio, front.health, rear.health, left.health, right.health, bcastHealth}
+	if side != nil {
+		subsystems = append(subsystems, side.health)
+	}
+
+	// Initialize I2C for optional devices, sharing one bus closed here.
+	var i2c embd.I2CBus
+	if (*imuOn && !*imuFake) || (*batOn && !*batFake) {
+		if err := embd.InitI2C(); err != nil {
+			log.Fatalf("Can't init I2C: %v", err)
+		}
+		defer embd.CloseI2C()
+		i2c = embd.NewI2CBus(byte(*i2cBus))
+	}
+
+	// Initialize optional IMU.
+	if *imuOn || *imuFake {
+		var bus embd.I2CBus
+		if *imuFake {
+			bus = newFakeMPU()
+		} else {
+			bus = i2c
+		}
+		srv.imu, err = newIMU(bus, mpuDefaultAddr, *imuImpact, *imuTilt)
+		if err != nil {
+			log.Fatalf("Can't init IMU: %v", err)
+		}
+		defer srv.imu.close()
+		subsystems = append(subsystems, srv.imu.health)
+		srv.imu.onEvent = srv.imuStop
+		go srv.imu.runIMU()
+	}
+	srv.odo = &odometry{driver: &drv, imu: srv.imu}
+	go srv.odo.run()
+	if sim != nil {
+		sim.setOdometry(srv.odo)
+		go sim.run()
+	}
+
+	// Initialize optional battery monitor.
+	if *batOn || *batFake {
+		var bus embd.I2CBus
+		if *batFake {
+			bus = newFakeADS(*batCells, *batDivider, time.Minute*30)
+		} else {
+			bus = i2c
+		}
+		srv.battery, err = newBattery(bus, adsDefaultAddr, *batDivider, *batCells, *batLow, *batCutoff)
+		if err != nil {
+			log.Fatalf("Can't init battery monitor: %v", err)
+		}
+		defer srv.battery.close()
+		subsystems = append(subsystems, srv.battery.health)
+		srv.battery.onState = srv.batteryChanged
+		go srv.battery.runBattery()
+	}
+	// Initialize optional session recording.
+	if *recDir != "" {
+		srv.rec, err = sessionlog.NewWriter(*recDir, *recMaxSize, *recMaxFiles)
+		if err != nil {
+			log.Fatalf("Can't init session recording: %v", err)
+		}
+		defer srv.rec.Close()
+		log.Infof("Recording sessions to %s", *recDir)
+	}
+
+	// Initialize optional camera.
+	if *camPath != "" || *camFake {
+		if err := checkCameraSize(*camWidth, *camHeight, *camRate); err != nil {
+			log.Fatalf("Can't init camera: %v", err)
+		}
+		var src frameSource
+		if *camFake {
+			src = newFakeSource(*camWidth, *camHeight, *camRate)
+		} else if src, err = newV4L2Source(*camPath, *camWidth, *camHeight, *camRate); err != nil {
+			log.Fatalf("Can't init camera: %v", err)
+		}
+		srv.camera = newCamera(src)
+		defer srv.camera.close()
+		if srv.photos, err = newPhotoStore(*photoDir); err != nil {
+			log.Fatalf("Can't init photo storage: %v", err)
+		}
+		subsystems = append(subsystems, srv.camera.health)
+		go srv.camera.run()
+	}
+
+	// Initialize optional gamepad.
+	if *padPath != "" {
+		pad, err := gamepad.Open(*padPath, gamepad.DefaultMapping)
+		if err != nil {
+			log.Fatalf("Can't init gamepad: %v", err)
+		}
+		go srv.driveGamepad(pad)
+	}
+
+	srv.health = newHealthCheck(subsystems...)
+	go srv.health.run()
+
+	// Serve web control panel, metrics and pprof.
+	srv.handleWeb(http.DefaultServeMux)
+	http.Handle("/metrics", promhttp.Handler())
+	go http.ListenAndServe(":9191", nil)
+
 	s := grpc.NewServer()
 	pb.RegisterDriverServer(s, &srv)
+	healthpb.RegisterHealthServer(s, srv.health.srv)
 
 	// Open broadcast connection.
 	bcast, err := net.ListenPacket("udp", ":0")
@@ -464,6 +1122,9 @@ func main() {
 		for {
 			if _, err := bcast.WriteTo([]byte(*grpcPort), dst); err != nil {
 				log.Warn(err)
+				bcastHealth.fail(err)
+			} else {
+				bcastHealth.ok()
 			}
 			time.Sleep(time.Second)
 		}
@@ -474,10 +1135,26 @@ func main() {
 	go func() {
 		sig := <-c
 		log.Infof("Got %s, trying to shutdown gracefully", sig.String())
+		srv.health.srv.Shutdown()
 		front.close()
 		rear.close()
+		if side != nil {
+			side.close()
+		}
 		left.close()
 		right.close()
+		if srv.imu != nil {
+			srv.imu.close()
+		}
+		if srv.battery != nil {
+			srv.battery.close()
+		}
+		if srv.rec != nil {
+			srv.rec.Close()
+		}
+		if srv.camera != nil {
+			srv.camera.close()
+		}
 		embd.CloseGPIO()
 		lis.Close()
 		bcast.Close()
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEchoReading(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		dist  int64
+		ago   time.Duration // Since measured, 0 if never.
+		valid bool
+	}{
+		{"never measured", 0, 0, false},
+		{"glitch", 0, time.Second, false},
+		{"obstacle", 42, time.Millisecond * 300, true},
+	} {
+		e := &echo{name: "front"}
+		if tc.ago > 0 {
+			e.dist, e.last = tc.dist, time.Now().Add(-tc.ago)
+		}
+		r := e.reading()
+		if r.Valid != tc.valid || r.Dist != int32(tc.dist) {
+			t.Errorf("%s: reading %v, want dist %d, valid %v", tc.name, r, tc.dist, tc.valid)
+		}
+		if tc.valid && (r.Age < int32(tc.ago/time.Millisecond) || r.Age > int32(tc.ago/time.Millisecond)+100) {
+			t.Errorf("%s: age %dms, want about %v", tc.name, r.Age, tc.ago)
+		}
+	}
+}
+
+func TestEchoLatestConsistent(t *testing.T) {
+	e := &echo{name: "front"}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	stop := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		// Distance and time written together, as measure does.
+		for i := int64(1); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			e.mu.Lock()
+			e.dist, e.last = i, time.Unix(i, 0)
+			e.mu.Unlock()
+		}
+	}()
+	for i := 0; i < 10000; i++ {
+		d, at := e.latest()
+		if d != 0 && at.Unix() != d {
+			t.Fatalf("latest returned distance %d measured at %d", d, at.Unix())
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
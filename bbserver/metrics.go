@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics, served on /metrics next to pprof.
+var (
+	distanceGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bbot_distance_cm",
+		Help: "Last distance measured by proximity sensor.",
+	}, []string{"sensor"})
+	measureErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbot_measure_errors_total",
+		Help: "Failed distance measurements.",
+	}, []string{"sensor"})
+	measureLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bbot_measure_duration_seconds",
+		Help:    "Time taken by successful distance measurement.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 10),
+	}, []string{"sensor"})
+	driveCommands = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbot_drive_commands_total",
+		Help: "Executed drive commands.",
+	}, []string{"cmd"})
+	directionViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bbot_direction_violations_total",
+		Help: "Directions from clients clamped, rejected as invalid or stale, or dropped as flood.",
+	}, []string{"kind"})
+	directionLatencies = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "bbot_direction_latency_seconds",
+		Help:    "Time from client sending direction to bot receiving it, as far as their clocks agree.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+	emergencyStops = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "bbot_emergency_stops_total",
+		Help: "Stops made by safety timer when directions stopped coming.",
+	})
+	streamsGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "bbot_streams",
+		Help: "Connected drive streams, gRPC and web.",
+	})
+	powerGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bbot_engine_power",
+		Help: "Engine power, negative when going backward.",
+	}, []string{"engine"})
+	pwmJitter = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bbot_pwm_jitter_seconds",
+		Help:    "Difference between actual and expected PWM loop period.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 10),
+	}, []string{"engine"})
+	simCollisions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "bbot_sim_collisions_total",
+		Help: "Collisions with walls of simulated bot.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(distanceGauge, measureErrors, measureLatency, driveCommands,
+		directionViolations, directionLatencies, emergencyStops, streamsGauge, powerGauge, pwmJitter, simCollisions)
+}
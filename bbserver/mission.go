@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pawelkowalak/berrybot/mission"
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	missionPower     = 40 // Half speed keeps odometry error low.
+	missionTurnPower = 30
+	missionBlocked   = 20                 // Obstacle closer than that in cm fails driving steps.
+	missionStale     = defaultSlowDur * 2 // Readings older than that fail driving steps.
+)
+
+// missionRun executes mission steps one after another on top of odometry.
+// Progress is measured from pose change on every tick, so paused steps resume
+// where they stopped.
+type missionRun struct {
+	m           *pb.Mission
+	odo         *odometry
+	front, rear *echo
+
+	mu        sync.Mutex
+	cur       int       // Index of current step.
+	done      float64   // Progress of current step in its units, or 0 for STEP_UNTIL_FRONT.
+	rate      float64   // Progress made in the last tick.
+	since     time.Time // Start of current step.
+	paused    bool
+	lastX     float64
+	lastY     float64
+	lastHead  float64
+	lastAt    time.Time
+	lastValid bool
+}
+
+func newMissionRun(m *pb.Mission, odo *odometry, front, rear *echo) *missionRun {
+	return &missionRun{m: m, odo: odo, front: front, rear: rear}
+}
+
+func (r *missionRun) setPaused(p bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = p
+}
+
+func (r *missionRun) fill(t *pb.Telemetry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t.MissionSteps = int32(len(r.m.Steps))
+	t.MissionStep = int32(r.cur + 1)
+	if r.cur >= len(r.m.Steps) {
+		t.MissionStep = t.MissionSteps
+		t.MissionStepDone = 1
+	} else if v := math.Abs(float64(r.m.Steps[r.cur].Value)); v > 0 {
+		t.MissionStepDone = float32(math.Min(1, r.done/v))
+	}
+	t.MissionPaused = r.paused
+}
+
+func (r *missionRun) step(now time.Time) (*pb.Direction, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	x, y, head := r.odo.pose()
+	var dist, turn, dt float64
+	if r.lastValid {
+		dist = math.Hypot(x-r.lastX, y-r.lastY)
+		turn = math.Mod(head-r.lastHead+540, 360) - 180
+		dt = now.Sub(r.lastAt).Seconds()
+	} else {
+		r.since = now
+	}
+	r.lastX, r.lastY, r.lastHead, r.lastAt, r.lastValid = x, y, head, now, true
+
+	if r.cur >= len(r.m.Steps) {
+		return nil, errBehaviorDone
+	}
+	s := r.m.Steps[r.cur]
+	target := math.Abs(float64(s.Value))
+	switch s.Action {
+	case pb.StepAction_STEP_FORWARD, pb.StepAction_STEP_BACKWARD:
+		r.rate = dist
+	case pb.StepAction_STEP_TURN:
+		// Signed, so overshoot of previous tick counts back.
+		r.rate = turn
+		if s.Value < 0 {
+			r.rate = -turn
+		}
+	case pb.StepAction_STEP_WAIT:
+		r.rate = 0
+		if !r.paused {
+			r.rate = dt
+		}
+	}
+	r.done += r.rate
+
+	// Bot keeps going for one more tick before it stops, so finish half of
+	// the last progress early to land closer to the target on average.
+	finished := s.Action != pb.StepAction_STEP_UNTIL_FRONT && r.done+r.rate/2 >= target
+	if s.Action == pb.StepAction_STEP_UNTIL_FRONT {
+		// Reading taken before the step started says nothing about the way.
+		finished = r.front.last.After(r.since) && float64(r.front.dist) < target
+	}
+	if finished {
+		r.cur++
+		r.done, r.rate = 0, 0
+		r.since = now
+		return &pb.Direction{}, nil
+	}
+	if r.paused {
+		return &pb.Direction{}, nil
+	}
+
+	switch s.Action {
+	case pb.StepAction_STEP_FORWARD:
+		if err := r.check(r.front, now); err != nil {
+			return nil, err
+		}
+		return &pb.Direction{Dy: missionPower}, nil
+	case pb.StepAction_STEP_BACKWARD:
+		if err := r.check(r.rear, now); err != nil {
+			return nil, err
+		}
+		return &pb.Direction{Dy: -missionPower}, nil
+	case pb.StepAction_STEP_TURN:
+		if s.Value < 0 {
+			return &pb.Direction{Dx: -missionTurnPower}, nil
+		}
+		return &pb.Direction{Dx: missionTurnPower}, nil
+	case pb.StepAction_STEP_UNTIL_FRONT:
+		if now.Sub(r.front.last) > missionStale {
+			return nil, fmt.Errorf("step %d: no recent %s distance", r.cur+1, r.front.name)
+		}
+		r.front.enabled = true
+		return &pb.Direction{Dy: missionPower}, nil
+	}
+	return &pb.Direction{}, nil
+}
+
+// check fails driving step when echo in the direction of driving has no
+// recent reading or sees an obstacle.
+func (r *missionRun) check(e *echo, now time.Time) error {
+	if now.Sub(e.last) > missionStale {
+		return fmt.Errorf("step %d: no recent %s distance", r.cur+1, e.name)
+	}
+	if e.dist < missionBlocked {
+		return fmt.Errorf("step %d: %s blocked at %dcm", r.cur+1, e.name, e.dist)
+	}
+	return nil
+}
+
+func (s *server) RunMission(ctx context.Context, in *pb.Mission) (*pb.ModeResponse, error) {
+	if err := mission.Validate(in); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := s.startMode(pb.Mode_MISSION, newMissionRun(in, s.odo, s.front, s.rear)); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	return &pb.ModeResponse{Mode: s.currentMode()}, nil
+}
+
+func (s *server) ControlMission(ctx context.Context, in *pb.MissionControl) (*pb.ModeResponse, error) {
+	m := s.runningMission()
+	if m == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "no mission running")
+	}
+	switch in.Action {
+	case pb.MissionAction_MISSION_PAUSE:
+		m.setPaused(true)
+	case pb.MissionAction_MISSION_RESUME:
+		m.setPaused(false)
+	case pb.MissionAction_MISSION_ABORT:
+		if err := s.setMode(pb.Mode_MANUAL); err != nil {
+			return nil, status.Errorf(codes.Internal, "%v", err)
+		}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown mission action %v", in.Action)
+	}
+	return &pb.ModeResponse{Mode: s.currentMode()}, nil
+}
+
+func (s *server) runningMission() *missionRun {
+	s.modeMu.Lock()
+	defer s.modeMu.Unlock()
+	return s.mission
+}
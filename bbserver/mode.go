@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultBehaviorDur = time.Millisecond * 100
+
+// behavior is an autonomous driving mode. It's asked for direction on every
+// tick, error ends the mode and stops the bot.
+type behavior interface {
+	step(now time.Time) (*pb.Direction, error)
+}
+
+// errBehaviorDone is returned by behavior which finished its job.
+var errBehaviorDone = errors.New("done")
+
+func (s *server) newBehavior(m pb.Mode) (behavior, error) {
+	switch m {
+	case pb.Mode_MANUAL:
+		return nil, nil
+	case pb.Mode_WANDER:
+		return newWander(s.front, s.rear), nil
+	case pb.Mode_WALL_FOLLOW:
+		if s.side == nil {
+			return nil, fmt.Errorf("wall following needs side echo, see -side-trig and -side-echo")
+		}
+		return newWallFollow(s.front, s.side, !*wallLeft, *wallDist), nil
+	case pb.Mode_MISSION:
+		return nil, fmt.Errorf("missions are started with RunMission")
+	case pb.Mode_SCRIPT:
+		return nil, fmt.Errorf("scripts are started with RunScript")
+	}
+	return nil, fmt.Errorf("unknown mode %v", m)
+}
+
+func (s *server) SetMode(ctx context.Context, in *pb.ModeRequest) (*pb.ModeResponse, error) {
+	if err := s.setMode(in.Mode); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	return &pb.ModeResponse{Mode: s.currentMode()}, nil
+}
+
+// setMode stops current autonomous mode, if any, and starts a new one.
+func (s *server) setMode(m pb.Mode) error {
+	b, err := s.newBehavior(m)
+	if err != nil {
+		return err
+	}
+	return s.startMode(m, b)
+}
+
+// startMode stops current autonomous mode, if any, and starts driving with
+// behavior in mode m. Nil behavior switches to manual mode.
+func (s *server) startMode(m pb.Mode, b behavior) error {
+	if b != nil {
+		if err := s.autoSafe(); err != nil {
+			return fmt.Errorf("can't start %s mode: %v", m, err)
+		}
+	}
+	s.modeMu.Lock()
+	defer s.modeMu.Unlock()
+	if s.modeStop != nil {
+		close(s.modeStop)
+		<-s.modeDone
+		s.modeStop, s.modeDone = nil, nil
+	}
+	s.mode = m
+	s.mission, _ = b.(*missionRun)
+	log.Infof("Mode set to %s", m)
+	if b == nil {
+		return nil
+	}
+	s.modeStop, s.modeDone = make(chan struct{}), make(chan struct{})
+	go s.runBehavior(b, s.modeStop, s.modeDone)
+	return nil
+}
+
+func (s *server) currentMode() pb.Mode {
+	s.modeMu.Lock()
+	defer s.modeMu.Unlock()
+	return s.mode
+}
+
+// endMode switches back to manual mode after behavior started with stop
+// channel ended by itself. It's no-op when mode was changed in the meantime.
+func (s *server) endMode(stop chan struct{}) {
+	s.modeMu.Lock()
+	defer s.modeMu.Unlock()
+	if s.modeStop != stop {
+		return
+	}
+	s.modeStop, s.modeDone = nil, nil
+	s.mode = pb.Mode_MANUAL
+	s.mission = nil
+}
+
+// autoSafe checks whether autonomous driving is safe. On top of canDrive it
+// refuses to continue after impact, which likely means a missed obstacle.
+func (s *server) autoSafe() error {
+	if s.estop.active() {
+		return fmt.Errorf("emergency stop latched")
+	}
+	if !s.canDrive() {
+		return fmt.Errorf("safety limits forbid driving")
+	}
+	if s.imu != nil && s.imu.State() != pb.ImuState_UPRIGHT {
+		return fmt.Errorf("IMU reports %s", s.imu.State())
+	}
+	return nil
+}
+
+// Goroutine driving with behavior until stopped or behavior fails.
+func (s *server) runBehavior(b behavior, stop, done chan struct{}) {
+	ticker := time.NewTicker(defaultBehaviorDur)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			s.drive(&pb.Direction{})
+			close(done)
+			return
+		case now := <-ticker.C:
+			d, err := b.step(now)
+			if err == nil {
+				err = s.autoSafe()
+			}
+			if err == errBehaviorDone {
+				log.Infof("Autonomous mode finished")
+				s.drive(&pb.Direction{})
+				close(done)
+				s.endMode(stop)
+				return
+			}
+			if err != nil {
+				log.Warnf("Autonomous mode stopped: %v", err)
+				s.drive(&pb.Direction{})
+				close(done)
+				s.endMode(stop)
+				return
+			}
+			s.drive(d)
+		}
+	}
+}
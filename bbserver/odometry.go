@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+// Rough chassis numbers used for dead reckoning, as there are no wheel encoders.
+const (
+	fullSpeedCmPerSec = 40.0
+	wheelBaseCm       = 14.0
+	defaultOdoDur     = time.Millisecond * 50
+)
+
+// Dead-reckoning pose estimate. Position is integrated from engine output.
+// Heading comes from the IMU when present, otherwise from wheel speed difference.
+type odometry struct {
+	driver *driver
+	imu    *imu
+
+	mu      sync.Mutex
+	x, y    float64 // cm
+	heading float64 // degrees
+}
+
+// Goroutine integrating pose in an infinite loop.
+func (o *odometry) run() {
+	ticker := time.NewTicker(defaultOdoDur)
+	defer ticker.Stop()
+	last := time.Now()
+	for now := range ticker.C {
+		o.update(now.Sub(last).Seconds())
+		last = now
+	}
+}
+
+func (o *odometry) update(dt float64) {
+	vl := o.driver.left.speed() * fullSpeedCmPerSec
+	vr := o.driver.right.speed() * fullSpeedCmPerSec
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.imu != nil {
+		o.heading = o.imu.Heading()
+	} else {
+		omega := (vl - vr) / wheelBaseCm * 180 / math.Pi
+		o.heading = math.Mod(o.heading+omega*dt+360, 360)
+	}
+	// Heading 0 is straight ahead along Y, growing clockwise like a compass.
+	v := (vl + vr) / 2
+	rad := o.heading * math.Pi / 180
+	o.x += v * math.Sin(rad) * dt
+	o.y += v * math.Cos(rad) * dt
+}
+
+func (o *odometry) pose() (x, y, heading float64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.x, o.y, o.heading
+}
+
+func (o *odometry) fill(t *pb.Telemetry) {
+	x, y, heading := o.pose()
+	t.PosX = float32(x)
+	t.PosY = float32(y)
+	if o.imu == nil {
+		t.Heading = float32(heading)
+	}
+}
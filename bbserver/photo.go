@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Size of photo chunks sent by GetPhoto, well below gRPC message size limit.
+const photoChunkSize = 64 << 10
+
+// photoStore keeps photos in a directory as JPEG files, each with metadata in
+// JSON file of the same name.
+type photoStore struct {
+	dir string
+}
+
+func newPhotoStore(dir string) (*photoStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create photo dir: %v", err)
+	}
+	return &photoStore{dir: dir}, nil
+}
+
+// save stores JPEG named after info timestamp and fills in the rest of info.
+func (p *photoStore) save(b []byte, info *pb.PhotoInfo) error {
+	info.Name = time.Unix(0, info.Timestamp).Format("20060102-150405.000") + ".jpg"
+	info.Size = int64(len(b))
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(b)); err == nil {
+		info.Width, info.Height = int32(cfg.Width), int32(cfg.Height)
+	}
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return fmt.Errorf("can't marshal photo info: %v", err)
+	}
+	path := filepath.Join(p.dir, info.Name)
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("can't write photo: %v", err)
+	}
+	if err := os.WriteFile(strings.TrimSuffix(path, ".jpg")+".json", meta, 0644); err != nil {
+		return fmt.Errorf("can't write photo info: %v", err)
+	}
+	return nil
+}
+
+// info reads metadata of photo by its name.
+func (p *photoStore) info(name string) (*pb.PhotoInfo, error) {
+	if name != filepath.Base(name) || !strings.HasSuffix(name, ".jpg") {
+		return nil, fmt.Errorf("invalid photo name %q", name)
+	}
+	b, err := os.ReadFile(filepath.Join(p.dir, strings.TrimSuffix(name, ".jpg")+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("can't read photo info: %v", err)
+	}
+	info := new(pb.PhotoInfo)
+	if err := json.Unmarshal(b, info); err != nil {
+		return nil, fmt.Errorf("can't parse photo info: %v", err)
+	}
+	return info, nil
+}
+
+// list returns info of all photos, the newest first.
+func (p *photoStore) list() ([]*pb.PhotoInfo, error) {
+	names, err := filepath.Glob(filepath.Join(p.dir, "*.jpg"))
+	if err != nil {
+		return nil, fmt.Errorf("can't list photos: %v", err)
+	}
+	var photos []*pb.PhotoInfo
+	for _, n := range names {
+		info, err := p.info(filepath.Base(n))
+		if err != nil {
+			log.Warnf("Skipping photo %s: %v", n, err)
+			continue
+		}
+		photos = append(photos, info)
+	}
+	sort.Slice(photos, func(i, j int) bool { return photos[i].Timestamp > photos[j].Timestamp })
+	return photos, nil
+}
+
+func (p *photoStore) open(name string) (*pb.PhotoInfo, *os.File, error) {
+	info, err := p.info(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(filepath.Join(p.dir, name))
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open photo: %v", err)
+	}
+	return info, f, nil
+}
+
+func (s *server) TakePhoto(ctx context.Context, in *pb.TakePhotoRequest) (*pb.PhotoInfo, error) {
+	if s.camera == nil {
+		return nil, status.Errorf(codes.Unavailable, "no camera, see -camera")
+	}
+	b, err := s.camera.still()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	x, y, heading := s.odo.pose()
+	if s.imu != nil {
+		// Odometry copies it only on its ticks.
+		heading = s.imu.Heading()
+	}
+	info := &pb.PhotoInfo{
+		Timestamp: time.Now().UnixNano(),
+		PosX:      float32(x),
+		PosY:      float32(y),
+		Heading:   float32(heading),
+		DistFront: int32(s.front.dist),
+		DistRear:  int32(s.rear.dist),
+	}
+	if s.side != nil {
+		info.DistSide = int32(s.side.dist)
+	}
+	if err := s.photos.save(b, info); err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	log.Infof("Took photo %s, %dx%d", info.Name, info.Width, info.Height)
+	return info, nil
+}
+
+func (s *server) ListPhotos(ctx context.Context, in *pb.ListPhotosRequest) (*pb.PhotoList, error) {
+	if s.photos == nil {
+		return nil, status.Errorf(codes.Unavailable, "no camera, see -camera")
+	}
+	photos, err := s.photos.list()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+	return &pb.PhotoList{Photos: photos}, nil
+}
+
+func (s *server) GetPhoto(in *pb.GetPhotoRequest, stream pb.Driver_GetPhotoServer) error {
+	if s.photos == nil {
+		return status.Errorf(codes.Unavailable, "no camera, see -camera")
+	}
+	info, f, err := s.photos.open(in.Name)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "%v", err)
+	}
+	defer f.Close()
+	buf := make([]byte, photoChunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 || info != nil {
+			if err := stream.Send(&pb.PhotoChunk{Info: info, Data: buf[:n]}); err != nil {
+				return err
+			}
+			info = nil
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return status.Errorf(codes.Internal, "can't read photo: %v", err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"golang.org/x/net/context"
+)
+
+// testPhotoBot returns simulated bot with fake camera storing photos in a
+// temporary dir.
+func testPhotoBot(t *testing.T) *server {
+	t.Helper()
+	s := newSimBot(t, "room", 0).srv
+	s.camera = newCamera(newFakeSource(32, 24, 10))
+	var err error
+	if s.photos, err = newPhotoStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestTakePhotoPose(t *testing.T) {
+	s := testPhotoBot(t)
+	s.odo.x, s.odo.y, s.odo.heading = 12, -34, 123
+	info, err := s.TakePhoto(context.Background(), &pb.TakePhotoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.PosX != 12 || info.PosY != -34 || info.Heading != 123 {
+		t.Errorf("photo at %.0f,%.0f heading %.0f, want 12,-34 heading 123", info.PosX, info.PosY, info.Heading)
+	}
+	if info.Width != 64 || info.Height != 48 {
+		t.Errorf("photo %dx%d, want full resolution 64x48", info.Width, info.Height)
+	}
+}
+
+func TestTakePhotoIMUHeading(t *testing.T) {
+	s := testPhotoBot(t)
+	m, f, _ := testIMU(t)
+	s.imu, s.odo.imu = m, m
+	// Turned clockwise a quarter, odometry didn't tick since.
+	now := time.Now()
+	feed(t, m, f, imuSample{az: 1, gz: -90}, time.Second, &now)
+	s.odo.heading = 10
+	info, err := s.TakePhoto(context.Background(), &pb.TakePhotoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(float64(info.Heading)-m.Heading()) > 0.01 || math.Abs(float64(info.Heading)-90) > 2 {
+		t.Errorf("photo heading %.1f, want IMU heading %.1f", info.Heading, m.Heading())
+	}
+}
+
+func TestPhotoNames(t *testing.T) {
+	s := testPhotoBot(t)
+	taken, err := s.TakePhoto(context.Background(), &pb.TakePhotoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Photo-like files outside of photo dir must stay out of reach.
+	outside := filepath.Dir(s.photos.dir)
+	for _, n := range []string{"secret.jpg", "secret.json"} {
+		if err := os.WriteFile(filepath.Join(outside, n), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		ok   bool
+	}{
+		{taken.Name, true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../secret.jpg", false},
+		{filepath.Join(outside, "secret.jpg"), false},
+		{"sub/" + taken.Name, false},
+		{taken.Name + "/", false},
+		{"20260101-000000.000.json", false},
+		{"20260101-000000.000.jpg", false}, // Valid but not there.
+	} {
+		info, f, err := s.photos.open(tc.name)
+		if (err == nil) != tc.ok {
+			t.Errorf("open(%q) error %v, want ok %v", tc.name, err, tc.ok)
+		}
+		if err == nil {
+			f.Close()
+			if info.Name != taken.Name {
+				t.Errorf("open(%q) returned %s", tc.name, info.Name)
+			}
+		}
+	}
+}
+
+func TestListPhotos(t *testing.T) {
+	s := testPhotoBot(t)
+	var names []string
+	for i := 0; i < 3; i++ {
+		info, err := s.TakePhoto(context.Background(), &pb.TakePhotoRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, info.Name)
+		// Names have millisecond resolution.
+		time.Sleep(time.Millisecond * 2)
+	}
+	// Broken metadata is skipped.
+	os.WriteFile(filepath.Join(s.photos.dir, "broken.jpg"), nil, 0644)
+	l, err := s.ListPhotos(context.Background(), &pb.ListPhotosRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Photos) != 3 {
+		t.Fatalf("listed %d photos, want 3", len(l.Photos))
+	}
+	for i, p := range l.Photos {
+		if want := names[len(names)-1-i]; p.Name != want {
+			t.Errorf("photo %d is %s, want %s, the newest first", i, p.Name, want)
+		}
+	}
+}
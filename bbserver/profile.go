@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// profile tames manual driving for less experienced drivers. Power cap and
+// ramp apply to engines whatever drives them, dead zone and turn sensitivity
+// only to directions from clients and gamepad. With only three PWM levels, a
+// cap between pwmHalf and pwmFull means half speed, and a cap of pwmFull or
+// more doesn't slow the bot down at all. A cap below pwmHalf would stop it, so
+// indoor and kids share half speed and differ only in ramp, dead zone and
+// turn.
+type profile struct {
+	maxPower int32   // Engine power cap, 0 means no cap.
+	deadZone int32   // Stick deflection treated as centered, replaces driveDeadZone.
+	ramp     float64 // Engine power increase per second, 0 means instant.
+	turn     float64 // Stick dx is scaled by it.
+}
+
+var profiles = map[pb.Profile]profile{
+	pb.Profile_PROFILE_SPORT:  {maxPower: 100, deadZone: driveDeadZone, turn: 1},
+	pb.Profile_PROFILE_INDOOR: {maxPower: 40, deadZone: 20, ramp: 150, turn: 0.8},
+	pb.Profile_PROFILE_KIDS:   {maxPower: 40, deadZone: 25, ramp: 100, turn: 0.6},
+}
+
+// parseProfile returns profile by its name without PROFILE_ prefix, in any
+// case, e.g. kids.
+func parseProfile(name string) (pb.Profile, error) {
+	p, ok := pb.Profile_value["PROFILE_"+strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown profile %q", name)
+	}
+	return pb.Profile(p), nil
+}
+
+// steer shapes direction from driver's stick with profile.
+func (p profile) steer(d *pb.Direction) *pb.Direction {
+	out := *d
+	out.Dx = int32(float64(d.Dx) * p.turn)
+	for _, v := range []*int32{&out.Dx, &out.Dy, &out.Left, &out.Right} {
+		if *v >= -p.deadZone && *v <= p.deadZone {
+			*v = 0
+		}
+	}
+	return &out
+}
+
+// driveManual drives with direction from driver's stick, shaped by active
+// profile.
+func (s *server) driveManual(d *pb.Direction) driveCmd {
+	s.driveMu.Lock()
+	p := profiles[s.profile]
+	s.driveMu.Unlock()
+	return s.driveDeadZone(p.steer(d), p.deadZone)
+}
+
+func (s *server) setProfile(p pb.Profile) error {
+	prof, ok := profiles[p]
+	if !ok {
+		return fmt.Errorf("unknown profile %v", p)
+	}
+	s.driveMu.Lock()
+	defer s.driveMu.Unlock()
+	s.profile = p
+	s.driver.setProfile(prof)
+	log.Infof("Profile set to %s", p)
+	return nil
+}
+
+func (s *server) currentProfile() pb.Profile {
+	s.driveMu.Lock()
+	defer s.driveMu.Unlock()
+	return s.profile
+}
+
+func (s *server) SetProfile(ctx context.Context, in *pb.ProfileRequest) (*pb.ProfileResponse, error) {
+	if err := s.setProfile(in.Profile); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	return &pb.ProfileResponse{Profile: s.currentProfile()}, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/sessionlog"
+)
+
+func TestParseProfile(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want pb.Profile
+		ok   bool
+	}{
+		{"sport", pb.Profile_PROFILE_SPORT, true},
+		{"Indoor", pb.Profile_PROFILE_INDOOR, true},
+		{"KIDS", pb.Profile_PROFILE_KIDS, true},
+		{"PROFILE_KIDS", 0, false},
+		{"turbo", 0, false},
+		{"", 0, false},
+	} {
+		got, err := parseProfile(tc.name)
+		if (err == nil) != tc.ok || got != tc.want {
+			t.Errorf("parseProfile(%q) = %v, %v, want %v, ok %v", tc.name, got, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestProfileSteer(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    pb.Profile
+		in   pb.Direction
+		want pb.Direction
+	}{
+		{"sport passes", pb.Profile_PROFILE_SPORT, pb.Direction{Dx: 30, Dy: 80}, pb.Direction{Dx: 30, Dy: 80}},
+		{"sport dead zone", pb.Profile_PROFILE_SPORT, pb.Direction{Dx: 15, Dy: -15}, pb.Direction{}},
+		{"indoor dead zone", pb.Profile_PROFILE_INDOOR, pb.Direction{Dx: 10, Dy: 20}, pb.Direction{}},
+		{"indoor turn", pb.Profile_PROFILE_INDOOR, pb.Direction{Dx: 50, Dy: 50}, pb.Direction{Dx: 40, Dy: 50}},
+		{"kids turn into dead zone", pb.Profile_PROFILE_KIDS, pb.Direction{Dx: 40, Dy: 60}, pb.Direction{Dy: 60}},
+		{"kids turn", pb.Profile_PROFILE_KIDS, pb.Direction{Dx: -100, Dy: 26}, pb.Direction{Dx: -60, Dy: 26}},
+		{"kids tank", pb.Profile_PROFILE_KIDS, pb.Direction{Tank: true, Left: 25, Right: -80, Seq: 7}, pb.Direction{Tank: true, Right: -80, Seq: 7}},
+	} {
+		got := profiles[tc.p].steer(&tc.in)
+		if got.Dx != tc.want.Dx || got.Dy != tc.want.Dy || got.Left != tc.want.Left || got.Right != tc.want.Right ||
+			got.Tank != tc.want.Tank || got.Seq != tc.want.Seq {
+			t.Errorf("%s: steer(%v) = %v, want %v", tc.name, &tc.in, got, &tc.want)
+		}
+	}
+}
+
+func TestProfilesSlowDown(t *testing.T) {
+	// Caps have to fall below full PWM duty to make any difference.
+	for _, p := range []pb.Profile{pb.Profile_PROFILE_INDOOR, pb.Profile_PROFILE_KIDS} {
+		if c := profiles[p].maxPower; c <= pwmHalf || c >= pwmFull {
+			t.Errorf("%s caps power at %d, want half duty, between %d and %d", p, c, pwmHalf, pwmFull)
+		}
+	}
+}
+
+func TestSetProfileCaps(t *testing.T) {
+	for _, tc := range []struct {
+		p     pb.Profile
+		pwr   int32
+		speed float64
+	}{
+		{pb.Profile_PROFILE_SPORT, 100, 1},
+		{pb.Profile_PROFILE_INDOOR, 40, 0.5},
+		{pb.Profile_PROFILE_KIDS, 40, 0.5},
+	} {
+		drv := &driver{left: testEngine("left"), right: testEngine("right")}
+		s := &server{driver: drv}
+		// Engine already at full power is slowed down right away.
+		drv.left.set(100, true)
+		if err := s.setProfile(tc.p); err != nil {
+			t.Fatal(err)
+		}
+		if s.currentProfile() != tc.p {
+			t.Errorf("current profile %s, want %s", s.currentProfile(), tc.p)
+		}
+		if drv.left.pwr > tc.pwr {
+			t.Errorf("%s: running engine at %d after switch, want at most %d", tc.p, drv.left.pwr, tc.pwr)
+		}
+		drv.right.set(100, true)
+		for i := 0; i < 40; i++ {
+			drv.right.rampUp(time.Millisecond * 25)
+		}
+		if drv.right.pwr != tc.pwr || drv.right.speed() != tc.speed {
+			t.Errorf("%s: full stick gives power %d and speed %.1f, want %d and %.1f",
+				tc.p, drv.right.pwr, drv.right.speed(), tc.pwr, tc.speed)
+		}
+	}
+	s := &server{driver: &driver{left: testEngine("left"), right: testEngine("right")}}
+	if err := s.setProfile(pb.Profile(42)); err == nil {
+		t.Error("setProfile accepted unknown profile")
+	}
+}
+
+func TestPowerCaps(t *testing.T) {
+	e := testEngine("left")
+	for _, tc := range []struct {
+		name    string
+		limit   int32
+		profile int32
+		want    int32
+	}{
+		{"no caps", 0, 0, 100},
+		{"profile", 0, 40, 40},
+		{"battery under profile", 30, 40, 30},
+		{"profile under battery", 45, 40, 40},
+		{"battery", 30, 0, 30},
+		{"lifted", 0, 0, 100},
+	} {
+		e.setLimit(tc.limit)
+		e.setProfile(tc.profile, 0)
+		if pwr, _ := e.power(); pwr > tc.want {
+			t.Errorf("%s: running engine at %d, want at most %d", tc.name, pwr, tc.want)
+		}
+		e.set(100, true)
+		if pwr, _ := e.power(); pwr != tc.want {
+			t.Errorf("%s: full power gives %d, want %d", tc.name, pwr, tc.want)
+		}
+	}
+}
+
+func TestDeadZoneReplaced(t *testing.T) {
+	for _, tc := range []struct {
+		d        pb.Direction
+		deadZone int32
+		want     driveCmd
+	}{
+		{pb.Direction{Dy: 12}, driveDeadZone, cmdStop},
+		{pb.Direction{Dy: 12}, 10, cmdForward},
+		{pb.Direction{Dy: 20}, 25, cmdStop},
+		{pb.Direction{Dx: 20, Dy: 30}, 25, cmdForward},
+		{pb.Direction{Tank: true, Left: 12}, 10, cmdTank},
+	} {
+		if got := classifyDirection(&tc.d, tc.deadZone); got != tc.want {
+			t.Errorf("classifyDirection(%v, %d) = %s, want %s", &tc.d, tc.deadZone, got, tc.want)
+		}
+	}
+}
+
+func TestRecordsRampTarget(t *testing.T) {
+	dir := t.TempDir()
+	w, err := sessionlog.NewWriter(dir, 1<<20, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSimBot(t, "room", 0).srv
+	s.rec = w
+	if err := s.setProfile(pb.Profile_PROFILE_KIDS); err != nil {
+		t.Fatal(err)
+	}
+	s.serveDrive(&fakeDriveStream{dirs: []*pb.Direction{{Dy: 80, Seq: 1}}})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := sessionlog.List(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("session logs %v, %v, want one", files, err)
+	}
+	recs, err := sessionlog.ReadFile(files[0])
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("read %d records, %v, want one", len(recs), err)
+	}
+	// Engines without PWM running don't ramp up.
+	if r := recs[0]; r.LeftPower != 40 || r.RightPower != 40 || !r.LeftForward || s.driver.left.signedPwr() != 0 {
+		t.Errorf("recorded powers %d and %d with engine at %d, want target 40 of both", r.LeftPower, r.RightPower, s.driver.left.signedPwr())
+	}
+}
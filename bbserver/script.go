@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	log "github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Behavior script limits.
+const (
+	scriptMaxSize  = 64 << 10
+	scriptMaxSteps = 100000   // Starlark execution steps per call, bounds CPU use.
+	scriptMaxAlloc = 16 << 20 // Rough bytes allocated per call, bounds memory use.
+	scriptMaxState = 1 << 20  // Rough size in bytes of state kept between calls.
+)
+
+// scriptCallTimeout is variable for tests, which hit other limits whatever
+// the speed of the machine.
+var scriptCallTimeout = defaultBehaviorDur / 2
+
+// script is a behavior driven by Starlark script. Script defines function
+// step(s, state), called on every tick with sensor readings s and state dict
+// kept between calls. It drives by calling driver builtins, e.g. forward(50),
+// the last call wins and step without any call stops the bot. Starlark has no
+// access to files or network and scripts can't load modules, so only the
+// builtins reach out of the sandbox. Every call is limited in execution steps,
+// allocated memory and time, state in size and the whole script in running
+// time. Operations which can allocate a lot are checked before they run, see
+// checkAllocs.
+type script struct {
+	name     string
+	srv      *server
+	stepFn   starlark.Callable
+	state    *starlark.Dict
+	start    time.Time
+	deadline time.Time
+	dir      *pb.Direction // Direction set by builtins in current step.
+}
+
+func newScript(srv *server, name, src string, maxTime time.Duration) (*script, error) {
+	if len(src) > scriptMaxSize {
+		return nil, fmt.Errorf("script is larger than %d bytes", scriptMaxSize)
+	}
+	sc := &script{
+		name:  name,
+		srv:   srv,
+		state: starlark.NewDict(0),
+		dir:   &pb.Direction{},
+	}
+	f, err := (&syntax.FileOptions{}).Parse(name, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	checkAllocs(f.Stmts)
+	predeclared := sc.builtins()
+	for k, v := range memBuiltins {
+		predeclared[k] = v
+	}
+	prog, err := starlark.FileProgram(f, predeclared.Has)
+	if err != nil {
+		return nil, err
+	}
+	var globals starlark.StringDict
+	err = sc.limited(func(th *starlark.Thread) error {
+		var err error
+		globals, err = prog.Init(th, predeclared)
+		globals.Freeze()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := globals["step"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script doesn't define step function")
+	}
+	sc.stepFn = fn
+	sc.start = time.Now()
+	sc.deadline = sc.start.Add(maxTime)
+	return sc, nil
+}
+
+// limited runs f, which executes Starlark code, within per-call limits. Every
+// call gets its own thread, so timeout firing just as a call returns can't
+// cancel the next one, and its own memory budget, charged only by the script.
+func (sc *script) limited(f func(*starlark.Thread) error) error {
+	// Load stays nil, so load statements fail.
+	th := &starlark.Thread{
+		Name: sc.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Infof("Script %s: %s", sc.name, msg)
+		},
+	}
+	th.SetMaxExecutionSteps(scriptMaxSteps)
+	left := int64(scriptMaxAlloc)
+	th.SetLocal(scriptMemKey, &left)
+	t := time.AfterFunc(scriptCallTimeout, func() {
+		th.Cancel("call took too long")
+	})
+	defer t.Stop()
+	return f(th)
+}
+
+// stateSize returns rough size in bytes of value kept between calls, or
+// false when it's nested too deep, e.g. a list containing itself.
+func stateSize(v starlark.Value, depth int) (int, bool) {
+	if depth > 100 {
+		return 0, false
+	}
+	size := scriptItemSize
+	add := func(v starlark.Value) bool {
+		n, ok := stateSize(v, depth+1)
+		size += n
+		return ok
+	}
+	switch v := v.(type) {
+	case starlark.String:
+		size += len(v)
+	case starlark.Bytes:
+		size += len(v)
+	case *starlark.Dict:
+		for _, kv := range v.Items() {
+			if !add(kv[0]) || !add(kv[1]) {
+				return 0, false
+			}
+		}
+	case starlark.Iterable:
+		it := v.Iterate()
+		defer it.Done()
+		var x starlark.Value
+		for it.Next(&x) {
+			if !add(x) {
+				return 0, false
+			}
+		}
+	}
+	return size, true
+}
+
+func (sc *script) builtins() starlark.StringDict {
+	return starlark.StringDict{
+		"forward":  sc.powerBuiltin("forward", func(p int32) *pb.Direction { return &pb.Direction{Dy: p} }),
+		"backward": sc.powerBuiltin("backward", func(p int32) *pb.Direction { return &pb.Direction{Dy: -p} }),
+		"right":    sc.powerBuiltin("right", func(p int32) *pb.Direction { return &pb.Direction{Dx: p} }),
+		"left":     sc.powerBuiltin("left", func(p int32) *pb.Direction { return &pb.Direction{Dx: -p} }),
+		"stop": starlark.NewBuiltin("stop", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			sc.dir = &pb.Direction{}
+			return starlark.None, nil
+		}),
+		// Direction as from joystick, for diagonal driving.
+		"drive": starlark.NewBuiltin("drive", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var dx, dy int
+			if err := starlark.UnpackArgs(b.Name(), args, kwargs, "dx", &dx, "dy", &dy); err != nil {
+				return nil, err
+			}
+			if dx < -100 || dx > 100 || dy < -100 || dy > 100 {
+				return nil, fmt.Errorf("%s: direction %d, %d out of range -100..100", b.Name(), dx, dy)
+			}
+			sc.dir = &pb.Direction{Dx: int32(dx), Dy: int32(dy)}
+			return starlark.None, nil
+		}),
+	}
+}
+
+// powerBuiltin returns builtin taking optional power, 100 by default.
+func (sc *script) powerBuiltin(name string, dir func(int32) *pb.Direction) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		power := 100
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "power?", &power); err != nil {
+			return nil, err
+		}
+		if power < 0 || power > 100 {
+			return nil, fmt.Errorf("%s: power %d out of range 0..100", b.Name(), power)
+		}
+		sc.dir = dir(int32(power))
+		return starlark.None, nil
+	})
+}
+
+// sensors returns readings passed to step function, taken from telemetry.
+// Optional devices which are missing are None.
+func (sc *script) sensors(now time.Time) *starlarkstruct.Struct {
+	s := sc.srv
+	t := &pb.Telemetry{}
+	s.odo.fill(t)
+	d := starlark.StringDict{
+		"t":       starlark.Float(now.Sub(sc.start).Seconds()),
+		"front":   starlark.MakeInt64(s.front.dist),
+		"rear":    starlark.MakeInt64(s.rear.dist),
+		"side":    starlark.None,
+		"x":       starlark.Float(t.PosX),
+		"y":       starlark.Float(t.PosY),
+		"imu":     starlark.None,
+		"battery": starlark.None,
+	}
+	if s.side != nil {
+		d["side"] = starlark.MakeInt64(s.side.dist)
+	}
+	if s.imu != nil {
+		s.imu.fill(t)
+		d["imu"] = starlark.String(t.ImuState.String())
+	}
+	if s.battery != nil {
+		s.battery.fill(t)
+		d["battery"] = starlark.MakeInt(int(t.BatteryPercent))
+	}
+	d["heading"] = starlark.Float(t.Heading)
+	return starlarkstruct.FromStringDict(starlark.String("sensors"), d)
+}
+
+func (sc *script) step(now time.Time) (*pb.Direction, error) {
+	if now.After(sc.deadline) {
+		return nil, fmt.Errorf("script %s ran out of time", sc.name)
+	}
+	// Keep distances fresh for the script whatever it does.
+	sc.srv.front.enabled = true
+	sc.srv.rear.enabled = true
+	sc.dir = &pb.Direction{}
+	s := sc.sensors(now)
+	err := sc.limited(func(th *starlark.Thread) error {
+		_, err := starlark.Call(th, sc.stepFn, starlark.Tuple{s, sc.state}, nil)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %v", sc.name, err)
+	}
+	if n, ok := stateSize(sc.state, 0); !ok || n > scriptMaxState {
+		return nil, fmt.Errorf("script %s: state grew over %d bytes", sc.name, scriptMaxState)
+	}
+	return sc.dir, nil
+}
+
+func (s *server) RunScript(ctx context.Context, in *pb.Script) (*pb.ModeResponse, error) {
+	sc, err := newScript(s, in.Name, in.Source, *scriptMaxTime)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if err := s.startMode(pb.Mode_SCRIPT, sc); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	return &pb.ModeResponse{Mode: s.currentMode()}, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// liftScriptTimeout lets calls run for long, so tests hit other limits
+// whatever the speed of the machine, e.g. under race detector.
+func liftScriptTimeout(t *testing.T) {
+	old := scriptCallTimeout
+	scriptCallTimeout = time.Hour
+	t.Cleanup(func() { scriptCallTimeout = old })
+}
+
+// testScript returns script running on a simulated bot.
+func testScript(t *testing.T, src string) *script {
+	t.Helper()
+	liftScriptTimeout(t)
+	sc, err := newScript(newSimBot(t, "room", 0).srv, "test.star", src, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
+
+func TestScriptDrives(t *testing.T) {
+	sc := testScript(t, `
+def step(s, state):
+    state["n"] = state.get("n", 0) + 1
+    if state["n"] < 3:
+        forward(50)
+`)
+	for i, want := range []int32{50, 50, 0} {
+		dir, err := sc.step(time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir.Dy != want {
+			t.Errorf("step %d: Dy %d, want %d", i, dir.Dy, want)
+		}
+	}
+}
+
+func TestNewScriptErrors(t *testing.T) {
+	liftScriptTimeout(t)
+	srv := newSimBot(t, "room", 0).srv
+	for _, tc := range []struct {
+		name, src, err string
+	}{
+		{"too large", "#" + strings.Repeat("x", scriptMaxSize), "larger than"},
+		{"no step", "x = 1\n", "step function"},
+		{"load", "load('other.star', 'f')\n", "load"},
+		{"runaway top level", "[x for x in range(1000000)]\n", "too many steps"},
+		{"memory hog top level", "x = 'x' * (64 << 20)\n", "memory"},
+		{"syntax", "def step(s, state)\n", "got newline"},
+		{"undefined", "def step(s, state):\n    fly()\n", "undefined: fly"},
+	} {
+		_, err := newScript(srv, "test.star", tc.src, time.Minute)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v, want one with %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestScriptLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name, body, err string
+	}{
+		{"runaway loop", "for i in range(100000000):\n        pass", "too many steps"},
+		{"big allocation", "s = 'x' * (64 << 20)", "memory"},
+		{"growing list", "l = []\n    for i in range(9000):\n        l.append('x' * 4096)", "memory"},
+		{"growing string", "x = ''\n    for i in range(9000):\n        x += 'x' * 4096", "memory"},
+		{"growing state", `state["l"] = state.get("l", []) + ["x" * 4096] * 100`, "state grew"},
+		{"state containing itself", `state["me"] = state`, "state grew"},
+	} {
+		sc := testScript(t, "def step(s, state):\n    "+tc.body+"\n")
+		var err error
+		// State grows over a few calls.
+		for i := 0; i < 10 && err == nil; i++ {
+			_, err = sc.step(time.Now())
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v, want one with %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+// TestScriptMemory checks that operations allocating a lot at once are
+// refused before they allocate.
+func TestScriptMemory(t *testing.T) {
+	for _, tc := range []struct {
+		name, body string
+	}{
+		{"string repetition", "x = 'x' * (1 << 30)"},
+		{"bytes repetition", "x = b'x' * (1 << 30)"},
+		{"list repetition", "x = [0] * (1 << 28)"},
+		{"repetition on the left", "x = (1 << 28) * (0,)"},
+		{"augmented repetition", "x = 'x'\n    x *= 1 << 30"},
+		{"doubling string", "x = 'x'\n    for i in range(40):\n        x += x"},
+		{"doubling list", "x = [0]\n    for i in range(40):\n        x = x + x"},
+		{"doubling in state", "state['x'] = [0]\n    for i in range(40):\n        state['x'] += state['x']"},
+		{"join", "x = ','.join(['x' * 1000] * 100000)"},
+		{"replace", "x = ('x' * 10000).replace('x', 'x' * 10000)"},
+		{"format", "x = ('%s' * 100) % (('x' * 1000000,) * 100)"},
+		{"format method", "x = ('{0}' * 100).format('x' * 1000000)"},
+		{"range to list", "x = list(range(1 << 30))"},
+		{"extend", "x = []\n    x.extend(range(1 << 30))"},
+		{"split", "x = ('x,' * 1000000).split(',')"},
+		{"reversed slices", "x = 'x' * 1000000\n    y = [x[::-1] for i in range(20)]"},
+		{"repr of shared lists", "x = [0]\n    for i in range(40):\n        x = [x, x]\n    y = str(x)"},
+		{"print", "print(['x' * 1000000] * 20)"},
+	} {
+		sc := testScript(t, "def step(s, state):\n    "+tc.body+"\n")
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := sc.step(time.Now())
+		runtime.ReadMemStats(&after)
+		if err == nil || !strings.Contains(err.Error(), "memory") {
+			t.Errorf("%s: error %v, want one with %q", tc.name, err, "memory")
+		}
+		// Allowed allocations, the simulated bot and runtime add a little.
+		if n := after.TotalAlloc - before.TotalAlloc; n > 4*scriptMaxAlloc {
+			t.Errorf("%s: allocated %d bytes, want at most about %d", tc.name, n, scriptMaxAlloc)
+		}
+	}
+}
+
+func TestScriptMemoryOfThread(t *testing.T) {
+	sc := testScript(t, "def step(s, state):\n    x = 'x' * (12 << 20)\n    forward(30)\n")
+	// Memory budget is per call and counts only allocations of the script,
+	// not of the server or other goroutines.
+	for i := 0; i < 3; i++ {
+		var b []byte
+		err := sc.limited(func(th *starlark.Thread) error {
+			b = make([]byte, 4*scriptMaxAlloc)
+			_, err := starlark.Call(th, sc.stepFn, starlark.Tuple{starlark.None, sc.state}, nil)
+			return err
+		})
+		runtime.KeepAlive(b)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+}
+
+// TestScriptRewrite checks that operations rewritten for memory checks work
+// as in Starlark.
+func TestScriptRewrite(t *testing.T) {
+	sc := testScript(t, `
+def twice(x, n=2):
+    return x * n
+
+def step(s, state):
+    l = state.setdefault("l", [])
+    l += [len(l)]
+    state["n"] = state.get("n", 0)
+    state["n"] += 2
+    state["s"] = "%d:%s" % (state["n"], ",".join([str(x) for x in l[::-1]]))
+    state["t"] = twice("ab") + twice(x="c", n=3) + "xyz"[1:] + str([l[-1:], (1,) * 2])
+    forward(*[30])
+`)
+	for i := 0; i < 3; i++ {
+		dir, err := sc.step(time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir.Dy != 30 {
+			t.Errorf("step %d: Dy %d, want 30", i, dir.Dy)
+		}
+	}
+	for k, want := range map[string]string{
+		"l": "[0, 1, 2]",
+		"s": `"6:2,1,0"`,
+		"t": `"ababcccyz[[2], (1, 1)]"`,
+	} {
+		v, _, err := sc.state.Get(starlark.String(k))
+		if err != nil || v == nil || v.String() != want {
+			t.Errorf("state[%q] = %v, want %s", k, v, want)
+		}
+	}
+	sc = testScript(t, "def step(s, state):\n    state['missing'] += 1\n")
+	if _, err := sc.step(time.Now()); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Errorf("adding to missing key: error %v", err)
+	}
+}
+
+func TestScriptTimeout(t *testing.T) {
+	sc := testScript(t, "def step(s, state):\n    forward(30)\n")
+	scriptCallTimeout = defaultBehaviorDur / 2
+	// Call running past timeout is cancelled.
+	err := sc.limited(func(th *starlark.Thread) error {
+		time.Sleep(scriptCallTimeout * 2)
+		_, err := starlark.Call(th, sc.stepFn, starlark.Tuple{starlark.None, sc.state}, nil)
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "took too long") {
+		t.Errorf("slow call error %v, want timeout", err)
+	}
+	// Call ending just as timeout fires doesn't cancel the ones after.
+	sc.limited(func(*starlark.Thread) error {
+		time.Sleep(scriptCallTimeout)
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		dir, err := sc.step(time.Now())
+		if err != nil {
+			t.Fatalf("step after timeout: %v", err)
+		}
+		if dir.Dy != 30 {
+			t.Errorf("step after timeout: Dy %d, want 30", dir.Dy)
+		}
+		time.Sleep(scriptCallTimeout / 4)
+	}
+}
+
+func TestScriptRunsOutOfTime(t *testing.T) {
+	sc := testScript(t, "def step(s, state):\n    pass\n")
+	if _, err := sc.step(time.Now().Add(2 * time.Minute)); err == nil {
+		t.Error("step past script's running time succeeded")
+	}
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Starlark operations which can allocate a lot at once, e.g. string repetition
+// or doubling a list in a loop, are checked before they run. Script syntax tree
+// is rewritten, so that they call builtins below, which charge their rough size
+// to memory budget of the thread and only then run the operation. Names of the
+// builtins can't be written in scripts.
+
+// scriptItemSize is rough size in bytes of an item of list, tuple or dict.
+const scriptItemSize = 16
+
+// scriptMemKey is thread local with bytes the call can still allocate.
+const scriptMemKey = "memory"
+
+// Operators checked before they run, the ones growing strings and lists.
+var checkedOps = map[syntax.Token]bool{
+	syntax.PLUS:    true,
+	syntax.STAR:    true,
+	syntax.PERCENT: true,
+}
+
+// Augmented assignments of checked operators.
+var checkedAugOps = map[syntax.Token]syntax.Token{
+	syntax.PLUS_EQ:    syntax.PLUS,
+	syntax.STAR_EQ:    syntax.STAR,
+	syntax.PERCENT_EQ: syntax.PERCENT,
+}
+
+// opNames maps operator passed to builtins back to token.
+var opNames = map[string]syntax.Token{
+	syntax.PLUS.String():    syntax.PLUS,
+	syntax.STAR.String():    syntax.STAR,
+	syntax.PERCENT.String(): syntax.PERCENT,
+}
+
+// memBuiltins are predeclared for every script, next to driver builtins.
+var memBuiltins = starlark.StringDict{
+	"$binary":        starlark.NewBuiltin("$binary", binaryBuiltin),
+	"$augment":       starlark.NewBuiltin("$augment", augmentBuiltin),
+	"$augment_index": starlark.NewBuiltin("$augment_index", augmentIndexBuiltin),
+	"$slice":         starlark.NewBuiltin("$slice", sliceBuiltin),
+	"$call":          starlark.NewBuiltin("$call", callBuiltin),
+}
+
+// memHelpers run operations in Starlark, with its semantics, after their size
+// was charged.
+var memHelpers = func() starlark.StringDict {
+	g, err := starlark.ExecFileOptions(&syntax.FileOptions{}, &starlark.Thread{}, "memory.star", `
+def slice(x, lo, hi, step):
+    return x[lo:hi:step]
+
+def extend(x, y):
+    x += y
+    return x
+
+def augment_index(augment, op, x, i, y):
+    x[i] = augment(op, x[i], y)
+`, nil)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}()
+
+// charge takes n bytes from memory budget of the thread, before they are
+// allocated.
+func charge(th *starlark.Thread, n int64) error {
+	left, ok := th.Local(scriptMemKey).(*int64)
+	if !ok {
+		return nil
+	}
+	if n > *left {
+		return fmt.Errorf("would allocate over %d bytes of memory", scriptMaxAlloc)
+	}
+	*left -= n
+	return nil
+}
+
+// budget returns bytes the thread can still allocate. It limits the work of
+// estimating sizes of huge values.
+func budget(th *starlark.Thread) int64 {
+	if left, ok := th.Local(scriptMemKey).(*int64); ok {
+		return *left
+	}
+	return scriptMaxAlloc
+}
+
+// checkAllocs rewrites statements, so that operations which can allocate a
+// lot go through memory checking builtins.
+func checkAllocs(stmts []syntax.Stmt) {
+	for i, s := range stmts {
+		stmts[i] = checkStmt(s)
+	}
+}
+
+func checkStmt(s syntax.Stmt) syntax.Stmt {
+	switch s := s.(type) {
+	case *syntax.AssignStmt:
+		s.RHS = checkExpr(s.RHS)
+		if op, ok := checkedAugOps[s.Op]; ok {
+			switch lhs := s.LHS.(type) {
+			case *syntax.Ident:
+				// x op= y becomes x = $augment(op, x, y).
+				x := &syntax.Ident{NamePos: lhs.NamePos, Name: lhs.Name}
+				s.Op = syntax.EQ
+				s.RHS = checkCall("$augment", s.OpPos, opLiteral(op, s.OpPos), x, s.RHS)
+				return s
+			case *syntax.IndexExpr:
+				// x[i] op= y becomes $augment_index(op, x, i, y), which
+				// evaluates x and i once.
+				return &syntax.ExprStmt{X: checkCall("$augment_index", s.OpPos, opLiteral(op, s.OpPos),
+					checkExpr(lhs.X), checkExpr(lhs.Y), s.RHS)}
+			}
+			// Fields of values reaching scripts can't be set, so the rest
+			// fails without keeping what it allocated.
+		}
+		s.LHS = checkTarget(s.LHS)
+	case *syntax.DefStmt:
+		checkExprs(s.Params)
+		checkAllocs(s.Body)
+	case *syntax.ExprStmt:
+		s.X = checkExpr(s.X)
+	case *syntax.IfStmt:
+		s.Cond = checkExpr(s.Cond)
+		checkAllocs(s.True)
+		checkAllocs(s.False)
+	case *syntax.ForStmt:
+		s.Vars = checkTarget(s.Vars)
+		s.X = checkExpr(s.X)
+		checkAllocs(s.Body)
+	case *syntax.WhileStmt:
+		s.Cond = checkExpr(s.Cond)
+		checkAllocs(s.Body)
+	case *syntax.ReturnStmt:
+		if s.Result != nil {
+			s.Result = checkExpr(s.Result)
+		}
+	}
+	return s
+}
+
+// checkTarget rewrites expressions inside assignment target, leaving the
+// target itself.
+func checkTarget(e syntax.Expr) syntax.Expr {
+	switch e := e.(type) {
+	case *syntax.IndexExpr:
+		e.X = checkExpr(e.X)
+		e.Y = checkExpr(e.Y)
+	case *syntax.DotExpr:
+		e.X = checkExpr(e.X)
+	case *syntax.ParenExpr:
+		e.X = checkTarget(e.X)
+	case *syntax.ListExpr:
+		for i, x := range e.List {
+			e.List[i] = checkTarget(x)
+		}
+	case *syntax.TupleExpr:
+		for i, x := range e.List {
+			e.List[i] = checkTarget(x)
+		}
+	}
+	return e
+}
+
+func checkExprs(es []syntax.Expr) {
+	for i, e := range es {
+		es[i] = checkExpr(e)
+	}
+}
+
+// checkExpr returns expression with checked operations replaced by calls to
+// memory checking builtins. Keyword arguments and parameter defaults are
+// binary expressions with = operator, which stays.
+func checkExpr(e syntax.Expr) syntax.Expr {
+	switch e := e.(type) {
+	case *syntax.BinaryExpr:
+		e.X = checkExpr(e.X)
+		e.Y = checkExpr(e.Y)
+		if checkedOps[e.Op] {
+			return checkCall("$binary", e.OpPos, opLiteral(e.Op, e.OpPos), e.X, e.Y)
+		}
+	case *syntax.CallExpr:
+		checkExprs(e.Args)
+		return &syntax.CallExpr{
+			Fn:     &syntax.Ident{NamePos: e.Lparen, Name: "$call"},
+			Lparen: e.Lparen,
+			Args:   append([]syntax.Expr{checkExpr(e.Fn)}, e.Args...),
+			Rparen: e.Rparen,
+		}
+	case *syntax.SliceExpr:
+		args := []syntax.Expr{checkExpr(e.X)}
+		for _, x := range []syntax.Expr{e.Lo, e.Hi, e.Step} {
+			if x == nil {
+				x = &syntax.Ident{NamePos: e.Lbrack, Name: "None"}
+			}
+			args = append(args, checkExpr(x))
+		}
+		return checkCall("$slice", e.Lbrack, args...)
+	case *syntax.Comprehension:
+		e.Body = checkExpr(e.Body)
+		for _, c := range e.Clauses {
+			switch c := c.(type) {
+			case *syntax.ForClause:
+				c.Vars = checkTarget(c.Vars)
+				c.X = checkExpr(c.X)
+			case *syntax.IfClause:
+				c.Cond = checkExpr(c.Cond)
+			}
+		}
+	case *syntax.CondExpr:
+		e.Cond = checkExpr(e.Cond)
+		e.True = checkExpr(e.True)
+		e.False = checkExpr(e.False)
+	case *syntax.DictExpr:
+		checkExprs(e.List)
+	case *syntax.DictEntry:
+		e.Key = checkExpr(e.Key)
+		e.Value = checkExpr(e.Value)
+	case *syntax.DotExpr:
+		e.X = checkExpr(e.X)
+	case *syntax.IndexExpr:
+		e.X = checkExpr(e.X)
+		e.Y = checkExpr(e.Y)
+	case *syntax.LambdaExpr:
+		checkExprs(e.Params)
+		e.Body = checkExpr(e.Body)
+	case *syntax.ListExpr:
+		checkExprs(e.List)
+	case *syntax.TupleExpr:
+		checkExprs(e.List)
+	case *syntax.ParenExpr:
+		e.X = checkExpr(e.X)
+	case *syntax.UnaryExpr:
+		if e.X != nil {
+			e.X = checkExpr(e.X)
+		}
+	}
+	return e
+}
+
+// checkCall returns call of builtin at pos, which errors point to.
+func checkCall(name string, pos syntax.Position, args ...syntax.Expr) *syntax.CallExpr {
+	return &syntax.CallExpr{
+		Fn:     &syntax.Ident{NamePos: pos, Name: name},
+		Lparen: pos,
+		Args:   args,
+		Rparen: pos,
+	}
+}
+
+func opLiteral(op syntax.Token, pos syntax.Position) *syntax.Literal {
+	return &syntax.Literal{Token: syntax.STRING, TokenPos: pos, Raw: strconv.Quote(op.String()), Value: op.String()}
+}
+
+func unpackOp(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, n int, vars ...any) (syntax.Token, error) {
+	var name string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, n, append([]any{&name}, vars...)...); err != nil {
+		return 0, err
+	}
+	op, ok := opNames[name]
+	if !ok {
+		return 0, fmt.Errorf("%s: unknown operator %q", b.Name(), name)
+	}
+	return op, nil
+}
+
+// binaryBuiltin runs x op y.
+func binaryBuiltin(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y starlark.Value
+	op, err := unpackOp(b, args, kwargs, 3, &x, &y)
+	if err != nil {
+		return nil, err
+	}
+	return binaryOp(th, op, x, y)
+}
+
+func binaryOp(th *starlark.Thread, op syntax.Token, x, y starlark.Value) (starlark.Value, error) {
+	if err := charge(th, binarySize(op, x, y, budget(th))); err != nil {
+		return nil, err
+	}
+	return starlark.Binary(op, x, y)
+}
+
+// augmentBuiltin returns x op y for x op= y. Like in Starlark, list += extends
+// the list in place.
+func augmentBuiltin(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, y starlark.Value
+	op, err := unpackOp(b, args, kwargs, 3, &x, &y)
+	if err != nil {
+		return nil, err
+	}
+	return augmentOp(th, op, x, y)
+}
+
+func augmentOp(th *starlark.Thread, op syntax.Token, x, y starlark.Value) (starlark.Value, error) {
+	if _, ok := x.(*starlark.List); !ok || op != syntax.PLUS {
+		return binaryOp(th, op, x, y)
+	}
+	if err := charge(th, size(y)); err != nil {
+		return nil, err
+	}
+	return starlark.Call(th, memHelpers["extend"], starlark.Tuple{x, y}, nil)
+}
+
+// augmentIndexBuiltin runs x[i] op= y.
+func augmentIndexBuiltin(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var op string
+	var x, i, y starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 4, &op, &x, &i, &y); err != nil {
+		return nil, err
+	}
+	args = starlark.Tuple{starlark.NewBuiltin("$augment", augmentBuiltin), starlark.String(op), x, i, y}
+	return starlark.Call(th, memHelpers["augment_index"], args, nil)
+}
+
+// sliceBuiltin returns x[lo:hi:step].
+func sliceBuiltin(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x, lo, hi, step starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 4, &x, &lo, &hi, &step); err != nil {
+		return nil, err
+	}
+	if err := charge(th, sliceSize(x, lo, hi, step)); err != nil {
+		return nil, err
+	}
+	return starlark.Call(th, memHelpers["slice"], args, nil)
+}
+
+// callBuiltin calls its first argument with the rest.
+func callBuiltin(th *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing function", b.Name())
+	}
+	fn, args := args[0], args[1:]
+	if err := charge(th, callSize(fn, args, kwargs, budget(th))); err != nil {
+		return nil, err
+	}
+	return starlark.Call(th, fn, args, kwargs)
+}
+
+// size returns rough size in bytes of v itself, without values it holds.
+func size(v starlark.Value) int64 {
+	switch v := v.(type) {
+	case starlark.String:
+		return int64(len(v))
+	case starlark.Bytes:
+		return int64(len(v))
+	case starlark.Int:
+		if _, ok := v.Int64(); !ok {
+			return int64(v.BigInt().BitLen()/8) + scriptItemSize
+		}
+	case starlark.Sequence:
+		return int64(v.Len()) * scriptItemSize
+	}
+	return scriptItemSize
+}
+
+// binarySize returns rough size in bytes of x op y.
+func binarySize(op syntax.Token, x, y starlark.Value, limit int64) int64 {
+	switch op {
+	case syntax.STAR:
+		if n, ok := repeatSize(x, y); ok {
+			return n
+		}
+		if n, ok := repeatSize(y, x); ok {
+			return n
+		}
+	case syntax.PERCENT:
+		if f, ok := x.(starlark.String); ok {
+			args := starlark.Tuple{y}
+			switch y := y.(type) {
+			case starlark.Tuple:
+				args = y
+			case *starlark.Dict:
+				args = nil
+				for _, kv := range y.Items() {
+					args = append(args, kv[1])
+				}
+			}
+			return formatSize(string(f), "%", args, limit)
+		}
+	}
+	return size(x) + size(y)
+}
+
+// repeatSize returns size of sequence repeated n times.
+func repeatSize(seq, n starlark.Value) (int64, bool) {
+	switch seq.(type) {
+	case starlark.String, starlark.Bytes, *starlark.List, starlark.Tuple:
+	default:
+		return 0, false
+	}
+	i, err := starlark.AsInt32(n)
+	if err != nil {
+		// Starlark refuses it.
+		return 0, true
+	}
+	return size(seq) * int64(max(i, 0)), true
+}
+
+// formatSize returns rough length of format f with any of args at every
+// occurrence of verb.
+func formatSize(f, verb string, args starlark.Tuple, limit int64) int64 {
+	var arg int64
+	for _, a := range args {
+		arg = max(arg, reprSize(limit, a))
+	}
+	return int64(len(f)) + int64(strings.Count(f, verb))*arg
+}
+
+// sliceSize returns rough size of x[lo:hi:step]. Strings sliced without step
+// share the bytes.
+func sliceSize(x, lo, hi, step starlark.Value) int64 {
+	st := 1
+	if step != starlark.None {
+		var err error
+		if st, err = starlark.AsInt32(step); err != nil || st == 0 {
+			return 0
+		}
+	}
+	item := int64(scriptItemSize)
+	switch x.(type) {
+	case starlark.String, starlark.Bytes:
+		if st == 1 {
+			return 0
+		}
+		// Built byte by byte, growing the buffer on the way.
+		item = 4
+	case *starlark.List, starlark.Tuple:
+	default:
+		return 0
+	}
+	n := x.(starlark.Indexable).Len()
+	index := func(v starlark.Value, def, lowest int) int {
+		i, err := starlark.AsInt32(v)
+		if v == starlark.None || err != nil {
+			return def
+		}
+		if i < 0 {
+			i += n
+		}
+		return min(max(i, lowest), n+lowest)
+	}
+	var count int
+	if st > 0 {
+		start, end := index(lo, 0, 0), index(hi, n, 0)
+		if end > start {
+			count = (end - start + st - 1) / st
+		}
+	} else {
+		start, end := index(lo, n-1, -1), index(hi, -1, -1)
+		if start > end {
+			count = (start - end - st - 1) / -st
+		}
+	}
+	return int64(count) * item
+}
+
+// callSize returns rough size in bytes allocated by calling fn with args.
+// Functions of scripts are charged only for their arguments, what they do is
+// checked as they run.
+func callSize(fn starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int64) int64 {
+	b, ok := fn.(*starlark.Builtin)
+	if !ok {
+		return int64(len(args)+len(kwargs)) * scriptItemSize
+	}
+	if recv := b.Receiver(); recv != nil {
+		return methodSize(b.Name(), recv, args, kwargs, limit)
+	}
+	switch b.Name() {
+	case "str":
+		if len(args) == 1 {
+			if _, ok := args[0].(starlark.String); ok {
+				return 0
+			}
+		}
+		return reprSize(limit, args...)
+	case "repr", "print", "fail":
+		return reprSize(limit, args...)
+	case "len", "type", "bool", "int", "float", "hash", "getattr", "hasattr", "abs", "min", "max", "any", "all",
+		"range", "chr", "ord":
+		return 0
+	case "enumerate", "zip":
+		// Lists of tuples.
+		return 3 * argsSize(args, kwargs)
+	}
+	return argsSize(args, kwargs)
+}
+
+// methodSize returns rough size in bytes allocated by method of recv.
+func methodSize(name string, recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int64) int64 {
+	switch recv := recv.(type) {
+	case starlark.String:
+		s := string(recv)
+		switch name {
+		case "join":
+			if len(args) == 1 {
+				return joinSize(s, args[0], limit)
+			}
+		case "replace":
+			if len(args) >= 2 {
+				old, _ := args[0].(starlark.String)
+				repl, _ := args[1].(starlark.String)
+				return int64(len(s)) + int64(strings.Count(s, string(old)))*int64(len(repl))
+			}
+		case "format":
+			for _, kv := range kwargs {
+				args = append(args, kv[1])
+			}
+			return formatSize(s, "{", args, limit)
+		case "split", "rsplit", "splitlines", "partition", "rpartition",
+			"elems", "elem_ords", "codepoints", "codepoint_ords":
+			return int64(len(s)+1) * scriptItemSize
+		case "capitalize", "lower", "upper", "title", "strip", "lstrip", "rstrip":
+			return int64(len(s))
+		}
+		return 0
+	case *starlark.List:
+		switch name {
+		case "extend":
+			return argsSize(args, kwargs)
+		case "append", "insert":
+			return scriptItemSize
+		}
+		return 0
+	case *starlark.Dict:
+		switch name {
+		case "items":
+			return 3 * size(recv)
+		case "keys", "values":
+			return size(recv)
+		case "get", "pop", "popitem", "clear":
+			return 0
+		}
+	}
+	return argsSize(args, kwargs)
+}
+
+func argsSize(args starlark.Tuple, kwargs []starlark.Tuple) int64 {
+	var n int64
+	for _, a := range args {
+		n += size(a)
+	}
+	return n + int64(len(kwargs))*scriptItemSize
+}
+
+// joinSize returns rough length of strings in iterable joined with sep.
+func joinSize(sep string, iterable starlark.Value, limit int64) int64 {
+	it, ok := iterable.(starlark.Iterable)
+	if !ok {
+		return 0
+	}
+	iter := it.Iterate()
+	defer iter.Done()
+	var n int64
+	var v starlark.Value
+	for n <= limit && iter.Next(&v) {
+		s, ok := v.(starlark.String)
+		if !ok {
+			// Starlark refuses it.
+			return 0
+		}
+		n += int64(len(s) + len(sep))
+	}
+	return n
+}
+
+// reprSize returns rough length of repr of values. Walking stops once it's
+// over limit, which bounds the work for huge values or lists sharing other
+// lists many times.
+func reprSize(limit int64, vs ...starlark.Value) int64 {
+	var n int64
+	path := make(map[starlark.Value]bool) // Lists and dicts being walked, repr cuts cycles short.
+	var walk func(v starlark.Value)
+	walk = func(v starlark.Value) {
+		if n > limit {
+			return
+		}
+		switch v := v.(type) {
+		case starlark.String:
+			n += int64(len(v)) + 2
+		case starlark.Bytes:
+			n += int64(len(v)) + 3
+		case starlark.Int:
+			if _, ok := v.Int64(); ok {
+				n += 20
+			} else {
+				n += size(v) * 3
+			}
+		case starlark.Tuple:
+			n += 2
+			for _, x := range v {
+				n += 2
+				walk(x)
+			}
+		case *starlark.List, *starlark.Dict:
+			if path[v] {
+				n += 5
+				return
+			}
+			path[v] = true
+			defer delete(path, v)
+			n += 2
+			it := v.(starlark.Iterable).Iterate()
+			defer it.Done()
+			var x starlark.Value
+			for it.Next(&x) {
+				n += 2
+				walk(x)
+				if d, ok := v.(*starlark.Dict); ok {
+					y, _, _ := d.Get(x)
+					walk(y)
+				}
+			}
+		default:
+			n += 32
+		}
+	}
+	for _, v := range vs {
+		n++
+		walk(v)
+	}
+	return n
+}
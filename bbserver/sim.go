@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kidoman/embd"
+	log "github.com/sirupsen/logrus"
+)
+
+// Simulated host, registered with embd so the rest of bbserver runs unchanged
+// off-hardware. Engine pins are plain memory and bot moves exactly as odometry
+// says. Echo pins report distance to the nearest wall of a map.
+const hostSim embd.Host = "berrybot-sim"
+
+const (
+	simPins       = 28
+	simMaxDist    = 400.0 // HC-SR04 range in cm.
+	simSensorDist = 7.0   // Sensor distance from bot center in cm.
+	simBotRadius  = 9.0   // Bot closer to a wall than that hits it.
+	simBeamAngle  = 15.0  // HC-SR04 beam half-angle in degrees.
+	simBeamRays   = 5
+	defaultSimDur = time.Millisecond * 50
+)
+
+// Active simulated world, nil when running on real hardware. embd pin
+// factories get no context, so it has to be global.
+var sim *simWorld
+
+func init() {
+	embd.Register(hostSim, func(rev int) *embd.Descriptor {
+		return &embd.Descriptor{
+			GPIODriver: func() embd.GPIODriver {
+				pins := make(embd.PinMap, simPins)
+				for i := range pins {
+					pins[i] = &embd.PinDesc{ID: strconv.Itoa(i), Caps: embd.CapDigital, DigitalLogical: i}
+				}
+				return embd.NewGPIODriver(pins, newSimPin, nil, nil)
+			},
+		}
+	})
+}
+
+// Wall segment in cm, in the same coordinates as odometry: bot starts at 0,0
+// facing along Y.
+type simWall struct {
+	X1, Y1, X2, Y2 float64
+}
+
+type simWorld struct {
+	Walls []simWall
+
+	mu     sync.Mutex
+	odo    *odometry
+	echoes map[int]float64 // Echo pin to sensor mounting angle in degrees.
+}
+
+// Built-in maps usable by name instead of JSON file.
+var simMaps = map[string][]simWall{
+	// Empty 3x3m room with bot in the middle.
+	"room": {
+		{-150, -150, 150, -150}, {150, -150, 150, 150},
+		{150, 150, -150, 150}, {-150, 150, -150, -150},
+	},
+	// Closed corridor, 87cm wide, around a 226x426cm block. Bot starts
+	// heading north along its west side, with right side echo 30cm from the
+	// block.
+	"corridor": {
+		{-50, -200, 350, -200}, {350, -200, 350, 400},
+		{350, 400, -50, 400}, {-50, 400, -50, -200},
+		{37, -113, 263, -113}, {263, -113, 263, 313},
+		{263, 313, 37, 313}, {37, 313, 37, -113},
+	},
+}
+
+// loadSimWorld returns built-in map by name or reads walls from JSON file
+// containing a list of {"X1", "Y1", "X2", "Y2"} objects.
+func loadSimWorld(name string) (*simWorld, error) {
+	w := &simWorld{echoes: make(map[int]float64)}
+	if walls, ok := simMaps[name]; ok {
+		w.Walls = walls
+		return w, nil
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't read sim map: %v", err)
+	}
+	if err := json.Unmarshal(b, &w.Walls); err != nil {
+		return nil, fmt.Errorf("can't parse sim map: %v", err)
+	}
+	return w, nil
+}
+
+// addEcho mounts simulated echo sensor on the bot at angle relative to heading.
+func (w *simWorld) addEcho(pin int, angle float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.echoes[pin] = angle
+}
+
+func (w *simWorld) setOdometry(o *odometry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.odo = o
+}
+
+// distance casts rays across the beam of echo sensor on pin and returns
+// distance to the nearest wall in cm.
+func (w *simWorld) distance(pin int) float64 {
+	w.mu.Lock()
+	angle, ok := w.echoes[pin]
+	odo := w.odo
+	w.mu.Unlock()
+	if !ok || odo == nil {
+		return simMaxDist
+	}
+	x, y, heading := odo.pose()
+	return w.beam(x, y, heading+angle)
+}
+
+// beam returns distance in cm to the nearest wall seen by echo sensor of bot
+// at x, y, pointing at angle in degrees.
+func (w *simWorld) beam(x, y, angle float64) float64 {
+	rad := angle * math.Pi / 180
+	x += math.Sin(rad) * simSensorDist
+	y += math.Cos(rad) * simSensorDist
+
+	best := simMaxDist
+	for i := 0; i < simBeamRays; i++ {
+		a := angle - simBeamAngle + 2*simBeamAngle*float64(i)/(simBeamRays-1)
+		if d := w.cast(x, y, a); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// cast returns distance in cm from x, y to the nearest wall in direction of
+// angle in degrees, or simMaxDist if it's out of range.
+func (w *simWorld) cast(x, y, angle float64) float64 {
+	rad := angle * math.Pi / 180
+	dx, dy := math.Sin(rad), math.Cos(rad)
+	best := simMaxDist
+	for _, wall := range w.Walls {
+		// Solve x + t*dx = X1 + u*(X2-X1), y + t*dy = Y1 + u*(Y2-Y1).
+		ex, ey := wall.X2-wall.X1, wall.Y2-wall.Y1
+		den := dx*ey - dy*ex
+		if den == 0 {
+			continue
+		}
+		t := ((wall.X1-x)*ey - (wall.Y1-y)*ex) / den
+		u := ((wall.X1-x)*dy - (wall.Y1-y)*dx) / den
+		if t >= 0 && u >= 0 && u <= 1 && t < best {
+			best = t
+		}
+	}
+	return best
+}
+
+// Goroutine detecting collisions with walls in an infinite loop, so driving
+// and autonomous modes can be verified in simulation. Bot passes through walls
+// anyway, as odometry knows nothing about them.
+func (w *simWorld) run() {
+	ticker := time.NewTicker(defaultSimDur)
+	defer ticker.Stop()
+	var hit bool
+	for range ticker.C {
+		w.mu.Lock()
+		odo := w.odo
+		w.mu.Unlock()
+		if odo == nil {
+			continue
+		}
+		x, y, _ := odo.pose()
+		near := w.clearance(x, y) < simBotRadius
+		if near && !hit {
+			simCollisions.Inc()
+			log.Warnf("Simulated bot hit a wall at %.0f,%.0f", x, y)
+		}
+		hit = near
+	}
+}
+
+// clearance returns distance in cm from point to the nearest wall.
+func (w *simWorld) clearance(x, y float64) float64 {
+	best := math.Inf(1)
+	for _, wall := range w.Walls {
+		ex, ey := wall.X2-wall.X1, wall.Y2-wall.Y1
+		// Project point on wall segment.
+		var u float64
+		if l := ex*ex + ey*ey; l > 0 {
+			u = math.Max(0, math.Min(1, ((x-wall.X1)*ex+(y-wall.Y1)*ey)/l))
+		}
+		if d := math.Hypot(x-wall.X1-u*ex, y-wall.Y1-u*ey); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// Simulated digital pin.
+type simPin struct {
+	n   int
+	drv embd.GPIODriver
+
+	mu  sync.Mutex
+	val int
+}
+
+func newSimPin(pd *embd.PinDesc, drv embd.GPIODriver) embd.DigitalPin {
+	return &simPin{n: pd.DigitalLogical, drv: drv}
+}
+
+func (p *simPin) N() int { return p.n }
+
+func (p *simPin) Write(val int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.val = val
+	return nil
+}
+
+func (p *simPin) Read() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.val, nil
+}
+
+// TimePulse returns echo pulse length for distance to the nearest wall, the
+// way HC-SR04 would.
+func (p *simPin) TimePulse(state int) (time.Duration, error) {
+	if sim == nil {
+		return 0, fmt.Errorf("no simulated world")
+	}
+	d := sim.distance(p.n)
+	// Inverse of distance calculation in echo.measure.
+	dur := time.Duration(d * 2 * 1000 / 34 * 1000)
+	time.Sleep(dur)
+	return dur, nil
+}
+
+func (p *simPin) SetDirection(dir embd.Direction) error { return nil }
+func (p *simPin) ActiveLow(b bool) error                { return nil }
+func (p *simPin) PullUp() error                         { return nil }
+func (p *simPin) PullDown() error                       { return nil }
+func (p *simPin) Watch(edge embd.Edge, h func(embd.DigitalPin)) error {
+	return embd.ErrFeatureNotSupported
+}
+func (p *simPin) StopWatching() error { return nil }
+func (p *simPin) Close() error        { return nil }
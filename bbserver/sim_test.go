@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+// Simulation step, echo sensors measure every few of them like in fast mode.
+const (
+	simTestTick    = time.Millisecond * 50
+	simTestMeasure = 5
+)
+
+// simBot runs behaviors on a bot in simulated world in virtual time, much
+// faster than bbserver -sim and without GPIO.
+type simBot struct {
+	t     *testing.T
+	world *simWorld
+	srv   *server
+	now   time.Time
+
+	hits     int     // Collisions with walls.
+	hit      bool    // Touching a wall now.
+	travel   float64 // Distance driven in cm.
+	maxPower int32   // Highest power of any engine.
+}
+
+// newSimBot puts bot in the start position of a built-in map, with side echo
+// at sideAngle unless it's 0.
+func newSimBot(t *testing.T, name string, sideAngle float64) *simBot {
+	t.Helper()
+	w, err := loadSimWorld(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := &driver{left: testEngine("left"), right: testEngine("right")}
+	s := &server{
+		front:  &echo{name: "front"},
+		rear:   &echo{name: "rear", angle: 180},
+		driver: drv,
+		odo:    &odometry{driver: drv},
+	}
+	if sideAngle != 0 {
+		s.side = &echo{name: "side", angle: sideAngle}
+	}
+	b := &simBot{t: t, world: w, srv: s, now: time.Now()}
+	b.measure()
+	return b
+}
+
+// testEngine returns engine on simulated pins, without PWM running.
+func testEngine(name string) *engine {
+	return &engine{
+		name:   name,
+		fwdPin: &simPin{},
+		pwrPin: &simPin{},
+		health: newSubsystem("engine."+name, time.Second),
+	}
+}
+
+// measure updates all echoes with distances to walls.
+func (b *simBot) measure() {
+	x, y, heading := b.srv.odo.pose()
+	for _, e := range []*echo{b.srv.front, b.srv.rear, b.srv.side} {
+		if e == nil {
+			continue
+		}
+		e.dist = int64(b.world.beam(x, y, heading+e.angle))
+		e.last = b.now
+	}
+}
+
+// run drives with behavior for duration d of virtual time. Behavior failing
+// fails the test, finishing ends the run.
+func (b *simBot) run(beh behavior, d time.Duration) {
+	b.t.Helper()
+	for i, end := 1, b.now.Add(d); b.now.Before(end); i++ {
+		b.now = b.now.Add(simTestTick)
+		x0, y0, _ := b.srv.odo.pose()
+		b.srv.odo.update(simTestTick.Seconds())
+		x, y, _ := b.srv.odo.pose()
+		b.travel += math.Hypot(x-x0, y-y0)
+		near := b.world.clearance(x, y) < simBotRadius
+		if near && !b.hit {
+			b.hits++
+			b.t.Logf("hit a wall at %.0f,%.0f after %v", x, y, time.Duration(i)*simTestTick)
+		}
+		b.hit = near
+
+		if i%simTestMeasure == 0 {
+			b.measure()
+		}
+		if i%int(defaultBehaviorDur/simTestTick) != 0 {
+			continue
+		}
+		dir, err := beh.step(b.now)
+		if err == errBehaviorDone {
+			b.srv.drive(&pb.Direction{})
+			return
+		}
+		if err != nil {
+			b.t.Fatalf("behavior failed after %v: %v", time.Duration(i)*simTestTick, err)
+		}
+		b.srv.drive(dir)
+		b.maxPower = max(b.maxPower, b.srv.driver.left.pwr, b.srv.driver.right.pwr)
+	}
+}
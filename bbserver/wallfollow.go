@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+// Wall following gains, on distance error in cm. Output is fraction of time
+// spent turning, see wallFollow.step.
+const (
+	wallKp = 0.02
+	wallKi = 0.001
+	wallKd = 0.03
+)
+
+const (
+	wallPower    = 40  // Forward power, half speed gives PID time to react.
+	wallMaxTurn  = 0.8 // Output limit, lower makes wider turns around outer corners.
+	wallTurnDx   = 30  // Also power of turns in place.
+	wallBlocked  = 25  // Front obstacle closer than that makes bot turn away from wall.
+	wallClear    = 50
+	wallStale    = defaultSlowDur * 2 // Readings older than that stop the mode.
+	wallMaxDtSec = 1.0                // Longer gaps between readings restart PID.
+)
+
+// pid is a PID controller with integral clamped to output limit.
+type pid struct {
+	kp, ki, kd float64
+	limit      float64
+
+	integral float64
+	prevErr  float64
+	started  bool
+}
+
+func (p *pid) update(err, dt float64) float64 {
+	var deriv float64
+	if p.started && dt > 0 {
+		deriv = (err - p.prevErr) / dt
+	}
+	p.prevErr = err
+	p.started = true
+	p.integral += err * dt
+	if lim := p.limit / p.ki; math.Abs(p.integral) > lim {
+		p.integral = math.Copysign(lim, p.integral)
+	}
+	out := p.kp*err + p.ki*p.integral + p.kd*deriv
+	return math.Max(-p.limit, math.Min(p.limit, out))
+}
+
+func (p *pid) reset() {
+	p.integral = 0
+	p.started = false
+}
+
+// wallFollow keeps set distance to a wall measured by side sensor, driving
+// forward with PID loop on distance error. Engines can only go straight or
+// turn with one wheel stopped, so PID output is turned into fraction of ticks
+// spent turning. Turns drive the outer wheel with forward power, stick
+// directions would turn at full power. When front is blocked, e.g. at inner
+// corner, bot turns in place away from the wall.
+type wallFollow struct {
+	front, side *echo
+	dir         int32   // 1 with wall on the right, -1 on the left.
+	target      float64 // cm
+	pid         pid
+
+	last     time.Time // Side reading used in the last PID update.
+	out, acc float64   // PID output and its accumulated error in ticks.
+	turning  time.Time // Start of turn away from the wall, zero when not turning.
+}
+
+func newWallFollow(front, side *echo, right bool, target float64) *wallFollow {
+	w := &wallFollow{
+		front:  front,
+		side:   side,
+		dir:    1,
+		target: target,
+		pid:    pid{kp: wallKp, ki: wallKi, kd: wallKd, limit: wallMaxTurn},
+	}
+	if !right {
+		w.dir = -1
+	}
+	return w
+}
+
+func (w *wallFollow) step(now time.Time) (*pb.Direction, error) {
+	front, frontAt := w.front.latest()
+	side, sideAt := w.side.latest()
+	if now.Sub(frontAt) > wallStale {
+		return nil, fmt.Errorf("no recent front distance")
+	}
+	if now.Sub(sideAt) > wallStale {
+		return nil, fmt.Errorf("no recent side distance")
+	}
+	w.front.enabled = true
+	w.side.enabled = true
+
+	if !w.turning.IsZero() {
+		// Reading taken before the turn started says nothing new.
+		if frontAt.After(w.turning) && front >= wallClear {
+			w.turning = time.Time{}
+			w.pid.reset()
+		} else {
+			return &pb.Direction{Dx: -w.dir * wallTurnDx}, nil
+		}
+	}
+	if front < wallBlocked {
+		w.turning = now
+		return &pb.Direction{Dx: -w.dir * wallTurnDx}, nil
+	}
+
+	if sideAt.After(w.last) {
+		dt := sideAt.Sub(w.last).Seconds()
+		if dt > wallMaxDtSec {
+			w.pid.reset()
+			dt = 0
+		}
+		w.last = sideAt
+		w.out = w.pid.update(float64(side)-w.target, dt)
+	}
+	// Turn on as many ticks as PID output asks for, on average.
+	w.acc += w.out
+	switch {
+	case w.acc >= 0.5:
+		w.acc--
+		return arc(w.dir), nil
+	case w.acc <= -0.5:
+		w.acc++
+		return arc(-w.dir), nil
+	}
+	return &pb.Direction{Dy: wallPower}, nil
+}
+
+// arc turns forward with inner wheel stopped, right when dir is 1 and left
+// when it's -1.
+func arc(dir int32) *pb.Direction {
+	if dir > 0 {
+		return &pb.Direction{Tank: true, Left: wallPower}
+	}
+	return &pb.Direction{Tank: true, Right: wallPower}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPID(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		p    pid
+		errs []float64 // One per second.
+		want float64   // Output of the last update.
+	}{
+		{"proportional", pid{kp: 0.5, limit: 10}, []float64{4}, 2},
+		{"negative", pid{kp: 0.5, limit: 10}, []float64{-4}, -2},
+		{"output limit", pid{kp: 0.5, limit: 1}, []float64{4}, 1},
+		{"integral", pid{ki: 0.1, limit: 10}, []float64{1, 1, 1}, 0.3},
+		// Integral stops at the output limit, so it unwinds right away.
+		{"integral clamp", pid{ki: 0.1, limit: 1}, []float64{5, 5, 5, 5, -1}, 0.9},
+		{"no derivative at start", pid{kd: 1, limit: 10}, []float64{5}, 0},
+		{"derivative", pid{kd: 1, limit: 10}, []float64{5, 2}, -3},
+	} {
+		var out float64
+		for _, e := range tc.errs {
+			out = tc.p.update(e, 1)
+		}
+		if math.Abs(out-tc.want) > 1e-9 {
+			t.Errorf("%s: output %v, want %v", tc.name, out, tc.want)
+		}
+	}
+}
+
+func TestPIDReset(t *testing.T) {
+	p := pid{ki: 0.1, kd: 1, limit: 10}
+	p.update(5, 1)
+	p.update(5, 1)
+	p.reset()
+	// Neither old integral nor jump from the old error is left.
+	if out := p.update(1, 1); math.Abs(out-0.1) > 1e-9 {
+		t.Errorf("output after reset %v, want 0.1", out)
+	}
+}
+
+func TestWallFollowCorridor(t *testing.T) {
+	for _, target := range []float64{20, 30, 40} {
+		// Side echo starts 30cm from the wall.
+		b := newSimBot(t, "corridor", 90)
+		w := newWallFollow(b.srv.front, b.srv.side, true, target)
+		b.run(w, time.Second*8)
+		if e := math.Abs(float64(b.srv.side.dist) - target); e > 3 {
+			t.Errorf("target %.0fcm: side %dcm after 8s", target, b.srv.side.dist)
+		}
+
+		// Around corners distance is off for a while.
+		var near, n int
+		for i := 0; i < 240; i++ {
+			b.run(w, time.Second/2)
+			if math.Abs(float64(b.srv.side.dist)-target) <= 5 {
+				near++
+			}
+			n++
+		}
+		if near < n*3/4 {
+			t.Errorf("target %.0fcm: side within 5cm only %d of %d times", target, near, n)
+		}
+		if b.hits > 0 {
+			t.Errorf("target %.0fcm: %d collisions with walls", target, b.hits)
+		}
+		if b.maxPower > wallPower {
+			t.Errorf("target %.0fcm: engine power up to %d, want at most %d", target, b.maxPower, wallPower)
+		}
+	}
+}
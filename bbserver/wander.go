@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+// Wander mode distances in cm, measured from the sensor.
+const (
+	wanderBlocked = 25 // Obstacle closer than that blocks the way.
+	wanderSlow    = 80 // Bot slows down gradually below that.
+	wanderClear   = 60 // Turning ends when front is at least that far.
+)
+
+// Wander mode powers and timing.
+const (
+	wanderFastPower  = 100
+	wanderSlowPower  = 30
+	wanderTurnPower  = 30
+	wanderReverseDur = time.Millisecond * 600
+	wanderStale      = defaultSlowDur * 2 // Readings older than that stop the mode.
+)
+
+type wanderState int
+
+const (
+	wanderForward wanderState = iota
+	wanderReverse
+	wanderTurn
+)
+
+// wander drives forward, slowing down as front obstacle gets closer. When
+// blocked it backs off if there is room behind and turns in place until the
+// way in front is clear.
+type wander struct {
+	front, rear *echo
+	state       wanderState
+	since       time.Time // Start of current state.
+	turn        int32     // 1 turns right, -1 left.
+}
+
+func newWander(front, rear *echo) *wander {
+	return &wander{front: front, rear: rear}
+}
+
+func (w *wander) set(st wanderState, now time.Time) {
+	w.state = st
+	w.since = now
+	if st == wanderTurn {
+		w.turn = 1
+		if rand.Intn(2) == 0 {
+			w.turn = -1
+		}
+	}
+}
+
+func (w *wander) step(now time.Time) (*pb.Direction, error) {
+	front, frontAt := w.front.latest()
+	rear, _ := w.rear.latest()
+	if now.Sub(frontAt) > wanderStale {
+		return nil, fmt.Errorf("no recent front distance")
+	}
+	// Keep measuring fast also while turning.
+	w.front.enabled = true
+	switch w.state {
+	case wanderForward:
+		if front >= wanderBlocked {
+			return &pb.Direction{Dy: wanderPower(front)}, nil
+		}
+		if rear > wanderBlocked {
+			w.set(wanderReverse, now)
+		} else {
+			w.set(wanderTurn, now)
+		}
+	case wanderReverse:
+		if now.Sub(w.since) >= wanderReverseDur || rear <= wanderBlocked {
+			w.set(wanderTurn, now)
+		}
+	case wanderTurn:
+		// Reading taken before the turn started says nothing new.
+		if frontAt.After(w.since) && front >= wanderClear {
+			w.set(wanderForward, now)
+			return &pb.Direction{Dy: wanderPower(front)}, nil
+		}
+	}
+	switch w.state {
+	case wanderReverse:
+		return &pb.Direction{Dy: -wanderSlowPower}, nil
+	case wanderTurn:
+		return &pb.Direction{Dx: w.turn * wanderTurnPower}, nil
+	}
+	return &pb.Direction{}, nil
+}
+
+// wanderPower scales forward power down with distance to front obstacle.
+func wanderPower(dist int64) int32 {
+	if dist >= wanderSlow {
+		return wanderFastPower
+	}
+	return int32(wanderSlowPower + (wanderFastPower-wanderSlowPower)*(dist-wanderBlocked)/(wanderSlow-wanderBlocked))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+func TestWanderNoCollisions(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		b := newSimBot(t, "room", 0)
+		b.run(newWander(b.srv.front, b.srv.rear), time.Minute*5)
+		if b.hits > 0 {
+			t.Errorf("run %d: %d collisions with walls", i, b.hits)
+		}
+		// Bot in a 3x3m room has to turn away from walls to get that far.
+		if b.travel < 3000 {
+			t.Errorf("run %d: drove only %.0fcm", i, b.travel)
+		}
+	}
+}
+
+// blindForward drives forward whatever is in front.
+type blindForward struct{}
+
+func (blindForward) step(time.Time) (*pb.Direction, error) {
+	return &pb.Direction{Dy: 100}, nil
+}
+
+func TestSimBotCollides(t *testing.T) {
+	b := newSimBot(t, "room", 0)
+	b.run(blindForward{}, time.Second*10)
+	if b.hits != 1 {
+		t.Errorf("blind bot hit walls %d times, want once", b.hits)
+	}
+}
+
+func TestWanderPower(t *testing.T) {
+	for _, tc := range []struct {
+		dist int64
+		want int32
+	}{
+		{wanderBlocked, wanderSlowPower},
+		{(wanderBlocked + wanderSlow) / 2, 64},
+		{wanderSlow - 1, 98},
+		{wanderSlow, wanderFastPower},
+		{400, wanderFastPower},
+	} {
+		if got := wanderPower(tc.dist); got != tc.want {
+			t.Errorf("wanderPower(%d) = %d, want %d", tc.dist, got, tc.want)
+		}
+	}
+}
+
+func TestWanderStaleFront(t *testing.T) {
+	b := newSimBot(t, "room", 0)
+	w := newWander(b.srv.front, b.srv.rear)
+	if _, err := w.step(b.now.Add(wanderStale + time.Millisecond)); err == nil {
+		t.Error("wander kept driving without recent front distance")
+	}
+}
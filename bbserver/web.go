@@ -0,0 +1,97 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// Web control panel, for driving from any browser without the mobile app.
+//
+//go:embed web/index.html
+var indexHTML []byte
+
+// wsStream is driveStream over web socket, with directions and telemetry sent
+// as JSON using the same field names as in steering.proto.
+type wsStream struct {
+	ws *websocket.Conn
+}
+
+func (w wsStream) Send(t *pb.Telemetry) error {
+	return websocket.JSON.Send(w.ws, t)
+}
+
+func (w wsStream) Recv() (*pb.Direction, error) {
+	d := new(pb.Direction)
+	if err := websocket.JSON.Receive(w.ws, d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// checkOrigin refuses requests made by pages of other sites, which browsers
+// would otherwise send on behalf of anyone visiting them in the bot's network.
+// Clients outside of browsers don't send Origin and pass.
+func checkOrigin(r *http.Request) error {
+	o := r.Header.Get("Origin")
+	if o == "" {
+		return nil
+	}
+	u, err := url.Parse(o)
+	if err != nil || u.Host != r.Host {
+		return fmt.Errorf("origin %q doesn't match host %q", o, r.Host)
+	}
+	return nil
+}
+
+// handlePost registers handler of state changing POST request.
+func handlePost(mux *http.ServeMux, path string, h func(r *http.Request)) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := checkOrigin(r); err != nil {
+			log.Warnf("Refused %s from %s: %v", path, r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		h(r)
+	})
+}
+
+// handleWeb registers control panel handlers on mux, which is the default one
+// next to pprof. Requests of other sites' pages are refused, see checkOrigin.
+func (s *server) handleWeb(mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	})
+	handlePost(mux, "/estop", func(r *http.Request) {
+		s.emergencyStop(fmt.Sprintf("web panel (from %s)", r.RemoteAddr))
+	})
+	handlePost(mux, "/estop/clear", func(r *http.Request) {
+		s.clearEmergencyStop("web panel " + r.RemoteAddr)
+	})
+	mux.Handle("/drive", websocket.Server{
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			return checkOrigin(r)
+		},
+		Handler: func(ws *websocket.Conn) {
+			log.Infof("Web client connected from %s", ws.Request().RemoteAddr)
+			if err := s.serveDrive(wsStream{ws}); err != nil {
+				log.Warnf("Web client: %v", err)
+			}
+		},
+	})
+}
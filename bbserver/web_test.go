@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://bot:9191", true},
+		{"https://bot:9191", true},
+		{"http://bot", false},
+		{"http://evil.example", false},
+		{"http://bot:9191.evil.example", false},
+		{"null", false},
+		{"%zz", false},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "http://bot:9191/estop", nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		if err := checkOrigin(r); (err == nil) != tc.ok {
+			t.Errorf("checkOrigin with Origin %q = %v, want ok %v", tc.origin, err, tc.ok)
+		}
+	}
+}
+
+// testWeb serves control panel of simulated bot.
+func testWeb(t *testing.T) (*server, *httptest.Server) {
+	t.Helper()
+	s := newSimBot(t, "room", 0).srv
+	mux := http.NewServeMux()
+	s.handleWeb(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func TestWebEmergencyStop(t *testing.T) {
+	s, ts := testWeb(t)
+	same := ts.URL
+	for _, tc := range []struct {
+		name    string
+		method  string
+		path    string
+		origin  string
+		status  int
+		stopped bool
+	}{
+		{"get", http.MethodGet, "/estop", "", http.StatusMethodNotAllowed, false},
+		{"other site", http.MethodPost, "/estop", "http://evil.example", http.StatusForbidden, false},
+		{"panel", http.MethodPost, "/estop", same, http.StatusOK, true},
+		{"other site clears", http.MethodPost, "/estop/clear", "http://evil.example", http.StatusForbidden, true},
+		{"panel clears", http.MethodPost, "/estop/clear", same, http.StatusOK, false},
+		{"curl", http.MethodPost, "/estop", "", http.StatusOK, true},
+	} {
+		req, err := http.NewRequest(tc.method, ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || s.estop.active() != tc.stopped {
+			t.Errorf("%s: status %d, stopped %v, want %d and %v", tc.name, resp.StatusCode, s.estop.active(), tc.status, tc.stopped)
+		}
+	}
+}
+
+func TestWebDriveOrigin(t *testing.T) {
+	s, ts := testWeb(t)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/drive"
+	if ws, err := websocket.Dial(url, "", "http://evil.example"); err == nil {
+		ws.Close()
+		t.Error("web socket of other site accepted")
+	}
+	ws, err := websocket.Dial(url, "", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if err := websocket.JSON.Send(ws, &pb.Direction{Dy: 80}); err != nil {
+		t.Fatal(err)
+	}
+	for end := time.Now().Add(time.Second); s.driver.left.signedPwr() != 80; {
+		if time.Now().After(end) {
+			t.Fatalf("engine at %d, want 80 from panel", s.driver.left.signedPwr())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
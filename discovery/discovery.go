@@ -0,0 +1,38 @@
+// Package discovery finds bots announcing their gRPC port with UDP broadcasts
+// on local network.
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultPort is UDP port bots broadcast on by default.
+const DefaultPort = "8032"
+
+// Find listens for UDP broadcasts on port and returns gRPC address of the first
+// bot it hears from. It blocks until a broadcast arrives, or until timeout
+// passes if it's not 0.
+func Find(port string, timeout time.Duration) (string, error) {
+	c, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		return "", fmt.Errorf("can't listen for broadcasts: %v", err)
+	}
+	defer c.Close()
+	if timeout > 0 {
+		if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return "", err
+		}
+	}
+	buf := make([]byte, 512)
+	n, peer, err := c.ReadFrom(buf)
+	if err != nil {
+		return "", fmt.Errorf("no bot found: %v", err)
+	}
+	host, _, err := net.SplitHostPort(peer.String())
+	if err != nil {
+		return "", fmt.Errorf("can't parse peer IP address %v", err)
+	}
+	return net.JoinHostPort(host, string(buf[:n])), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+// sensitivity of proximity feedback, how far from obstacles beeping starts.
+type sensitivity int
+
+const (
+	sensitivityOff    sensitivity = iota
+	sensitivityLow                // Beeps closer than near threshold.
+	sensitivityMedium             // Closer than far threshold.
+	sensitivityHigh               // Anywhere on radar, up to twice the far threshold.
+)
+
+// Sensitivities in order of their bars in settings.
+var sensitivities = []sensitivity{sensitivityOff, sensitivityLow, sensitivityMedium, sensitivityHigh}
+
+var sensitivityNames = []string{
+	sensitivityOff:    "off",
+	sensitivityLow:    "low",
+	sensitivityMedium: "medium",
+	sensitivityHigh:   "high",
+}
+
+func (s sensitivity) MarshalText() ([]byte, error) {
+	return marshalName(sensitivityNames, int(s))
+}
+
+func (s *sensitivity) UnmarshalText(b []byte) error {
+	i, err := unmarshalName(sensitivityNames, b)
+	*s = sensitivity(i)
+	return err
+}
+
+// start returns distance in cm below which beeping starts.
+func (s sensitivity) start(p proximity) int {
+	switch s {
+	case sensitivityLow:
+		return p.Near
+	case sensitivityMedium:
+		return p.Far
+	case sensitivityHigh:
+		return 2 * p.Far
+	}
+	return 0
+}
+
+// Parking sensor like beeps, slow when beeping starts and fast enough to
+// merge into a tone at half of near threshold, and haptic pulse when an
+// obstacle comes closer than near threshold. Obstacles have to move away a
+// bit past it before the next pulse, so noisy readings don't keep buzzing.
+const (
+	beepDur        = time.Millisecond * 60
+	beepSlow       = time.Second
+	beepFast       = time.Millisecond * 80
+	pulseDur       = time.Millisecond * 150
+	pulseClearDist = 10
+)
+
+// feedback beeps and vibrates about obstacles while the bot moves, for
+// drivers looking at the bot rather than the phone.
+type feedback struct {
+	mu       sync.Mutex
+	moving   bool // Set by telemetry.
+	noSound  bool // Beeping failed, it's not tried again.
+	noHaptic bool // Vibrating failed, e.g. phone has no vibrator.
+
+	lastBeep time.Time
+	near     bool // The closest obstacle is within near threshold.
+}
+
+// SetMoving tells feedback whether engines of the bot are running.
+func (a *App) SetMoving(t *pb.Telemetry) {
+	a.feedback.mu.Lock()
+	a.feedback.moving = t.LeftPower != 0 || t.RightPower != 0
+	a.feedback.mu.Unlock()
+}
+
+// Feedback beeps at rate following the closest distance and pulses when it
+// crosses near threshold, as set in settings. It's called on every frame.
+func (a *App) Feedback(now time.Time) {
+	f := &a.feedback
+	s := a.settings.Feedback
+	d, ok := a.closest(now)
+	if s == sensitivityOff || !ok {
+		f.near = false
+		return
+	}
+	p := a.settings.Proximity
+	f.mu.Lock()
+	moving, noSound, noHaptic := f.moving, f.noSound, f.noHaptic
+	f.mu.Unlock()
+
+	switch {
+	case d < p.Near && !f.near:
+		f.near = true
+		if moving && !noHaptic {
+			go f.play("haptic pulses", vibrate, pulseDur, &f.noHaptic)
+		}
+	case d >= p.Near+pulseClearDist:
+		f.near = false
+	}
+
+	start := s.start(p)
+	if !moving || noSound || d >= start || now.Sub(f.lastBeep) < beepInterval(d, p.Near/2, start) {
+		return
+	}
+	f.lastBeep = now
+	go f.play("beeps", beep, beepDur, &f.noSound)
+}
+
+// play runs platform beep or vibration, giving up on it after it fails.
+func (f *feedback) play(name string, fn func(time.Duration) error, d time.Duration, failed *bool) {
+	if err := fn(d); err != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if !*failed {
+			log.Printf("No proximity %s: %v", name, err)
+		}
+		*failed = true
+	}
+}
+
+// beepInterval returns time between beeps at distance d, the fastest below
+// solid and the slowest at start distance.
+func beepInterval(d, solid, start int) time.Duration {
+	if d <= solid || start <= solid {
+		return beepFast
+	}
+	frac := float64(d-solid) / float64(start-solid)
+	return beepFast + time.Duration(frac*float64(beepSlow-beepFast))
+}
+
+// closest returns distance to the closest obstacle seen by any sensor, or
+// false when none has recent data.
+func (a *App) closest(now time.Time) (int, bool) {
+	d, ok := 0, false
+	for _, b := range a.blips(now) {
+		if b.level != levelNone && (!ok || int(b.dist) < d) {
+			d, ok = int(b.dist), true
+		}
+	}
+	return d, ok
+}
@@ -0,0 +1,87 @@
+//go:build android
+
+package main
+
+/*
+#include <jni.h>
+#include <stdlib.h>
+
+// Tone generator is created on the first beep and kept for the whole run.
+static jobject toneGen;
+
+// Functions below return on Java exceptions, leaving them pending for
+// RunOnJVM to report.
+
+static void startTone(uintptr_t jni_env, int ms) {
+	JNIEnv* env = (JNIEnv*)jni_env;
+	if (toneGen == NULL) {
+		jclass cls = (*env)->FindClass(env, "android/media/ToneGenerator");
+		if ((*env)->ExceptionCheck(env)) {
+			return;
+		}
+		jmethodID init = (*env)->GetMethodID(env, cls, "<init>", "(II)V");
+		// AudioManager.STREAM_NOTIFICATION at full volume.
+		jobject t = (*env)->NewObject(env, cls, init, 5, 100);
+		if ((*env)->ExceptionCheck(env)) {
+			return;
+		}
+		toneGen = (*env)->NewGlobalRef(env, t);
+	}
+	jclass cls = (*env)->GetObjectClass(env, toneGen);
+	jmethodID start = (*env)->GetMethodID(env, cls, "startTone", "(II)Z");
+	// ToneGenerator.TONE_PROP_BEEP.
+	(*env)->CallBooleanMethod(env, toneGen, start, 24, ms);
+}
+
+// vibrateFor returns -1 when there's no vibrator.
+static int vibrateFor(uintptr_t jni_env, uintptr_t ctx, int ms) {
+	JNIEnv* env = (JNIEnv*)jni_env;
+	jobject context = (jobject)ctx;
+	jclass ctxCls = (*env)->GetObjectClass(env, context);
+	jmethodID getService = (*env)->GetMethodID(env, ctxCls, "getSystemService", "(Ljava/lang/String;)Ljava/lang/Object;");
+	jobject vib = (*env)->CallObjectMethod(env, context, getService, (*env)->NewStringUTF(env, "vibrator"));
+	if ((*env)->ExceptionCheck(env)) {
+		return 0;
+	}
+	if (vib == NULL) {
+		return -1;
+	}
+	jclass vibCls = (*env)->GetObjectClass(env, vib);
+	jmethodID vibrate = (*env)->GetMethodID(env, vibCls, "vibrate", "(J)V");
+	(*env)->CallVoidMethod(env, vib, vibrate, (jlong)ms);
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/mobile/app"
+)
+
+// Calls to Java are serialized, so the tone generator is created once.
+var jvmMu sync.Mutex
+
+func beep(d time.Duration) error {
+	jvmMu.Lock()
+	defer jvmMu.Unlock()
+	return app.RunOnJVM(func(vm, env, ctx uintptr) error {
+		C.startTone(C.uintptr_t(env), C.int(d/time.Millisecond))
+		return nil
+	})
+}
+
+// vibrate needs VIBRATE permission from AndroidManifest.xml.
+func vibrate(d time.Duration) error {
+	jvmMu.Lock()
+	defer jvmMu.Unlock()
+	return app.RunOnJVM(func(vm, env, ctx uintptr) error {
+		if C.vibrateFor(C.uintptr_t(env), C.uintptr_t(ctx), C.int(d/time.Millisecond)) != 0 {
+			return errors.New("no vibrator")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,32 @@
+//go:build ios
+
+package main
+
+/*
+#cgo LDFLAGS: -framework AudioToolbox
+#include <AudioToolbox/AudioToolbox.h>
+
+// Short "Tink" of the keyboard.
+static void playTink() {
+	AudioServicesPlaySystemSound(1103);
+}
+
+static void playVibrate() {
+	AudioServicesPlaySystemSound(kSystemSoundID_Vibrate);
+}
+*/
+import "C"
+
+import "time"
+
+// System sounds have fixed length on iOS, so durations are ignored.
+
+func beep(d time.Duration) error {
+	C.playTink()
+	return nil
+}
+
+func vibrate(d time.Duration) error {
+	C.playVibrate()
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !android && !ios
+
+package main
+
+import "time"
+
+// Desktop builds have neither tones nor vibration, feedback stays silent.
+
+func beep(d time.Duration) error {
+	return nil
+}
+
+func vibrate(d time.Duration) error {
+	return nil
+}
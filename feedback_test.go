@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSensitivityStart(t *testing.T) {
+	p := proximity{Near: 50, Far: 100}
+	for s, want := range map[sensitivity]int{
+		sensitivityOff:    0,
+		sensitivityLow:    50,
+		sensitivityMedium: 100,
+		sensitivityHigh:   200,
+	} {
+		if got := s.start(p); got != want {
+			t.Errorf("%s: start = %d, want %d", sensitivityNames[s], got, want)
+		}
+	}
+}
+
+func TestBeepInterval(t *testing.T) {
+	for _, tc := range []struct {
+		d, solid, start int
+		want            time.Duration
+	}{
+		{0, 25, 100, beepFast},
+		{25, 25, 100, beepFast},
+		{100, 25, 100, beepSlow},
+		{50, 25, 50, beepSlow},
+		{60, 20, 100, beepFast + (beepSlow-beepFast)/2},
+		{30, 25, 25, beepFast}, // Starts no farther than solid tone.
+		{30, 25, 0, beepFast},
+	} {
+		if got := beepInterval(tc.d, tc.solid, tc.start); got != tc.want {
+			t.Errorf("beepInterval(%d, %d, %d) = %v, want %v", tc.d, tc.solid, tc.start, got, tc.want)
+		}
+	}
+	// Beeps speed up as obstacle comes closer.
+	prev := beepInterval(200, 25, 200)
+	for d := 199; d >= 0; d-- {
+		got := beepInterval(d, 25, 200)
+		if got > prev {
+			t.Errorf("beepInterval(%d, 25, 200) = %v, slower than %v just farther", d, got, prev)
+		}
+		prev = got
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/protocol"
+
+	"golang.org/x/mobile/event/size"
+	"golang.org/x/mobile/event/touch"
+	"golang.org/x/mobile/exp/f32"
+	"golang.org/x/mobile/exp/sprite"
+	"golang.org/x/mobile/exp/sprite/clock"
+	"golang.org/x/net/context"
+)
+
+// gallery browses photos stored on the bot, one at a time.
+type gallery struct {
+	mu     sync.Mutex
+	open   bool
+	photos []*pb.PhotoInfo // The newest first.
+	cur    int
+	data   []byte      // JPEG of current photo, nil while loading.
+	img    image.Image // Decoded data.
+	fresh  bool        // Image isn't uploaded to texture yet.
+	tex    sprite.Texture
+
+	touch   touch.Sequence // Touch started on a button.
+	touched bool
+}
+
+// Size of icon buttons and their distance from screen edges in points.
+const (
+	iconSize   = 36
+	iconMargin = 10
+)
+
+// iconPos returns top left corner of icon button. Shutter and gallery buttons
+// are in bottom right corner, settings button in bottom left, gallery controls
+// around the photo and stick layout buttons of settings in top left corner.
+func (a *App) iconPos(icon int) (x, y float32) {
+	w, h := a.screen.w, a.screen.h
+	switch icon {
+	case iconShutter:
+		return w - iconSize - iconMargin, h - iconSize - iconMargin
+	case iconGallery:
+		return w - 2*(iconSize+iconMargin), h - iconSize - iconMargin
+	case iconPrev:
+		return iconMargin, h/2 - iconSize/2
+	case iconNext:
+		return w - iconSize - iconMargin, h/2 - iconSize/2
+	case iconSave:
+		return w - 2*(iconSize+iconMargin), iconMargin
+	case iconClose:
+		return w - iconSize - iconMargin, iconMargin
+	case iconSettings:
+		return iconMargin, h - iconSize - iconMargin
+	case iconLayoutFixed, iconLayoutFloating, iconLayoutTank, iconLayoutTilt:
+		return iconMargin + float32(icon-iconLayoutFixed)*(iconSize+iconMargin), iconMargin
+	case iconCalibrate:
+		x, y := a.stickHome(0)
+		return x + ctrlSize/2 + iconMargin, y - iconSize/2
+	}
+	return 0, 0
+}
+
+// iconAt returns icon button under point, or -1 if there's none. Only buttons
+// shown in current view count, bots without photos have none.
+func (a *App) iconAt(xp, yp float32, open bool) int {
+	var icons []int
+	if a.caps.Has(protocol.Photos) {
+		icons = []int{iconShutter, iconGallery}
+	}
+	if open {
+		icons = []int{iconPrev, iconNext, iconSave, iconClose}
+	}
+	for _, i := range icons {
+		if a.onIcon(i, xp, yp) {
+			return i
+		}
+	}
+	return -1
+}
+
+// onIcon tells whether point is on icon button.
+func (a *App) onIcon(icon int, xp, yp float32) bool {
+	x, y := a.iconPos(icon)
+	return xp >= x && xp < x+iconSize && yp >= y && yp < y+iconSize
+}
+
+// TouchPhoto handles touches of photo buttons and the whole screen while
+// gallery is open. It returns false for touches it leaves to others.
+func (a *App) TouchPhoto(sz size.Event, e touch.Event) bool {
+	g := &a.gallery
+	g.mu.Lock()
+	open := g.open
+	g.mu.Unlock()
+	if g.touched && e.Sequence == g.touch {
+		if e.Type == touch.TypeEnd {
+			g.touched = false
+		}
+		return true
+	}
+	if e.Type != touch.TypeBegin || sz.PixelsPerPt == 0 {
+		return open
+	}
+	icon := a.iconAt(e.X/sz.PixelsPerPt, e.Y/sz.PixelsPerPt, open)
+	if icon < 0 && !open {
+		return false
+	}
+	g.touch = e.Sequence
+	g.touched = true
+	switch icon {
+	case iconShutter:
+		go a.TakePhoto()
+	case iconGallery:
+		a.ResetStick(sz)
+		g.mu.Lock()
+		g.open = true
+		g.mu.Unlock()
+		go a.loadGallery()
+	case iconPrev:
+		a.showPhoto(-1)
+	case iconNext:
+		a.showPhoto(1)
+	case iconSave:
+		go a.SavePhoto()
+	case iconClose:
+		g.mu.Lock()
+		g.open = false
+		g.mu.Unlock()
+	}
+	return true
+}
+
+// TakePhoto takes full resolution photo, stored on the bot.
+func (a *App) TakePhoto() {
+	if !a.connected {
+		return
+	}
+	info, err := a.cli.TakePhoto(context.Background(), &pb.TakePhotoRequest{})
+	if err != nil {
+		log.Printf("Can't take photo: %v", err)
+		return
+	}
+	log.Printf("Photo %s taken", info.Name)
+}
+
+// loadGallery lists photos and shows the newest one.
+func (a *App) loadGallery() {
+	if !a.connected {
+		return
+	}
+	l, err := a.cli.ListPhotos(context.Background(), &pb.ListPhotosRequest{})
+	if err != nil {
+		log.Printf("Can't list photos: %v", err)
+		return
+	}
+	if len(l.Photos) == 0 {
+		log.Print("No photos yet")
+	}
+	g := &a.gallery
+	g.mu.Lock()
+	g.photos = l.Photos
+	g.cur = 0
+	g.mu.Unlock()
+	a.showPhoto(0)
+}
+
+// showPhoto moves by delta photos and starts downloading the photo.
+func (a *App) showPhoto(delta int) {
+	g := &a.gallery
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.photos) == 0 {
+		return
+	}
+	g.cur = (g.cur + delta + len(g.photos)) % len(g.photos)
+	g.data, g.img = nil, nil
+	go a.downloadPhoto(g.photos[g.cur].Name)
+}
+
+func (a *App) downloadPhoto(name string) {
+	stream, err := a.cli.GetPhoto(context.Background(), &pb.GetPhotoRequest{Name: name})
+	if err != nil {
+		log.Printf("Can't download photo: %v", err)
+		return
+	}
+	var b bytes.Buffer
+	for {
+		c, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Can't download photo: %v", err)
+			return
+		}
+		b.Write(c.Data)
+	}
+	m, err := jpeg.Decode(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		log.Printf("Can't decode photo: %v", err)
+		return
+	}
+	g := &a.gallery
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// User might have moved on while downloading.
+	if len(g.photos) == 0 || g.photos[g.cur].Name != name {
+		return
+	}
+	g.data, g.img, g.fresh = b.Bytes(), m, true
+}
+
+// SavePhoto saves current photo on the phone, in photos directory of the app.
+func (a *App) SavePhoto() {
+	g := &a.gallery
+	g.mu.Lock()
+	data := g.data
+	var name string
+	if len(g.photos) > 0 {
+		name = g.photos[g.cur].Name
+	}
+	g.mu.Unlock()
+	if data == nil {
+		log.Print("No photo to save")
+		return
+	}
+	dir := filepath.Join(appDir(), "photos")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Can't save photo: %v", err)
+		return
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Printf("Can't save photo: %v", err)
+		return
+	}
+	log.Printf("Saved %s", path)
+}
+
+// appDir returns directory where the app keeps its files. Android apps have
+// no home, but get their cache directory, next to their files, as TMPDIR.
+func appDir() string {
+	if runtime.GOOS == "android" {
+		return filepath.Dir(os.TempDir())
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return os.TempDir()
+	}
+	if runtime.GOOS == "ios" {
+		return filepath.Join(home, "Documents")
+	}
+	return filepath.Join(home, "berrybot")
+}
+
+// galleryNodes adds photo buttons and gallery view on top of the scene.
+func (a *App) galleryNodes(newNode func(arrangerFunc), icons, cols []sprite.SubTex) {
+	g := &a.gallery
+	isOpen := func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.open
+	}
+	icon := func(i int, show func() bool) {
+		newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+			if !show() {
+				eng.SetSubTex(n, sprite.SubTex{})
+				return
+			}
+			x, y := a.iconPos(i)
+			eng.SetSubTex(n, icons[i])
+			eng.SetTransform(n, f32.Affine{
+				{iconSize, 0, x},
+				{0, iconSize, y},
+			})
+		})
+	}
+	closed := func() bool { return !isOpen() && a.caps.Has(protocol.Photos) }
+	icon(iconShutter, closed)
+	icon(iconGallery, closed)
+
+	// Background hiding controls.
+	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+		if !isOpen() {
+			eng.SetSubTex(n, sprite.SubTex{})
+			return
+		}
+		eng.SetSubTex(n, cols[colBlack])
+		eng.SetTransform(n, f32.Affine{
+			{a.screen.w, 0, 0},
+			{0, a.screen.h, 0},
+		})
+	})
+
+	// Photo, as large as fits the screen.
+	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+		g.mu.Lock()
+		open, m, fresh := g.open, g.img, g.fresh
+		g.fresh = false
+		g.mu.Unlock()
+		if !open || m == nil {
+			eng.SetSubTex(n, sprite.SubTex{})
+			return
+		}
+		b := m.Bounds()
+		if fresh {
+			if g.tex != nil {
+				g.tex.Release()
+			}
+			tex, err := eng.LoadTexture(m)
+			if err != nil {
+				log.Printf("Can't load photo: %v", err)
+				g.tex = nil
+				return
+			}
+			g.tex = tex
+		}
+		scale := float32(math.Min(float64(a.screen.w)/float64(b.Dx()), float64(a.screen.h)/float64(b.Dy())))
+		w, h := float32(b.Dx())*scale, float32(b.Dy())*scale
+		eng.SetSubTex(n, sprite.SubTex{T: g.tex, R: image.Rect(0, 0, b.Dx(), b.Dy())})
+		eng.SetTransform(n, f32.Affine{
+			{w, 0, (a.screen.w - w) / 2},
+			{0, h, (a.screen.h - h) / 2},
+		})
+	})
+
+	for _, i := range []int{iconPrev, iconNext, iconSave, iconClose} {
+		icon(i, isOpen)
+	}
+}
+
+// Icons of photo and settings buttons, white shapes drawn at runtime.
+const (
+	iconShutter = iota
+	iconGallery
+	iconPrev
+	iconNext
+	iconSave
+	iconClose
+	iconSettings
+	iconLayoutFixed
+	iconLayoutFloating
+	iconLayoutTank
+	iconLayoutTilt
+	iconCalibrate
+)
+
+func loadIcons(eng sprite.Engine) []sprite.SubTex {
+	abs := math.Abs
+	// Shapes in coordinates from -1 to 1, with y pointing down.
+	prev := func(x, y float64) bool { return x > -0.6 && x < 0.6 && abs(y) < 0.8*(x+0.6)/1.2 }
+	shapes := []func(x, y float64) bool{
+		iconShutter: func(x, y float64) bool { r := math.Hypot(x, y); return r < 0.6 || (r > 0.75 && r < 0.9) },
+		iconGallery: func(x, y float64) bool { return abs(x) > 0.1 && abs(y) > 0.1 && abs(x) < 0.9 && abs(y) < 0.9 },
+		iconPrev:    prev,
+		iconNext:    func(x, y float64) bool { return prev(-x, y) },
+		iconSave: func(x, y float64) bool {
+			return (abs(x) < 0.2 && y > -0.9 && y <= 0) || (y > 0 && abs(x) < 0.8-y)
+		},
+		iconClose: func(x, y float64) bool { return abs(x) < 0.8 && abs(y) < 0.8 && (abs(x-y) < 0.25 || abs(x+y) < 0.25) },
+		iconSettings: func(x, y float64) bool {
+			r := math.Hypot(x, y)
+			return r > 0.3 && (r < 0.65 || (r < 0.9 && math.Cos(8*math.Atan2(y, x)) > 0.3))
+		},
+		iconLayoutFixed: func(x, y float64) bool {
+			r := math.Hypot(x, y-0.4)
+			return r < 0.15 || (r > 0.3 && r < 0.45) || (abs(y+0.75) < 0.08 && abs(x) < 0.8)
+		},
+		iconLayoutFloating: func(x, y float64) bool {
+			r := math.Hypot(x, y)
+			return r < 0.15 || (r > 0.3 && r < 0.45) || (r > 0.65 && r < 0.95 && (abs(x) < 0.08 || abs(y) < 0.08))
+		},
+		iconLayoutTank: func(x, y float64) bool {
+			return (abs(abs(x)-0.45) < 0.15 && abs(y) < 0.8) || (abs(abs(x)-0.45) < 0.3 && abs(y-0.1) < 0.12)
+		},
+		iconLayoutTilt: func(x, y float64) bool {
+			// Phone outline, tilted.
+			u, v := 0.9*x-0.42*y, 0.42*x+0.9*y
+			return abs(u) < 0.45 && abs(v) < 0.85 && !(abs(u) < 0.3 && abs(v) < 0.7)
+		},
+		iconCalibrate: func(x, y float64) bool {
+			r := math.Hypot(x, y)
+			return (r > 0.5 && r < 0.65) || (abs(x) < 0.07 && abs(y) < 0.9) || (abs(y) < 0.07 && abs(x) < 0.9)
+		},
+	}
+	const n = 64
+	m := image.NewRGBA(image.Rect(0, 0, n*len(shapes), n))
+	for i, inside := range shapes {
+		for y := 1; y < n-1; y++ {
+			for x := 1; x < n-1; x++ {
+				if inside(2*(float64(x)+0.5)/n-1, 2*(float64(y)+0.5)/n-1) {
+					m.SetRGBA(i*n+x, y, color.RGBA{0xff, 0xff, 0xff, 0xff})
+				}
+			}
+		}
+	}
+	t, err := eng.LoadTexture(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+	texs := make([]sprite.SubTex, len(shapes))
+	for i := range shapes {
+		texs[i] = sprite.SubTex{T: t, R: image.Rect(i*n, 0, i*n+n, n)}
+	}
+	return texs
+}
@@ -0,0 +1,194 @@
+// Package gamepad reads gamepads through Linux joystick API (/dev/input/js*)
+// and turns their state into driving directions.
+package gamepad
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+// Joystick API event types, see linux/joystick.h.
+const (
+	typeButton = 0x01
+	typeAxis   = 0x02
+	typeInit   = 0x80
+)
+
+// Event is a single joystick API event as read from the device.
+type Event struct {
+	Time   uint32 // Milliseconds, with undefined base.
+	Value  int16
+	Type   uint8
+	Number uint8
+}
+
+// Mapping tells which axes and buttons control the bot.
+type Mapping struct {
+	X, Y    uint8 // Steering axes.
+	InvertY bool  // Most pads report stick up as negative.
+	Stop    uint8 // Button latching emergency stop until pressed again.
+	Slow    uint8 // Button toggling slow mode.
+	Profile uint8 // Button switching to the next driving profile.
+}
+
+// DefaultMapping uses left stick, B to stop, A to toggle slow mode and Y to
+// switch profile on Xbox-style pads.
+var DefaultMapping = Mapping{X: 0, Y: 1, InvertY: true, Stop: 1, Slow: 0, Profile: 3}
+
+// Action is a button press the bot has to act on, besides driving.
+type Action int
+
+const (
+	ActionStop        Action = iota + 1 // Stop latched, bot should stop hard.
+	ActionReleaseStop                   // Stop released.
+	ActionProfile                       // Switch to the next driving profile.
+)
+
+// NextProfile returns driving profile following p, wrapping around.
+func NextProfile(p pb.Profile) pb.Profile {
+	return pb.Profile((int(p) + 1) % len(pb.Profile_name))
+}
+
+// SlowFactor scales directions in slow mode.
+const SlowFactor = 0.5
+
+// Pad holds state of an opened gamepad. It's safe for concurrent use.
+type Pad struct {
+	Mapping Mapping
+
+	f       *os.File
+	mu      sync.Mutex
+	axes    map[uint8]int16
+	buttons map[uint8]bool
+	stopped bool
+	slow    bool
+	actions chan Action
+}
+
+// Open opens joystick device at path, e.g. /dev/input/js0.
+func Open(path string, m Mapping) (*Pad, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open gamepad: %v", err)
+	}
+	return &Pad{
+		Mapping: m,
+		f:       f,
+		axes:    make(map[uint8]int16),
+		buttons: make(map[uint8]bool),
+		actions: make(chan Action, 16),
+	}, nil
+}
+
+// Run reads events and updates state until device is closed or unplugged.
+func (p *Pad) Run() error {
+	for {
+		var e Event
+		if err := binary.Read(p.f, binary.LittleEndian, &e); err != nil {
+			return fmt.Errorf("can't read gamepad: %v", err)
+		}
+		p.Update(e)
+	}
+}
+
+// Update applies single event to pad state.
+func (p *Pad) Update(e Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch e.Type &^ typeInit {
+	case typeAxis:
+		p.axes[e.Number] = e.Value
+	case typeButton:
+		pressed := e.Value != 0
+		// Toggle on press only, initial state events report what's held
+		// already and shouldn't toggle anything.
+		if pressed && !p.buttons[e.Number] && e.Type&typeInit == 0 {
+			switch e.Number {
+			case p.Mapping.Stop:
+				p.stopped = !p.stopped
+				if p.stopped {
+					p.act(ActionStop)
+				} else {
+					p.act(ActionReleaseStop)
+				}
+			case p.Mapping.Slow:
+				p.slow = !p.slow
+			case p.Mapping.Profile:
+				p.act(ActionProfile)
+			}
+		}
+		p.buttons[e.Number] = pressed
+	}
+}
+
+// act queues action, dropping it when nobody reads them.
+func (p *Pad) act(a Action) {
+	select {
+	case p.actions <- a:
+	default:
+	}
+}
+
+// Actions returns channel of button presses to act on.
+func (p *Pad) Actions() <-chan Action {
+	return p.actions
+}
+
+// Normalize maps raw axis values to direction between -100 and 100, clamped
+// to a circle the same way as stick of the mobile app controller.
+func Normalize(x, y int16) (dx, dy int32) {
+	fx := float64(x) / math.MaxInt16
+	fy := float64(y) / math.MaxInt16
+	if d := math.Hypot(fx, fy); d > 1 {
+		fx /= d
+		fy /= d
+	}
+	return int32(fx * 100), int32(fy * 100)
+}
+
+// Direction returns current direction, zero when stopped.
+func (p *Pad) Direction() *pb.Direction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return &pb.Direction{}
+	}
+	y := p.axes[p.Mapping.Y]
+	if p.Mapping.InvertY {
+		if y == math.MinInt16 {
+			y = math.MaxInt16 // Negating would overflow.
+		} else {
+			y = -y
+		}
+	}
+	dx, dy := Normalize(p.axes[p.Mapping.X], y)
+	if p.slow {
+		dx = int32(float64(dx) * SlowFactor)
+		dy = int32(float64(dy) * SlowFactor)
+	}
+	return &pb.Direction{Dx: dx, Dy: dy}
+}
+
+// Stopped tells whether stop button latched the stop.
+func (p *Pad) Stopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+// Slow tells whether slow mode is on.
+func (p *Pad) Slow() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.slow
+}
+
+// Close closes the device, which also ends Run.
+func (p *Pad) Close() error {
+	return p.f.Close()
+}
@@ -0,0 +1,148 @@
+package gamepad
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+func testPad(t *testing.T) *Pad {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "js0")
+	if err := os.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Open(name, DefaultMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func press(p *Pad, button uint8) {
+	p.Update(Event{Type: typeButton, Number: button, Value: 1})
+	p.Update(Event{Type: typeButton, Number: button, Value: 0})
+}
+
+// actions returns actions queued so far.
+func actions(p *Pad) []Action {
+	var as []Action
+	for {
+		select {
+		case a := <-p.Actions():
+			as = append(as, a)
+		default:
+			return as
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		x, y   int16
+		dx, dy int32
+	}{
+		{0, 0, 0, 0},
+		{math.MaxInt16, 0, 100, 0},
+		{0, math.MinInt16, 0, -100},
+		{math.MaxInt16 / 2, 0, 49, 0},
+		// Corners are clamped to the circle.
+		{math.MaxInt16, math.MaxInt16, 70, 70},
+		{math.MinInt16, math.MinInt16, -70, -70},
+	} {
+		dx, dy := Normalize(tc.x, tc.y)
+		if dx != tc.dx || dy != tc.dy {
+			t.Errorf("Normalize(%d, %d) = %d, %d, want %d, %d", tc.x, tc.y, dx, dy, tc.dx, tc.dy)
+		}
+	}
+}
+
+func TestDirection(t *testing.T) {
+	p := testPad(t)
+	p.Update(Event{Type: typeAxis, Number: DefaultMapping.X, Value: math.MaxInt16})
+	p.Update(Event{Type: typeAxis, Number: DefaultMapping.Y, Value: 0})
+	if d := p.Direction(); d.Dx != 100 || d.Dy != 0 {
+		t.Errorf("stick right: direction %v, want dx 100", d)
+	}
+	// Stick up is negative, and the most negative value must not overflow.
+	p.Update(Event{Type: typeAxis, Number: DefaultMapping.X, Value: 0})
+	p.Update(Event{Type: typeAxis, Number: DefaultMapping.Y, Value: math.MinInt16})
+	if d := p.Direction(); d.Dy != 100 {
+		t.Errorf("stick up: direction %v, want dy 100", d)
+	}
+	press(p, DefaultMapping.Slow)
+	if d := p.Direction(); d.Dy != 50 {
+		t.Errorf("slow mode: direction %v, want dy 50", d)
+	}
+	press(p, DefaultMapping.Stop)
+	if d := p.Direction(); d.Dx != 0 || d.Dy != 0 {
+		t.Errorf("stopped: direction %v, want zero", d)
+	}
+}
+
+func TestButtons(t *testing.T) {
+	p := testPad(t)
+	press(p, DefaultMapping.Stop)
+	if !p.Stopped() {
+		t.Error("stop button didn't latch stop")
+	}
+	press(p, DefaultMapping.Profile)
+	press(p, DefaultMapping.Stop)
+	if p.Stopped() {
+		t.Error("second press of stop button didn't release it")
+	}
+	want := []Action{ActionStop, ActionProfile, ActionReleaseStop}
+	if got := actions(p); !slices.Equal(got, want) {
+		t.Errorf("actions %v, want %v", got, want)
+	}
+
+	// Holding a button doesn't repeat its action.
+	p.Update(Event{Type: typeButton, Number: DefaultMapping.Profile, Value: 1})
+	p.Update(Event{Type: typeButton, Number: DefaultMapping.Profile, Value: 1})
+	if got := actions(p); len(got) != 1 {
+		t.Errorf("held button: actions %v, want one", got)
+	}
+}
+
+func TestInitialStateIgnored(t *testing.T) {
+	p := testPad(t)
+	// Driver reports buttons held when the device is opened.
+	p.Update(Event{Type: typeButton | typeInit, Number: DefaultMapping.Stop, Value: 1})
+	p.Update(Event{Type: typeButton | typeInit, Number: DefaultMapping.Profile, Value: 1})
+	if p.Stopped() {
+		t.Error("initial state event latched stop")
+	}
+	if got := actions(p); len(got) != 0 {
+		t.Errorf("initial state events queued actions %v", got)
+	}
+}
+
+func TestActionsDropped(t *testing.T) {
+	p := testPad(t)
+	// Nobody reads actions, Update must not block.
+	for i := 0; i < 100; i++ {
+		press(p, DefaultMapping.Profile)
+	}
+	if got := actions(p); len(got) == 0 {
+		t.Error("no actions queued")
+	}
+}
+
+func TestNextProfile(t *testing.T) {
+	for _, tc := range []struct {
+		p, want pb.Profile
+	}{
+		{pb.Profile_PROFILE_SPORT, pb.Profile_PROFILE_INDOOR},
+		{pb.Profile_PROFILE_INDOOR, pb.Profile_PROFILE_KIDS},
+		{pb.Profile_PROFILE_KIDS, pb.Profile_PROFILE_SPORT},
+	} {
+		if got := NextProfile(tc.p); got != tc.want {
+			t.Errorf("NextProfile(%s) = %s, want %s", tc.p, got, tc.want)
+		}
+	}
+}
@@ -3,20 +3,32 @@ module github.com/pawelkowalak/berrybot
 go 1.25.0
 
 require (
+	github.com/blackjack/webcam v0.6.1
 	github.com/golang/protobuf v1.5.4
 	github.com/kidoman/embd v0.0.0-20170508013040-d3d8c0c5c68d
+	github.com/prometheus/client_golang v1.23.2
 	github.com/sirupsen/logrus v1.9.4
 	github.com/viru/gmlog v0.0.0-20160704083431-64dd08293638
+	go.starlark.net v0.0.0-20260210143700-b62fd896b91b
+	golang.org/x/image v0.36.0
 	golang.org/x/mobile v0.0.0-20260217195705-b56b3793a9c4
 	golang.org/x/net v0.50.0
+	golang.org/x/term v0.40.0
 	google.golang.org/grpc v1.79.1
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/golang/glog v1.2.5 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/common v0.66.1 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
+	go.yaml.in/yaml/v2 v2.4.2 // indirect
 	golang.org/x/exp/shiny v0.0.0-20260112195511-716be5621a96 // indirect
-	golang.org/x/image v0.36.0 // indirect
 	golang.org/x/sys v0.41.0 // indirect
 	golang.org/x/text v0.34.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251202230838-ff82c1b0f217 // indirect
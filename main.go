@@ -11,6 +11,7 @@ import (
 	"golang.org/x/mobile/event/size"
 	"golang.org/x/mobile/event/touch"
 	"golang.org/x/mobile/exp/gl/glutil"
+	"golang.org/x/mobile/exp/sensor"
 	"golang.org/x/mobile/exp/sprite"
 	"golang.org/x/mobile/exp/sprite/clock"
 	"golang.org/x/mobile/exp/sprite/glsprite"
@@ -21,6 +22,7 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 
 	app.Main(func(a app.App) {
+		sensor.Notify(a)
 		var glctx gl.Context
 		var sz size.Event
 		for e := range a.Events() {
@@ -48,11 +50,14 @@ func main() {
 				a.Publish()
 				a.Send(paint.Event{}) // keep animating
 			case touch.Event:
-				if e.Type == touch.TypeEnd {
-					bbot.ResetStick(sz)
+				if bbot.TouchSettings(sz, e) || bbot.TouchPhoto(sz, e) || bbot.TouchProfile(sz, e) {
 					break
 				}
-				bbot.SetStick(sz, e.X, e.Y)
+				bbot.TouchStick(sz, e)
+			case sensor.Event:
+				if bbot != nil {
+					bbot.Tilt(e)
+				}
 			}
 		}
 	})
@@ -74,9 +79,11 @@ func onStart(glctx gl.Context, sz size.Event) {
 	bbot = NewApp()
 	bbot.Reset(sz)
 	scene = bbot.Scene(eng, sz)
+	bbot.UpdateSensors()
 }
 
 func onStop() {
+	bbot.StopSensors()
 	eng.Release()
 	log.Release()
 	images.Release()
@@ -87,6 +94,7 @@ func onPaint(glctx gl.Context, sz size.Event) {
 	glctx.ClearColor(0, 0, 0, 1)
 	glctx.Clear(gl.COLOR_BUFFER_BIT)
 	now := clock.Time(time.Since(startTime) * 60 / time.Second)
+	bbot.Feedback(time.Now())
 	eng.Render(scene, now, sz)
 	log.Draw(sz)
 }
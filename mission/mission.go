@@ -0,0 +1,98 @@
+// Package mission reads missions, lists of driving steps executed by the bot.
+// Missions are stored as JSON with the same fields as steering.Mission, with
+// step actions named in lower case without STEP_ prefix, e.g.:
+//
+//	{
+//	  "version": 1,
+//	  "name": "example",
+//	  "steps": [
+//	    {"action": "forward", "value": 50},
+//	    {"action": "turn", "value": 90},
+//	    {"action": "wait", "value": 2},
+//	    {"action": "until_front", "value": 30}
+//	  ]
+//	}
+package mission
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+)
+
+// Version is the current mission format version.
+const Version = 1
+
+// Validate checks whether mission has supported version and sane steps.
+func Validate(m *pb.Mission) error {
+	if m.Version != Version {
+		return fmt.Errorf("unsupported mission version %d, want %d", m.Version, Version)
+	}
+	if len(m.Steps) == 0 {
+		return fmt.Errorf("mission has no steps")
+	}
+	for i, s := range m.Steps {
+		// Infinite step would never finish.
+		if v := float64(s.Value); math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("step %d: %v value %v isn't finite", i+1, s.Action, s.Value)
+		}
+		var ok bool
+		switch s.Action {
+		case pb.StepAction_STEP_TURN:
+			ok = s.Value != 0
+		case pb.StepAction_STEP_FORWARD, pb.StepAction_STEP_BACKWARD, pb.StepAction_STEP_WAIT, pb.StepAction_STEP_UNTIL_FRONT:
+			ok = s.Value > 0
+		default:
+			return fmt.Errorf("step %d: unknown action %v", i+1, s.Action)
+		}
+		if !ok {
+			return fmt.Errorf("step %d: invalid %v value %v", i+1, s.Action, s.Value)
+		}
+	}
+	return nil
+}
+
+// JSON representation of pb.Mission.
+type file struct {
+	Version int32  `json:"version"`
+	Name    string `json:"name"`
+	Steps   []struct {
+		Action string  `json:"action"`
+		Value  float32 `json:"value"`
+	} `json:"steps"`
+}
+
+// Read parses and validates mission.
+func Read(r io.Reader) (*pb.Mission, error) {
+	var f file
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("can't parse mission: %v", err)
+	}
+	m := &pb.Mission{Version: f.Version, Name: f.Name}
+	for i, s := range f.Steps {
+		a, ok := pb.StepAction_value["STEP_"+strings.ToUpper(s.Action)]
+		if !ok {
+			return nil, fmt.Errorf("step %d: unknown action %q", i+1, s.Action)
+		}
+		m.Steps = append(m.Steps, &pb.MissionStep{Action: pb.StepAction(a), Value: s.Value})
+	}
+	if err := Validate(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadFile reads mission from file.
+func ReadFile(path string) (*pb.Mission, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open mission: %v", err)
+	}
+	defer f.Close()
+	return Read(f)
+}
@@ -0,0 +1,131 @@
+package mission
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestRead(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		json string
+		want *pb.Mission // Nil when reading fails.
+	}{
+		{
+			name: "example",
+			json: `{"version": 1, "name": "example", "steps": [
+				{"action": "forward", "value": 50},
+				{"action": "turn", "value": 90},
+				{"action": "wait", "value": 2},
+				{"action": "until_front", "value": 30}]}`,
+			want: &pb.Mission{Version: 1, Name: "example", Steps: []*pb.MissionStep{
+				{Action: pb.StepAction_STEP_FORWARD, Value: 50},
+				{Action: pb.StepAction_STEP_TURN, Value: 90},
+				{Action: pb.StepAction_STEP_WAIT, Value: 2},
+				{Action: pb.StepAction_STEP_UNTIL_FRONT, Value: 30},
+			}},
+		},
+		{
+			name: "action in any case",
+			json: `{"version": 1, "steps": [{"action": "BackWard", "value": 10}]}`,
+			want: &pb.Mission{Version: 1, Steps: []*pb.MissionStep{{Action: pb.StepAction_STEP_BACKWARD, Value: 10}}},
+		},
+		{
+			name: "left turn",
+			json: `{"version": 1, "steps": [{"action": "turn", "value": -45.5}]}`,
+			want: &pb.Mission{Version: 1, Steps: []*pb.MissionStep{{Action: pb.StepAction_STEP_TURN, Value: -45.5}}},
+		},
+		{name: "bad json", json: `{"version": 1, "steps": [`},
+		{name: "no version", json: `{"steps": [{"action": "forward", "value": 10}]}`},
+		{name: "newer version", json: `{"version": 2, "steps": [{"action": "forward", "value": 10}]}`},
+		{name: "no steps", json: `{"version": 1, "steps": []}`},
+		{name: "unknown action", json: `{"version": 1, "steps": [{"action": "jump", "value": 10}]}`},
+		{name: "prefixed action", json: `{"version": 1, "steps": [{"action": "step_forward", "value": 10}]}`},
+		{name: "no action", json: `{"version": 1, "steps": [{"value": 10}]}`},
+		{name: "no turn", json: `{"version": 1, "steps": [{"action": "turn", "value": 0}]}`},
+		{name: "negative distance", json: `{"version": 1, "steps": [{"action": "forward", "value": -10}]}`},
+		{name: "no wait", json: `{"version": 1, "steps": [{"action": "wait"}]}`},
+		{name: "negative front", json: `{"version": 1, "steps": [{"action": "until_front", "value": -1}]}`},
+		{name: "value not a number", json: `{"version": 1, "steps": [{"action": "forward", "value": "10"}]}`},
+	} {
+		m, err := Read(strings.NewReader(tc.json))
+		switch {
+		case tc.want == nil && err == nil:
+			t.Errorf("%s: Read succeeded with %v", tc.name, m)
+		case tc.want != nil && err != nil:
+			t.Errorf("%s: Read failed: %v", tc.name, err)
+		case tc.want != nil && !proto.Equal(m, tc.want):
+			t.Errorf("%s: Read = %v, want %v", tc.name, m, tc.want)
+		}
+	}
+}
+
+func TestReadErrorNamesStep(t *testing.T) {
+	_, err := Read(strings.NewReader(`{"version": 1, "steps": [{"action": "wait", "value": 1}, {"action": "turn"}]}`))
+	if err == nil || !strings.Contains(err.Error(), "step 2") {
+		t.Errorf("Read error %v, want one about step 2", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	inf, nan := float32(math.Inf(1)), float32(math.NaN())
+	for _, tc := range []struct {
+		name   string
+		action pb.StepAction
+		value  float32
+		ok     bool
+	}{
+		{"forward", pb.StepAction_STEP_FORWARD, 50, true},
+		{"turn left", pb.StepAction_STEP_TURN, -90, true},
+		{"wait", pb.StepAction_STEP_WAIT, 0.5, true},
+		{"unknown action", pb.StepAction(42), 1, false},
+		{"zero turn", pb.StepAction_STEP_TURN, 0, false},
+		{"negative wait", pb.StepAction_STEP_WAIT, -1, false},
+		{"infinite forward", pb.StepAction_STEP_FORWARD, inf, false},
+		{"infinite backward", pb.StepAction_STEP_BACKWARD, inf, false},
+		{"infinite turn", pb.StepAction_STEP_TURN, inf, false},
+		{"negative infinite turn", pb.StepAction_STEP_TURN, -inf, false},
+		{"infinite wait", pb.StepAction_STEP_WAIT, inf, false},
+		{"infinite until front", pb.StepAction_STEP_UNTIL_FRONT, inf, false},
+		{"NaN turn", pb.StepAction_STEP_TURN, nan, false},
+		{"NaN forward", pb.StepAction_STEP_FORWARD, nan, false},
+	} {
+		m := &pb.Mission{Version: Version, Steps: []*pb.MissionStep{
+			{Action: pb.StepAction_STEP_WAIT, Value: 1},
+			{Action: tc.action, Value: tc.value},
+		}}
+		err := Validate(m)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: Validate error %v, want ok %v", tc.name, err, tc.ok)
+		}
+		if err != nil && !strings.HasPrefix(err.Error(), "step 2:") {
+			t.Errorf("%s: error %q doesn't name step 2", tc.name, err)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "square.json")
+	json := `{"version": 1, "name": "square", "steps": [{"action": "forward", "value": 50}, {"action": "turn", "value": 90}]}`
+	if err := os.WriteFile(name, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "square" || len(m.Steps) != 2 {
+		t.Errorf("ReadFile = %v, want square with 2 steps", m)
+	}
+	if _, err := ReadFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("ReadFile of missing file succeeded")
+	}
+}
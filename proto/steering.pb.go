@@ -9,8 +9,34 @@ It is generated from these files:
 	steering.proto
 
 It has these top-level messages:
+	HelloRequest
+	HelloResponse
 	Direction
+	Range
 	Telemetry
+	Record
+	StatusRequest
+	SubsystemStatus
+	Status
+	ModeRequest
+	ModeResponse
+	MissionStep
+	Mission
+	MissionControl
+	Script
+	EmergencyStopRequest
+	ClearEmergencyStopRequest
+	EmergencyStopState
+	ProfileRequest
+	ProfileResponse
+	Image
+	Frame
+	TakePhotoRequest
+	PhotoInfo
+	ListPhotosRequest
+	PhotoList
+	GetPhotoRequest
+	PhotoChunk
 */
 package steering
 
@@ -28,26 +54,597 @@ var _ = proto.Marshal
 var _ = fmt.Errorf
 var _ = math.Inf
 
+// ImuState reports crash detection results from the inertial sensor.
+type ImuState int32
+
+const (
+	ImuState_UPRIGHT ImuState = 0
+	ImuState_IMPACT  ImuState = 1
+	ImuState_TIPPED  ImuState = 2
+)
+
+var ImuState_name = map[int32]string{
+	0: "UPRIGHT",
+	1: "IMPACT",
+	2: "TIPPED",
+}
+var ImuState_value = map[string]int32{
+	"UPRIGHT": 0,
+	"IMPACT":  1,
+	"TIPPED":  2,
+}
+
+func (x ImuState) String() string {
+	return proto.EnumName(ImuState_name, int32(x))
+}
+
+// BatteryState reports battery level relative to configured thresholds.
+type BatteryState int32
+
+const (
+	BatteryState_BATTERY_UNKNOWN BatteryState = 0
+	BatteryState_BATTERY_OK      BatteryState = 1
+	BatteryState_BATTERY_LOW     BatteryState = 2
+	BatteryState_BATTERY_EMPTY   BatteryState = 3
+)
+
+var BatteryState_name = map[int32]string{
+	0: "BATTERY_UNKNOWN",
+	1: "BATTERY_OK",
+	2: "BATTERY_LOW",
+	3: "BATTERY_EMPTY",
+}
+var BatteryState_value = map[string]int32{
+	"BATTERY_UNKNOWN": 0,
+	"BATTERY_OK":      1,
+	"BATTERY_LOW":     2,
+	"BATTERY_EMPTY":   3,
+}
+
+func (x BatteryState) String() string {
+	return proto.EnumName(BatteryState_name, int32(x))
+}
+
+// Mode is driving mode, manual with directions from clients or autonomous.
+type Mode int32
+
+const (
+	Mode_MANUAL Mode = 0
+	// Drive around avoiding obstacles.
+	Mode_WANDER Mode = 1
+	// Keep set distance to a wall on the side.
+	Mode_WALL_FOLLOW Mode = 2
+	// Execute mission uploaded with RunMission.
+	Mode_MISSION Mode = 3
+	// Drive with behavior script uploaded with RunScript.
+	Mode_SCRIPT Mode = 4
+)
+
+var Mode_name = map[int32]string{
+	0: "MANUAL",
+	1: "WANDER",
+	2: "WALL_FOLLOW",
+	3: "MISSION",
+	4: "SCRIPT",
+}
+var Mode_value = map[string]int32{
+	"MANUAL":      0,
+	"WANDER":      1,
+	"WALL_FOLLOW": 2,
+	"MISSION":     3,
+	"SCRIPT":      4,
+}
+
+func (x Mode) String() string {
+	return proto.EnumName(Mode_name, int32(x))
+}
+
+// StepAction is what a mission step does with its value.
+type StepAction int32
+
+const (
+	// Drive forward or backward value cm.
+	StepAction_STEP_FORWARD  StepAction = 0
+	StepAction_STEP_BACKWARD StepAction = 1
+	// Turn in place value degrees, clockwise when positive.
+	StepAction_STEP_TURN StepAction = 2
+	// Stand still for value seconds.
+	StepAction_STEP_WAIT StepAction = 3
+	// Drive forward until front distance drops below value cm.
+	StepAction_STEP_UNTIL_FRONT StepAction = 4
+)
+
+var StepAction_name = map[int32]string{
+	0: "STEP_FORWARD",
+	1: "STEP_BACKWARD",
+	2: "STEP_TURN",
+	3: "STEP_WAIT",
+	4: "STEP_UNTIL_FRONT",
+}
+var StepAction_value = map[string]int32{
+	"STEP_FORWARD":     0,
+	"STEP_BACKWARD":    1,
+	"STEP_TURN":        2,
+	"STEP_WAIT":        3,
+	"STEP_UNTIL_FRONT": 4,
+}
+
+func (x StepAction) String() string {
+	return proto.EnumName(StepAction_name, int32(x))
+}
+
+type MissionAction int32
+
+const (
+	MissionAction_MISSION_PAUSE  MissionAction = 0
+	MissionAction_MISSION_RESUME MissionAction = 1
+	MissionAction_MISSION_ABORT  MissionAction = 2
+)
+
+var MissionAction_name = map[int32]string{
+	0: "MISSION_PAUSE",
+	1: "MISSION_RESUME",
+	2: "MISSION_ABORT",
+}
+var MissionAction_value = map[string]int32{
+	"MISSION_PAUSE":  0,
+	"MISSION_RESUME": 1,
+	"MISSION_ABORT":  2,
+}
+
+func (x MissionAction) String() string {
+	return proto.EnumName(MissionAction_name, int32(x))
+}
+
+// Profile is driving profile, from full power to gentle driving for beginners.
+type Profile int32
+
+const (
+	Profile_PROFILE_SPORT  Profile = 0
+	Profile_PROFILE_INDOOR Profile = 1
+	Profile_PROFILE_KIDS   Profile = 2
+)
+
+var Profile_name = map[int32]string{
+	0: "PROFILE_SPORT",
+	1: "PROFILE_INDOOR",
+	2: "PROFILE_KIDS",
+}
+var Profile_value = map[string]int32{
+	"PROFILE_SPORT":  0,
+	"PROFILE_INDOOR": 1,
+	"PROFILE_KIDS":   2,
+}
+
+func (x Profile) String() string {
+	return proto.EnumName(Profile_name, int32(x))
+}
+
+// Modifier changes power of manual driving for a while, e.g. when button is held.
+type Modifier int32
+
+const (
+	Modifier_MODIFIER_NONE Modifier = 0
+	// Boost ignores throttle and drives at full power.
+	Modifier_MODIFIER_BOOST Modifier = 1
+	// Precision halves power for fine maneuvers.
+	Modifier_MODIFIER_PRECISION Modifier = 2
+)
+
+var Modifier_name = map[int32]string{
+	0: "MODIFIER_NONE",
+	1: "MODIFIER_BOOST",
+	2: "MODIFIER_PRECISION",
+}
+var Modifier_value = map[string]int32{
+	"MODIFIER_NONE":      0,
+	"MODIFIER_BOOST":     1,
+	"MODIFIER_PRECISION": 2,
+}
+
+func (x Modifier) String() string {
+	return proto.EnumName(Modifier_name, int32(x))
+}
+
+type HelloRequest struct {
+	ProtocolVersion int32 `protobuf:"varint,1,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+	// Client name and build, e.g. "bbcli dev".
+	Client       string   `protobuf:"bytes,2,opt,name=client" json:"client,omitempty"`
+	Capabilities []string `protobuf:"bytes,3,rep,name=capabilities" json:"capabilities,omitempty"`
+}
+
+func (m *HelloRequest) Reset()         { *m = HelloRequest{} }
+func (m *HelloRequest) String() string { return proto.CompactTextString(m) }
+func (*HelloRequest) ProtoMessage()    {}
+
+type HelloResponse struct {
+	ProtocolVersion int32 `protobuf:"varint,1,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+	// The oldest client protocol version the bot still serves.
+	MinProtocolVersion int32  `protobuf:"varint,2,opt,name=minProtocolVersion" json:"minProtocolVersion,omitempty"`
+	Build              string `protobuf:"bytes,3,opt,name=build" json:"build,omitempty"`
+	// Capabilities of the bot, see protocol package for names.
+	Capabilities []string `protobuf:"bytes,4,rep,name=capabilities" json:"capabilities,omitempty"`
+}
+
+func (m *HelloResponse) Reset()         { *m = HelloResponse{} }
+func (m *HelloResponse) String() string { return proto.CompactTextString(m) }
+func (*HelloResponse) ProtoMessage()    {}
+
 // Direction is normalized delta x and y that corresponds to joystick position. Range should be between -100 and 100.
+// Other fields are optional. Values out of range are clamped into it, directions mixing
+// dx and dy with left and right wheel powers are rejected.
 type Direction struct {
 	Dx int32 `protobuf:"varint,1,opt,name=dx" json:"dx,omitempty"`
 	Dy int32 `protobuf:"varint,2,opt,name=dy" json:"dy,omitempty"`
+	// Percent of power at full stick deflection, 0 means 100.
+	Throttle int32 `protobuf:"varint,3,opt,name=throttle" json:"throttle,omitempty"`
+	// Tank drives with left and right wheel powers between -100 and 100
+	// instead of dx and dy.
+	Tank  bool  `protobuf:"varint,4,opt,name=tank" json:"tank,omitempty"`
+	Left  int32 `protobuf:"varint,5,opt,name=left" json:"left,omitempty"`
+	Right int32 `protobuf:"varint,6,opt,name=right" json:"right,omitempty"`
+	// Client time in Unix nanoseconds and sequence number growing with each
+	// direction sent on stream, for spotting latency and reordering.
+	Timestamp int64    `protobuf:"varint,7,opt,name=timestamp" json:"timestamp,omitempty"`
+	Seq       uint64   `protobuf:"varint,8,opt,name=seq" json:"seq,omitempty"`
+	Modifier  Modifier `protobuf:"varint,9,opt,name=modifier,enum=steering.Modifier" json:"modifier,omitempty"`
 }
 
 func (m *Direction) Reset()         { *m = Direction{} }
 func (m *Direction) String() string { return proto.CompactTextString(m) }
 func (*Direction) ProtoMessage()    {}
 
+// Range is the latest reading of a proximity sensor.
+type Range struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Mounting angle in degrees, clockwise from the front of the bot.
+	Angle float32 `protobuf:"fixed32,2,opt,name=angle" json:"angle,omitempty"`
+	// Distance in cm, only meaningful when valid.
+	Dist int32 `protobuf:"varint,3,opt,name=dist" json:"dist,omitempty"`
+	// Sensor has measured successfully at least once.
+	Valid bool `protobuf:"varint,4,opt,name=valid" json:"valid,omitempty"`
+	// Milliseconds since the measurement.
+	Age int32 `protobuf:"varint,5,opt,name=age" json:"age,omitempty"`
+}
+
+func (m *Range) Reset()         { *m = Range{} }
+func (m *Range) String() string { return proto.CompactTextString(m) }
+func (*Range) ProtoMessage()    {}
+
 type Telemetry struct {
 	Speed     int32 `protobuf:"varint,1,opt,name=speed" json:"speed,omitempty"`
 	DistFront int32 `protobuf:"varint,2,opt,name=distFront" json:"distFront,omitempty"`
 	DistRear  int32 `protobuf:"varint,3,opt,name=distRear" json:"distRear,omitempty"`
+	// Heading in degrees (0-360) and angular rate in degrees per second.
+	Heading     float32 `protobuf:"fixed32,4,opt,name=heading" json:"heading,omitempty"`
+	AngularRate float32 `protobuf:"fixed32,5,opt,name=angularRate" json:"angularRate,omitempty"`
+	// Acceleration magnitude in g.
+	Accel    float32  `protobuf:"fixed32,6,opt,name=accel" json:"accel,omitempty"`
+	ImuState ImuState `protobuf:"varint,7,opt,name=imuState,enum=steering.ImuState" json:"imuState,omitempty"`
+	// Dead-reckoned position in cm, relative to the start position.
+	PosX float32 `protobuf:"fixed32,8,opt,name=posX" json:"posX,omitempty"`
+	PosY float32 `protobuf:"fixed32,9,opt,name=posY" json:"posY,omitempty"`
+	// Battery pack voltage, charge percentage and estimated minutes left, which
+	// is 0 when unknown.
+	BatteryVoltage float32      `protobuf:"fixed32,10,opt,name=batteryVoltage" json:"batteryVoltage,omitempty"`
+	BatteryPercent int32        `protobuf:"varint,11,opt,name=batteryPercent" json:"batteryPercent,omitempty"`
+	BatteryMinutes int32        `protobuf:"varint,12,opt,name=batteryMinutes" json:"batteryMinutes,omitempty"`
+	BatteryState   BatteryState `protobuf:"varint,13,opt,name=batteryState,enum=steering.BatteryState" json:"batteryState,omitempty"`
+	// Last drive command and engine powers, negative when going backward.
+	Cmd        string `protobuf:"bytes,14,opt,name=cmd" json:"cmd,omitempty"`
+	LeftPower  int32  `protobuf:"varint,15,opt,name=leftPower" json:"leftPower,omitempty"`
+	RightPower int32  `protobuf:"varint,16,opt,name=rightPower" json:"rightPower,omitempty"`
+	Mode       Mode   `protobuf:"varint,17,opt,name=mode,enum=steering.Mode" json:"mode,omitempty"`
+	// Distance measured by optional side sensor.
+	DistSide int32 `protobuf:"varint,18,opt,name=distSide" json:"distSide,omitempty"`
+	// Running mission progress: current step counted from 1, number of steps,
+	// whether it's paused and done fraction of current step.
+	MissionStep     int32   `protobuf:"varint,19,opt,name=missionStep" json:"missionStep,omitempty"`
+	MissionSteps    int32   `protobuf:"varint,20,opt,name=missionSteps" json:"missionSteps,omitempty"`
+	MissionPaused   bool    `protobuf:"varint,21,opt,name=missionPaused" json:"missionPaused,omitempty"`
+	MissionStepDone float32 `protobuf:"fixed32,22,opt,name=missionStepDone" json:"missionStepDone,omitempty"`
+	// Latched emergency stop and why it was requested.
+	EmergencyStop       bool    `protobuf:"varint,23,opt,name=emergencyStop" json:"emergencyStop,omitempty"`
+	EmergencyStopReason string  `protobuf:"bytes,24,opt,name=emergencyStopReason" json:"emergencyStopReason,omitempty"`
+	Profile             Profile `protobuf:"varint,25,opt,name=profile,enum=steering.Profile" json:"profile,omitempty"`
+	// Readings of all proximity sensors, including those above.
+	Ranges []*Range `protobuf:"bytes,26,rep,name=ranges" json:"ranges,omitempty"`
 }
 
 func (m *Telemetry) Reset()         { *m = Telemetry{} }
 func (m *Telemetry) String() string { return proto.CompactTextString(m) }
 func (*Telemetry) ProtoMessage()    {}
 
+func (m *Telemetry) GetRanges() []*Range {
+	if m != nil {
+		return m.Ranges
+	}
+	return nil
+}
+
+// Record is a single entry of a recorded driving session. Either direction
+// with the resulting drive command and engine outputs, or telemetry is set.
+type Record struct {
+	// Unix time in nanoseconds.
+	Timestamp    int64      `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Direction    *Direction `protobuf:"bytes,2,opt,name=direction" json:"direction,omitempty"`
+	Telemetry    *Telemetry `protobuf:"bytes,3,opt,name=telemetry" json:"telemetry,omitempty"`
+	Cmd          string     `protobuf:"bytes,4,opt,name=cmd" json:"cmd,omitempty"`
+	LeftPower    int32      `protobuf:"varint,5,opt,name=leftPower" json:"leftPower,omitempty"`
+	RightPower   int32      `protobuf:"varint,6,opt,name=rightPower" json:"rightPower,omitempty"`
+	LeftForward  bool       `protobuf:"varint,7,opt,name=leftForward" json:"leftForward,omitempty"`
+	RightForward bool       `protobuf:"varint,8,opt,name=rightForward" json:"rightForward,omitempty"`
+}
+
+func (m *Record) Reset()         { *m = Record{} }
+func (m *Record) String() string { return proto.CompactTextString(m) }
+func (*Record) ProtoMessage()    {}
+
+func (m *Record) GetDirection() *Direction {
+	if m != nil {
+		return m.Direction
+	}
+	return nil
+}
+
+func (m *Record) GetTelemetry() *Telemetry {
+	if m != nil {
+		return m.Telemetry
+	}
+	return nil
+}
+
+type StatusRequest struct {
+}
+
+func (m *StatusRequest) Reset()         { *m = StatusRequest{} }
+func (m *StatusRequest) String() string { return proto.CompactTextString(m) }
+func (*StatusRequest) ProtoMessage()    {}
+
+// SubsystemStatus reports health of a single subsystem, e.g. front echo.
+type SubsystemStatus struct {
+	Name    string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Serving bool   `protobuf:"varint,2,opt,name=serving" json:"serving,omitempty"`
+	// Unix time in nanoseconds of the last success and the last error, 0 if none.
+	LastOk        int64  `protobuf:"varint,3,opt,name=lastOk" json:"lastOk,omitempty"`
+	LastError     string `protobuf:"bytes,4,opt,name=lastError" json:"lastError,omitempty"`
+	LastErrorTime int64  `protobuf:"varint,5,opt,name=lastErrorTime" json:"lastErrorTime,omitempty"`
+}
+
+func (m *SubsystemStatus) Reset()         { *m = SubsystemStatus{} }
+func (m *SubsystemStatus) String() string { return proto.CompactTextString(m) }
+func (*SubsystemStatus) ProtoMessage()    {}
+
+// Status describes running server.
+type Status struct {
+	Version string `protobuf:"bytes,1,opt,name=version" json:"version,omitempty"`
+	// Uptime in seconds.
+	Uptime int64 `protobuf:"varint,2,opt,name=uptime" json:"uptime,omitempty"`
+	// Command line flags the server was started with.
+	Config     map[string]string  `protobuf:"bytes,3,rep,name=config" json:"config,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Subsystems []*SubsystemStatus `protobuf:"bytes,4,rep,name=subsystems" json:"subsystems,omitempty"`
+}
+
+func (m *Status) Reset()         { *m = Status{} }
+func (m *Status) String() string { return proto.CompactTextString(m) }
+func (*Status) ProtoMessage()    {}
+
+func (m *Status) GetConfig() map[string]string {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *Status) GetSubsystems() []*SubsystemStatus {
+	if m != nil {
+		return m.Subsystems
+	}
+	return nil
+}
+
+type ModeRequest struct {
+	Mode Mode `protobuf:"varint,1,opt,name=mode,enum=steering.Mode" json:"mode,omitempty"`
+}
+
+func (m *ModeRequest) Reset()         { *m = ModeRequest{} }
+func (m *ModeRequest) String() string { return proto.CompactTextString(m) }
+func (*ModeRequest) ProtoMessage()    {}
+
+type ModeResponse struct {
+	Mode Mode `protobuf:"varint,1,opt,name=mode,enum=steering.Mode" json:"mode,omitempty"`
+}
+
+func (m *ModeResponse) Reset()         { *m = ModeResponse{} }
+func (m *ModeResponse) String() string { return proto.CompactTextString(m) }
+func (*ModeResponse) ProtoMessage()    {}
+
+type MissionStep struct {
+	Action StepAction `protobuf:"varint,1,opt,name=action,enum=steering.StepAction" json:"action,omitempty"`
+	Value  float32    `protobuf:"fixed32,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *MissionStep) Reset()         { *m = MissionStep{} }
+func (m *MissionStep) String() string { return proto.CompactTextString(m) }
+func (*MissionStep) ProtoMessage()    {}
+
+// Mission is a list of steps executed one after another. Missions are stored
+// as JSON mapping of this message.
+type Mission struct {
+	// Format version, currently 1.
+	Version int32          `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	Name    string         `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	Steps   []*MissionStep `protobuf:"bytes,3,rep,name=steps" json:"steps,omitempty"`
+}
+
+func (m *Mission) Reset()         { *m = Mission{} }
+func (m *Mission) String() string { return proto.CompactTextString(m) }
+func (*Mission) ProtoMessage()    {}
+
+func (m *Mission) GetSteps() []*MissionStep {
+	if m != nil {
+		return m.Steps
+	}
+	return nil
+}
+
+type MissionControl struct {
+	Action MissionAction `protobuf:"varint,1,opt,name=action,enum=steering.MissionAction" json:"action,omitempty"`
+}
+
+func (m *MissionControl) Reset()         { *m = MissionControl{} }
+func (m *MissionControl) String() string { return proto.CompactTextString(m) }
+func (*MissionControl) ProtoMessage()    {}
+
+// Script is a Starlark behavior script, see README for the API.
+type Script struct {
+	Name   string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Source string `protobuf:"bytes,2,opt,name=source" json:"source,omitempty"`
+}
+
+func (m *Script) Reset()         { *m = Script{} }
+func (m *Script) String() string { return proto.CompactTextString(m) }
+func (*Script) ProtoMessage()    {}
+
+type EmergencyStopRequest struct {
+	Reason string `protobuf:"bytes,1,opt,name=reason" json:"reason,omitempty"`
+}
+
+func (m *EmergencyStopRequest) Reset()         { *m = EmergencyStopRequest{} }
+func (m *EmergencyStopRequest) String() string { return proto.CompactTextString(m) }
+func (*EmergencyStopRequest) ProtoMessage()    {}
+
+type ClearEmergencyStopRequest struct {
+}
+
+func (m *ClearEmergencyStopRequest) Reset()         { *m = ClearEmergencyStopRequest{} }
+func (m *ClearEmergencyStopRequest) String() string { return proto.CompactTextString(m) }
+func (*ClearEmergencyStopRequest) ProtoMessage()    {}
+
+type EmergencyStopState struct {
+	Stopped bool   `protobuf:"varint,1,opt,name=stopped" json:"stopped,omitempty"`
+	Reason  string `protobuf:"bytes,2,opt,name=reason" json:"reason,omitempty"`
+	// Unix time in nanoseconds when it was latched, 0 when not stopped.
+	Since int64 `protobuf:"varint,3,opt,name=since" json:"since,omitempty"`
+}
+
+func (m *EmergencyStopState) Reset()         { *m = EmergencyStopState{} }
+func (m *EmergencyStopState) String() string { return proto.CompactTextString(m) }
+func (*EmergencyStopState) ProtoMessage()    {}
+
+type ProfileRequest struct {
+	Profile Profile `protobuf:"varint,1,opt,name=profile,enum=steering.Profile" json:"profile,omitempty"`
+}
+
+func (m *ProfileRequest) Reset()         { *m = ProfileRequest{} }
+func (m *ProfileRequest) String() string { return proto.CompactTextString(m) }
+func (*ProfileRequest) ProtoMessage()    {}
+
+type ProfileResponse struct {
+	Profile Profile `protobuf:"varint,1,opt,name=profile,enum=steering.Profile" json:"profile,omitempty"`
+}
+
+func (m *ProfileResponse) Reset()         { *m = ProfileResponse{} }
+func (m *ProfileResponse) String() string { return proto.CompactTextString(m) }
+func (*ProfileResponse) ProtoMessage()    {}
+
+type Image struct {
+	Live bool `protobuf:"varint,1,opt,name=live" json:"live,omitempty"`
+	// Maximum frames per second of live video, 0 or more than the camera rate
+	// means the camera rate.
+	Rate float32 `protobuf:"fixed32,2,opt,name=rate" json:"rate,omitempty"`
+}
+
+func (m *Image) Reset()         { *m = Image{} }
+func (m *Image) String() string { return proto.CompactTextString(m) }
+func (*Image) ProtoMessage()    {}
+
+type Frame struct {
+	// Unix time in nanoseconds when the frame was captured.
+	Timestamp int64  `protobuf:"varint,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Width     int32  `protobuf:"varint,2,opt,name=width" json:"width,omitempty"`
+	Height    int32  `protobuf:"varint,3,opt,name=height" json:"height,omitempty"`
+	Jpeg      []byte `protobuf:"bytes,4,opt,name=jpeg,proto3" json:"jpeg,omitempty"`
+}
+
+func (m *Frame) Reset()         { *m = Frame{} }
+func (m *Frame) String() string { return proto.CompactTextString(m) }
+func (*Frame) ProtoMessage()    {}
+
+type TakePhotoRequest struct {
+}
+
+func (m *TakePhotoRequest) Reset()         { *m = TakePhotoRequest{} }
+func (m *TakePhotoRequest) String() string { return proto.CompactTextString(m) }
+func (*TakePhotoRequest) ProtoMessage()    {}
+
+// PhotoInfo describes stored photo and where the bot was when taking it.
+type PhotoInfo struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Unix time in nanoseconds.
+	Timestamp int64 `protobuf:"varint,2,opt,name=timestamp" json:"timestamp,omitempty"`
+	Width     int32 `protobuf:"varint,3,opt,name=width" json:"width,omitempty"`
+	Height    int32 `protobuf:"varint,4,opt,name=height" json:"height,omitempty"`
+	// Size of JPEG file in bytes.
+	Size      int64   `protobuf:"varint,5,opt,name=size" json:"size,omitempty"`
+	PosX      float32 `protobuf:"fixed32,6,opt,name=posX" json:"posX,omitempty"`
+	PosY      float32 `protobuf:"fixed32,7,opt,name=posY" json:"posY,omitempty"`
+	Heading   float32 `protobuf:"fixed32,8,opt,name=heading" json:"heading,omitempty"`
+	DistFront int32   `protobuf:"varint,9,opt,name=distFront" json:"distFront,omitempty"`
+	DistRear  int32   `protobuf:"varint,10,opt,name=distRear" json:"distRear,omitempty"`
+	DistSide  int32   `protobuf:"varint,11,opt,name=distSide" json:"distSide,omitempty"`
+}
+
+func (m *PhotoInfo) Reset()         { *m = PhotoInfo{} }
+func (m *PhotoInfo) String() string { return proto.CompactTextString(m) }
+func (*PhotoInfo) ProtoMessage()    {}
+
+type ListPhotosRequest struct {
+}
+
+func (m *ListPhotosRequest) Reset()         { *m = ListPhotosRequest{} }
+func (m *ListPhotosRequest) String() string { return proto.CompactTextString(m) }
+func (*ListPhotosRequest) ProtoMessage()    {}
+
+type PhotoList struct {
+	Photos []*PhotoInfo `protobuf:"bytes,1,rep,name=photos" json:"photos,omitempty"`
+}
+
+func (m *PhotoList) Reset()         { *m = PhotoList{} }
+func (m *PhotoList) String() string { return proto.CompactTextString(m) }
+func (*PhotoList) ProtoMessage()    {}
+
+func (m *PhotoList) GetPhotos() []*PhotoInfo {
+	if m != nil {
+		return m.Photos
+	}
+	return nil
+}
+
+type GetPhotoRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *GetPhotoRequest) Reset()         { *m = GetPhotoRequest{} }
+func (m *GetPhotoRequest) String() string { return proto.CompactTextString(m) }
+func (*GetPhotoRequest) ProtoMessage()    {}
+
+type PhotoChunk struct {
+	Info *PhotoInfo `protobuf:"bytes,1,opt,name=info" json:"info,omitempty"`
+	Data []byte     `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *PhotoChunk) Reset()         { *m = PhotoChunk{} }
+func (m *PhotoChunk) String() string { return proto.CompactTextString(m) }
+func (*PhotoChunk) ProtoMessage()    {}
+
+func (m *PhotoChunk) GetInfo() *PhotoInfo {
+	if m != nil {
+		return m.Info
+	}
+	return nil
+}
+
 // Reference imports to suppress errors if they are not otherwise used.
 var _ context.Context
 var _ grpc.ClientConn
@@ -55,8 +652,38 @@ var _ grpc.ClientConn
 // Client API for Driver service
 
 type DriverClient interface {
+	// Hello exchanges protocol version and capabilities, clients call it right
+	// after connecting. Bots older than it speak protocol version 0.
+	Hello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloResponse, error)
 	// Drive is a client-to-server stream providing direction.
 	Drive(ctx context.Context, opts ...grpc.CallOption) (Driver_DriveClient, error)
+	// GetStatus reports version, uptime, configuration and health of subsystems.
+	GetStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*Status, error)
+	// SetMode switches between manual driving and autonomous modes. Directions
+	// from clients are ignored while autonomous mode is on.
+	SetMode(ctx context.Context, in *ModeRequest, opts ...grpc.CallOption) (*ModeResponse, error)
+	// RunMission starts executing mission in mission mode.
+	RunMission(ctx context.Context, in *Mission, opts ...grpc.CallOption) (*ModeResponse, error)
+	// ControlMission pauses, resumes or aborts running mission.
+	ControlMission(ctx context.Context, in *MissionControl, opts ...grpc.CallOption) (*ModeResponse, error)
+	// RunScript starts driving with behavior script in script mode.
+	RunScript(ctx context.Context, in *Script, opts ...grpc.CallOption) (*ModeResponse, error)
+	// EmergencyStop stops motors and keeps them off, whatever drives the bot,
+	// until ClearEmergencyStop is called.
+	EmergencyStop(ctx context.Context, in *EmergencyStopRequest, opts ...grpc.CallOption) (*EmergencyStopState, error)
+	ClearEmergencyStop(ctx context.Context, in *ClearEmergencyStopRequest, opts ...grpc.CallOption) (*EmergencyStopState, error)
+	// SetProfile switches driving profile, which limits power, acceleration and
+	// stick sensitivity.
+	SetProfile(ctx context.Context, in *ProfileRequest, opts ...grpc.CallOption) (*ProfileResponse, error)
+	// GetImage streams JPEG frames from the camera, a single one unless live
+	// video is requested.
+	GetImage(ctx context.Context, in *Image, opts ...grpc.CallOption) (Driver_GetImageClient, error)
+	// TakePhoto captures full resolution still and stores it on the bot.
+	TakePhoto(ctx context.Context, in *TakePhotoRequest, opts ...grpc.CallOption) (*PhotoInfo, error)
+	// ListPhotos lists stored photos, the newest first.
+	ListPhotos(ctx context.Context, in *ListPhotosRequest, opts ...grpc.CallOption) (*PhotoList, error)
+	// GetPhoto downloads stored photo in chunks, the first one has its info.
+	GetPhoto(ctx context.Context, in *GetPhotoRequest, opts ...grpc.CallOption) (Driver_GetPhotoClient, error)
 }
 
 type driverClient struct {
@@ -67,6 +694,15 @@ func NewDriverClient(cc *grpc.ClientConn) DriverClient {
 	return &driverClient{cc}
 }
 
+func (c *driverClient) Hello(ctx context.Context, in *HelloRequest, opts ...grpc.CallOption) (*HelloResponse, error) {
+	out := new(HelloResponse)
+	err := grpc.Invoke(ctx, "/steering.Driver/Hello", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *driverClient) Drive(ctx context.Context, opts ...grpc.CallOption) (Driver_DriveClient, error) {
 	stream, err := grpc.NewClientStream(ctx, &_Driver_serviceDesc.Streams[0], c.cc, "/steering.Driver/Drive", opts...)
 	if err != nil {
@@ -76,6 +712,126 @@ func (c *driverClient) Drive(ctx context.Context, opts ...grpc.CallOption) (Driv
 	return x, nil
 }
 
+func (c *driverClient) GetStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	err := grpc.Invoke(ctx, "/steering.Driver/GetStatus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) SetMode(ctx context.Context, in *ModeRequest, opts ...grpc.CallOption) (*ModeResponse, error) {
+	out := new(ModeResponse)
+	err := grpc.Invoke(ctx, "/steering.Driver/SetMode", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) RunMission(ctx context.Context, in *Mission, opts ...grpc.CallOption) (*ModeResponse, error) {
+	out := new(ModeResponse)
+	err := grpc.Invoke(ctx, "/steering.Driver/RunMission", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) ControlMission(ctx context.Context, in *MissionControl, opts ...grpc.CallOption) (*ModeResponse, error) {
+	out := new(ModeResponse)
+	err := grpc.Invoke(ctx, "/steering.Driver/ControlMission", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) RunScript(ctx context.Context, in *Script, opts ...grpc.CallOption) (*ModeResponse, error) {
+	out := new(ModeResponse)
+	err := grpc.Invoke(ctx, "/steering.Driver/RunScript", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) EmergencyStop(ctx context.Context, in *EmergencyStopRequest, opts ...grpc.CallOption) (*EmergencyStopState, error) {
+	out := new(EmergencyStopState)
+	err := grpc.Invoke(ctx, "/steering.Driver/EmergencyStop", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) ClearEmergencyStop(ctx context.Context, in *ClearEmergencyStopRequest, opts ...grpc.CallOption) (*EmergencyStopState, error) {
+	out := new(EmergencyStopState)
+	err := grpc.Invoke(ctx, "/steering.Driver/ClearEmergencyStop", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) SetProfile(ctx context.Context, in *ProfileRequest, opts ...grpc.CallOption) (*ProfileResponse, error) {
+	out := new(ProfileResponse)
+	err := grpc.Invoke(ctx, "/steering.Driver/SetProfile", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) GetImage(ctx context.Context, in *Image, opts ...grpc.CallOption) (Driver_GetImageClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Driver_serviceDesc.Streams[1], c.cc, "/steering.Driver/GetImage", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &driverGetImageClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (c *driverClient) TakePhoto(ctx context.Context, in *TakePhotoRequest, opts ...grpc.CallOption) (*PhotoInfo, error) {
+	out := new(PhotoInfo)
+	err := grpc.Invoke(ctx, "/steering.Driver/TakePhoto", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) ListPhotos(ctx context.Context, in *ListPhotosRequest, opts ...grpc.CallOption) (*PhotoList, error) {
+	out := new(PhotoList)
+	err := grpc.Invoke(ctx, "/steering.Driver/ListPhotos", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driverClient) GetPhoto(ctx context.Context, in *GetPhotoRequest, opts ...grpc.CallOption) (Driver_GetPhotoClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Driver_serviceDesc.Streams[2], c.cc, "/steering.Driver/GetPhoto", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &driverGetPhotoClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
 type Driver_DriveClient interface {
 	Send(*Direction) error
 	Recv() (*Telemetry, error)
@@ -98,21 +854,283 @@ func (x *driverDriveClient) Recv() (*Telemetry, error) {
 	return m, nil
 }
 
+type Driver_GetImageClient interface {
+	Recv() (*Frame, error)
+	grpc.ClientStream
+}
+
+type driverGetImageClient struct {
+	grpc.ClientStream
+}
+
+func (x *driverGetImageClient) Recv() (*Frame, error) {
+	m := new(Frame)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+type Driver_GetPhotoClient interface {
+	Recv() (*PhotoChunk, error)
+	grpc.ClientStream
+}
+
+type driverGetPhotoClient struct {
+	grpc.ClientStream
+}
+
+func (x *driverGetPhotoClient) Recv() (*PhotoChunk, error) {
+	m := new(PhotoChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for Driver service
 
 type DriverServer interface {
+	// Hello exchanges protocol version and capabilities, clients call it right
+	// after connecting. Bots older than it speak protocol version 0.
+	Hello(context.Context, *HelloRequest) (*HelloResponse, error)
 	// Drive is a client-to-server stream providing direction.
 	Drive(Driver_DriveServer) error
+	// GetStatus reports version, uptime, configuration and health of subsystems.
+	GetStatus(context.Context, *StatusRequest) (*Status, error)
+	// SetMode switches between manual driving and autonomous modes. Directions
+	// from clients are ignored while autonomous mode is on.
+	SetMode(context.Context, *ModeRequest) (*ModeResponse, error)
+	// RunMission starts executing mission in mission mode.
+	RunMission(context.Context, *Mission) (*ModeResponse, error)
+	// ControlMission pauses, resumes or aborts running mission.
+	ControlMission(context.Context, *MissionControl) (*ModeResponse, error)
+	// RunScript starts driving with behavior script in script mode.
+	RunScript(context.Context, *Script) (*ModeResponse, error)
+	// EmergencyStop stops motors and keeps them off, whatever drives the bot,
+	// until ClearEmergencyStop is called.
+	EmergencyStop(context.Context, *EmergencyStopRequest) (*EmergencyStopState, error)
+	ClearEmergencyStop(context.Context, *ClearEmergencyStopRequest) (*EmergencyStopState, error)
+	// SetProfile switches driving profile, which limits power, acceleration and
+	// stick sensitivity.
+	SetProfile(context.Context, *ProfileRequest) (*ProfileResponse, error)
+	// GetImage streams JPEG frames from the camera, a single one unless live
+	// video is requested.
+	GetImage(*Image, Driver_GetImageServer) error
+	// TakePhoto captures full resolution still and stores it on the bot.
+	TakePhoto(context.Context, *TakePhotoRequest) (*PhotoInfo, error)
+	// ListPhotos lists stored photos, the newest first.
+	ListPhotos(context.Context, *ListPhotosRequest) (*PhotoList, error)
+	// GetPhoto downloads stored photo in chunks, the first one has its info.
+	GetPhoto(*GetPhotoRequest, Driver_GetPhotoServer) error
 }
 
 func RegisterDriverServer(s *grpc.Server, srv DriverServer) {
 	s.RegisterService(&_Driver_serviceDesc, srv)
 }
 
+func _Driver_Hello_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HelloRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).Hello(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/Hello",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).Hello(ctx, req.(*HelloRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Driver_Drive_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(DriverServer).Drive(&driverDriveServer{stream})
 }
 
+func _Driver_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/GetStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).GetStatus(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_SetMode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ModeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).SetMode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/SetMode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).SetMode(ctx, req.(*ModeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_RunMission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Mission)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).RunMission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/RunMission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).RunMission(ctx, req.(*Mission))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_ControlMission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MissionControl)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).ControlMission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/ControlMission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).ControlMission(ctx, req.(*MissionControl))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_RunScript_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Script)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).RunScript(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/RunScript",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).RunScript(ctx, req.(*Script))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_EmergencyStop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EmergencyStopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).EmergencyStop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/EmergencyStop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).EmergencyStop(ctx, req.(*EmergencyStopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_ClearEmergencyStop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClearEmergencyStopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).ClearEmergencyStop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/ClearEmergencyStop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).ClearEmergencyStop(ctx, req.(*ClearEmergencyStopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_SetProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).SetProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/SetProfile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).SetProfile(ctx, req.(*ProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_TakePhoto_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TakePhotoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).TakePhoto(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/TakePhoto",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).TakePhoto(ctx, req.(*TakePhotoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Driver_ListPhotos_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPhotosRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriverServer).ListPhotos(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/steering.Driver/ListPhotos",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriverServer).ListPhotos(ctx, req.(*ListPhotosRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 type Driver_DriveServer interface {
 	Send(*Telemetry) error
 	Recv() (*Direction, error)
@@ -135,10 +1153,97 @@ func (x *driverDriveServer) Recv() (*Direction, error) {
 	return m, nil
 }
 
+func _Driver_GetImage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Image)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DriverServer).GetImage(m, &driverGetImageServer{stream})
+}
+
+type Driver_GetImageServer interface {
+	Send(*Frame) error
+	grpc.ServerStream
+}
+
+type driverGetImageServer struct {
+	grpc.ServerStream
+}
+
+func (x *driverGetImageServer) Send(m *Frame) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Driver_GetPhoto_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetPhotoRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DriverServer).GetPhoto(m, &driverGetPhotoServer{stream})
+}
+
+type Driver_GetPhotoServer interface {
+	Send(*PhotoChunk) error
+	grpc.ServerStream
+}
+
+type driverGetPhotoServer struct {
+	grpc.ServerStream
+}
+
+func (x *driverGetPhotoServer) Send(m *PhotoChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Driver_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "steering.Driver",
 	HandlerType: (*DriverServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Hello",
+			Handler:    _Driver_Hello_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Driver_GetStatus_Handler,
+		},
+		{
+			MethodName: "SetMode",
+			Handler:    _Driver_SetMode_Handler,
+		},
+		{
+			MethodName: "RunMission",
+			Handler:    _Driver_RunMission_Handler,
+		},
+		{
+			MethodName: "ControlMission",
+			Handler:    _Driver_ControlMission_Handler,
+		},
+		{
+			MethodName: "RunScript",
+			Handler:    _Driver_RunScript_Handler,
+		},
+		{
+			MethodName: "EmergencyStop",
+			Handler:    _Driver_EmergencyStop_Handler,
+		},
+		{
+			MethodName: "ClearEmergencyStop",
+			Handler:    _Driver_ClearEmergencyStop_Handler,
+		},
+		{
+			MethodName: "SetProfile",
+			Handler:    _Driver_SetProfile_Handler,
+		},
+		{
+			MethodName: "TakePhoto",
+			Handler:    _Driver_TakePhoto_Handler,
+		},
+		{
+			MethodName: "ListPhotos",
+			Handler:    _Driver_ListPhotos_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "Drive",
@@ -146,5 +1251,15 @@ var _Driver_serviceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "GetImage",
+			Handler:       _Driver_GetImage_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetPhoto",
+			Handler:       _Driver_GetPhoto_Handler,
+			ServerStreams: true,
+		},
 	},
 }
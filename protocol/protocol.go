@@ -0,0 +1,74 @@
+// Package protocol describes version and optional features of steering
+// protocol, exchanged by clients and bots with Hello RPC. Bots older than Hello
+// speak version 0, with Drive only.
+package protocol
+
+import (
+	"sort"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Version is the current protocol version. It's bumped when RPCs or messages
+// change in a way the other side has to know about.
+const Version = 1
+
+// MinVersion is the oldest client version bots still serve. Clients older
+// than Hello don't call it, so bots serve them as long as it's 0.
+const MinVersion = 0
+
+// Capabilities of bots, depending on their hardware and configuration.
+const (
+	EchoFront = "echo.front"
+	EchoRear  = "echo.rear"
+	EchoSide  = "echo.side"
+	IMU       = "imu"
+	Battery   = "battery"
+	// Wheel encoders, odometry is dead-reckoned from engine power without.
+	Encoders      = "encoders"
+	Camera        = "camera"
+	Photos        = "photos"
+	Gamepad       = "gamepad"
+	Profiles      = "profiles"
+	TankDrive     = "drive.tank" // Direction with left and right wheel powers.
+	EmergencyStop = "estop"
+
+	ModeWander     = "mode.wander"
+	ModeWallFollow = "mode.wall_follow"
+	ModeMission    = "mode.mission"
+	ModeScript     = "mode.script"
+)
+
+// Set is a set of capabilities.
+type Set map[string]bool
+
+// NewSet returns set of capabilities listed in Hello.
+func NewSet(caps []string) Set {
+	s := make(Set, len(caps))
+	for _, c := range caps {
+		s[c] = true
+	}
+	return s
+}
+
+// Has tells whether capability c is in the set.
+func (s Set) Has(c string) bool {
+	return s[c]
+}
+
+// List returns capabilities in the set, sorted.
+func (s Set) List() []string {
+	caps := make([]string, 0, len(s))
+	for c := range s {
+		caps = append(caps, c)
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+// Unsupported tells whether err means the other side doesn't know the RPC,
+// e.g. Hello called on a bot older than it.
+func Unsupported(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}
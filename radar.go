@@ -0,0 +1,208 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/mobile/exp/f32"
+	"golang.org/x/mobile/exp/sprite"
+	"golang.org/x/mobile/exp/sprite/clock"
+)
+
+// Radar around the bot, with a beam of each proximity sensor reaching as far
+// as the obstacle it sees.
+const (
+	radarSize  = 180 // Size in points, and of its texture in pixels.
+	radarBeam  = 30  // Beam width in degrees, about that of an ultrasonic sensor.
+	radarStale = 3 * time.Second
+)
+
+// level of proximity, also the color of its beam.
+type level int
+
+const (
+	levelNone level = iota // No data, or too old.
+	levelFar
+	levelMid
+	levelNear
+)
+
+var levelColors = []color.NRGBA{
+	levelNone: {0x60, 0x60, 0x60, 0xff},
+	levelFar:  {0x4c, 0xaf, 0x50, 0xff},
+	levelMid:  {0xff, 0x98, 0x00, 0xff},
+	levelNear: {0xf4, 0x43, 0x36, 0xff},
+}
+
+// reading is the latest distance from a proximity sensor.
+type reading struct {
+	angle float64 // Degrees clockwise from the front of the bot.
+	dist  int32   // In cm.
+	valid bool
+	at    time.Time // When it was measured, as far as the app can tell.
+}
+
+// blip is a reading as shown on radar.
+type blip struct {
+	angle float64
+	dist  int32
+	level level
+}
+
+type radar struct {
+	mu       sync.Mutex
+	readings []reading
+	shown    []blip    // Blips drawn on texture.
+	prox     proximity // Thresholds they were drawn with.
+}
+
+// SetRanges updates radar with readings from telemetry. Bots older than
+// ranges report front and rear distances only, 0 cm when measuring failed.
+func (a *App) SetRanges(t *pb.Telemetry) {
+	now := time.Now()
+	var rs []reading
+	for _, r := range t.Ranges {
+		rs = append(rs, reading{
+			angle: float64(r.Angle),
+			dist:  r.Dist,
+			valid: r.Valid,
+			at:    now.Add(-time.Duration(r.Age) * time.Millisecond),
+		})
+	}
+	if len(t.Ranges) == 0 {
+		rs = []reading{
+			{angle: 0, dist: t.DistFront, valid: t.DistFront > 0, at: now},
+			{angle: 180, dist: t.DistRear, valid: t.DistRear > 0, at: now},
+		}
+	}
+	a.radar.mu.Lock()
+	a.radar.readings = rs
+	a.radar.mu.Unlock()
+}
+
+// blips returns readings with their levels at given time. Readings go stale
+// when the sensor stops measuring or telemetry stops coming.
+func (a *App) blips(now time.Time) []blip {
+	a.radar.mu.Lock()
+	defer a.radar.mu.Unlock()
+	p := a.settings.Proximity
+	bs := make([]blip, len(a.radar.readings))
+	for i, r := range a.radar.readings {
+		b := blip{angle: r.angle, dist: r.dist}
+		switch {
+		case !r.valid || now.Sub(r.at) > radarStale:
+			b.level, b.dist = levelNone, 0
+		case int(r.dist) < p.Near:
+			b.level = levelNear
+		case int(r.dist) < p.Far:
+			b.level = levelMid
+		default:
+			b.level = levelFar
+		}
+		bs[i] = b
+	}
+	return bs
+}
+
+// radarNodes adds radar centered on the bot, redrawn when blips change.
+func (a *App) radarNodes(eng sprite.Engine, newNode func(arrangerFunc)) {
+	m := image.NewRGBA(image.Rect(0, 0, radarSize, radarSize))
+	tex, err := eng.LoadTexture(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+		r := &a.radar
+		bs := a.blips(time.Now())
+		if !slices.Equal(bs, r.shown) || a.settings.Proximity != r.prox {
+			drawRadar(m, bs, 2*a.settings.Proximity.Far)
+			tex.Upload(m.Bounds(), m)
+			r.shown, r.prox = bs, a.settings.Proximity
+		}
+		eng.SetSubTex(n, sprite.SubTex{T: tex, R: m.Bounds()})
+		eng.SetTransform(n, f32.Affine{
+			{radarSize, 0, a.bot.x + botSize/2 - radarSize/2},
+			{0, radarSize, a.bot.y + botSize/2 - radarSize/2},
+		})
+	})
+}
+
+// drawRadar draws beams of blips around the bot, with distance in cm next to
+// the obstacle. The edge of radar is maxDist away from the bot, obstacles
+// further away fill the whole beam. Beams without data are hatched.
+func drawRadar(m *image.RGBA, bs []blip, maxDist int) {
+	const (
+		c     = radarSize / 2
+		inner = botSize / 2
+		outer = radarSize / 2
+	)
+	draw.Draw(m, m.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	for _, b := range bs {
+		r := float64(outer)
+		seen := b.level != levelNone && int(b.dist) < maxDist
+		if seen {
+			r = inner + (outer-inner)*float64(b.dist)/float64(maxDist)
+		}
+		col := levelColors[b.level]
+		faded := col
+		faded.A = 0x50
+		for y := 0; y < radarSize; y++ {
+			for x := 0; x < radarSize; x++ {
+				dx, dy := float64(x)+0.5-c, float64(y)+0.5-c
+				d := math.Hypot(dx, dy)
+				if d < inner || d > r {
+					continue
+				}
+				// Angle from the beam axis, between -180 and 180.
+				da := math.Mod(math.Atan2(dx, -dy)*180/math.Pi-b.angle+540, 360) - 180
+				if math.Abs(da) > radarBeam/2 {
+					continue
+				}
+				switch {
+				case b.level == levelNone:
+					if (x+y)%6 < 2 {
+						m.Set(x, y, col)
+					}
+				case seen && d > r-3:
+					m.Set(x, y, col)
+				default:
+					m.Set(x, y, faded)
+				}
+			}
+		}
+
+		label := "--"
+		lr := float64(inner+outer) / 2
+		if b.level != levelNone {
+			label = strconv.Itoa(int(b.dist))
+			lr = math.Min(r+10, outer-7)
+		}
+		rad := b.angle * math.Pi / 180
+		drawLabel(m, label, c+lr*math.Sin(rad), c-lr*math.Cos(rad))
+	}
+}
+
+// drawLabel draws white text centered at point, shadowed so it's readable
+// over video too.
+func drawLabel(m *image.RGBA, s string, x, y float64) {
+	face := basicfont.Face7x13
+	d := font.Drawer{Dst: m, Face: face}
+	w := d.MeasureString(s).Round()
+	tx := min(max(int(x)-w/2, 0), radarSize-w-1)
+	ty := min(max(int(y)+face.Ascent/2, face.Ascent), radarSize-face.Descent-1)
+	d.Src, d.Dot = image.Black, fixed.P(tx+1, ty+1)
+	d.DrawString(s)
+	d.Src, d.Dot = image.White, fixed.P(tx, ty)
+	d.DrawString(s)
+}
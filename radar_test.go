@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlips(t *testing.T) {
+	now := time.Now()
+	a := &App{}
+	a.settings.Proximity = proximity{Near: 50, Far: 100}
+	for _, tc := range []struct {
+		name  string
+		r     reading
+		level level
+		dist  int32
+	}{
+		{"near", reading{dist: 20, valid: true, at: now}, levelNear, 20},
+		{"at near", reading{dist: 50, valid: true, at: now}, levelMid, 50},
+		{"mid", reading{dist: 70, valid: true, at: now}, levelMid, 70},
+		{"at far", reading{dist: 100, valid: true, at: now}, levelFar, 100},
+		{"far", reading{dist: 300, valid: true, at: now}, levelFar, 300},
+		{"invalid", reading{dist: 20, at: now}, levelNone, 0},
+		{"recent", reading{dist: 20, valid: true, at: now.Add(-radarStale)}, levelNear, 20},
+		{"stale", reading{dist: 20, valid: true, at: now.Add(-radarStale - time.Millisecond)}, levelNone, 0},
+		{"stale invalid", reading{valid: false, at: now.Add(-time.Minute)}, levelNone, 0},
+	} {
+		tc.r.angle = 90
+		a.radar.readings = []reading{tc.r}
+		bs := a.blips(now)
+		if len(bs) != 1 {
+			t.Fatalf("%s: %d blips, want 1", tc.name, len(bs))
+		}
+		if want := (blip{angle: 90, dist: tc.dist, level: tc.level}); bs[0] != want {
+			t.Errorf("%s: blip %+v, want %+v", tc.name, bs[0], want)
+		}
+	}
+
+	// Closest obstacle skips stale and invalid readings.
+	a.radar.readings = []reading{
+		{angle: 0, dist: 30, valid: true, at: now},
+		{angle: 180, dist: 30, valid: true, at: now.Add(-time.Minute)},
+	}
+	if d, ok := a.closest(now); !ok || d != 30 {
+		t.Errorf("closest = %d, %v, want 30 from the front", d, ok)
+	}
+	a.radar.readings[0].valid = false
+	if d, ok := a.closest(now); ok {
+		t.Errorf("closest = %d with no recent reading, want none", d)
+	}
+}
@@ -0,0 +1,301 @@
+// Package sessionlog reads and writes recorded driving sessions. A session log
+// is a sequence of steering.Record messages, each prefixed with its length as
+// uvarint.
+package sessionlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Ext is the file extension of session logs.
+const Ext = ".bblog"
+
+// maxRecordSize guards against reading garbage as huge record length.
+const maxRecordSize = 1 << 20
+
+// Writer writes records to timestamped files in a directory, starting a new
+// file when current one grows over MaxSize and removing the oldest ones above
+// MaxFiles. Records of concurrent driving sessions go to separate files, see
+// NewSession. It's safe for concurrent use.
+type Writer struct {
+	Dir      string
+	MaxSize  int64 // Bytes per file, 0 means no limit.
+	MaxFiles int   // Files kept in Dir, 0 means no limit.
+
+	mu   sync.Mutex
+	seq  int
+	open map[string]*Session // Files being written, never pruned.
+	def  *Session            // Used by Write.
+}
+
+// NewWriter creates Dir if needed and returns a Writer for it. Files are
+// opened lazily on first record.
+func NewWriter(dir string, maxSize int64, maxFiles int) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("can't create session log dir: %v", err)
+	}
+	return &Writer{Dir: dir, MaxSize: maxSize, MaxFiles: maxFiles, open: make(map[string]*Session)}, nil
+}
+
+// Write appends a record to the default session, stamping it with current
+// time if not set.
+func (w *Writer) Write(r *pb.Record) error {
+	w.mu.Lock()
+	if w.def == nil {
+		w.def = w.NewSession()
+	}
+	s := w.def
+	w.mu.Unlock()
+	return s.Write(r)
+}
+
+// NewSession returns a session writing its records to its own files, starting
+// with the first record.
+func (w *Writer) NewSession() *Session {
+	return &Session{w: w}
+}
+
+// Flush writes buffered records of all sessions to disk.
+func (w *Writer) Flush() error {
+	var err error
+	for _, s := range w.sessions() {
+		if ferr := s.Flush(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// Close flushes and closes files of all sessions. Records written later go to
+// new files.
+func (w *Writer) Close() error {
+	var err error
+	for _, s := range w.sessions() {
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// sessions returns sessions with open files.
+func (w *Writer) sessions() []*Session {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var ss []*Session
+	for _, s := range w.open {
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// create opens a new file of session s, unique even when rotating quickly,
+// and prunes old ones.
+func (w *Writer) create(s *Session) (*os.File, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// Sequence number keeps names unique and sorted when rotating quickly.
+	w.seq++
+	name := filepath.Join(w.Dir, fmt.Sprintf("session-%s-%04d%s", time.Now().Format("20060102-150405.000"), w.seq, Ext))
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("can't create session log: %v", err)
+	}
+	w.open[name] = s
+	return f, w.prune()
+}
+
+func (w *Writer) release(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.open, name)
+}
+
+// Remove oldest files above MaxFiles, skipping those still written. Names sort
+// by time.
+func (w *Writer) prune() error {
+	if w.MaxFiles <= 0 {
+		return nil
+	}
+	files, err := List(w.Dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(files) && len(files) > w.MaxFiles; {
+		if w.open[files[i]] != nil {
+			i++
+			continue
+		}
+		if err := os.Remove(files[i]); err != nil {
+			return fmt.Errorf("can't remove old session log: %v", err)
+		}
+		files = append(files[:i], files[i+1:]...)
+	}
+	return nil
+}
+
+// Session writes records of a single driving session, e.g. of one client, to
+// files of its Writer not shared with other sessions. It's safe for
+// concurrent use.
+type Session struct {
+	w *Writer
+
+	mu   sync.Mutex
+	f    *os.File
+	bw   *bufio.Writer
+	size int64
+}
+
+// Write appends a record, stamping it with current time if not set.
+func (s *Session) Write(r *pb.Record) error {
+	if r.Timestamp == 0 {
+		r.Timestamp = time.Now().UnixNano()
+	}
+	b, err := proto.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("can't marshal record: %v", err)
+	}
+	var n [binary.MaxVarintLen64]byte
+	l := binary.PutUvarint(n[:], uint64(len(b)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil || (s.w.MaxSize > 0 && s.size+int64(l+len(b)) > s.w.MaxSize) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.bw.Write(n[:l]); err != nil {
+		return fmt.Errorf("can't write record: %v", err)
+	}
+	if _, err := s.bw.Write(b); err != nil {
+		return fmt.Errorf("can't write record: %v", err)
+	}
+	s.size += int64(l + len(b))
+	return nil
+}
+
+// Flush writes buffered records to disk.
+func (s *Session) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bw == nil {
+		return nil
+	}
+	return s.bw.Flush()
+}
+
+// Close flushes and closes current file.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFile()
+}
+
+func (s *Session) closeFile() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.bw.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.w.release(s.f.Name())
+	s.f = nil
+	s.bw = nil
+	return err
+}
+
+func (s *Session) rotate() error {
+	if err := s.closeFile(); err != nil {
+		return fmt.Errorf("can't close session log: %v", err)
+	}
+	f, err := s.w.create(s)
+	if f == nil {
+		return err
+	}
+	s.f = f
+	s.bw = bufio.NewWriter(f)
+	s.size = 0
+	return err
+}
+
+// List returns session log files in dir, oldest first.
+func List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't list session logs: %v", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), Ext) {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Reader reads records written by Writer.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns next record or io.EOF at the end of log.
+func (r *Reader) Read() (*pb.Record, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxRecordSize {
+		return nil, fmt.Errorf("record size %d too big, corrupted log?", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, fmt.Errorf("can't read record: %v", err)
+	}
+	rec := new(pb.Record)
+	if err := proto.Unmarshal(b, rec); err != nil {
+		return nil, fmt.Errorf("can't unmarshal record: %v", err)
+	}
+	return rec, nil
+}
+
+// ReadFile reads all records from a session log file.
+func ReadFile(name string) ([]*pb.Record, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []*pb.Record
+	r := NewReader(f)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
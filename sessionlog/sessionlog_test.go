@@ -0,0 +1,219 @@
+package sessionlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func testRecords() []*pb.Record {
+	return []*pb.Record{
+		{Timestamp: 1, Direction: &pb.Direction{Dx: -20, Dy: 80, Seq: 1}, Cmd: "fwdLeft", LeftPower: 40, RightPower: 80, LeftForward: true, RightForward: true},
+		{Timestamp: 2, Telemetry: &pb.Telemetry{Speed: 100, DistFront: 42, Ranges: []*pb.Range{{Name: "front", Dist: 42, Valid: true, Age: 5}}}},
+		{Timestamp: 3, Direction: &pb.Direction{Tank: true, Left: 50, Right: -50, Seq: 2}, Cmd: "tank"},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testRecords()
+	for _, r := range want[:2] {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := w.NewSession()
+	if err := s.Write(want[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("List(%q) = %v, want 2 files", dir, files)
+	}
+	var got []*pb.Record
+	for _, f := range files {
+		recs, err := ReadFile(f)
+		if err != nil {
+			t.Fatalf("ReadFile(%q): %v", f, err)
+		}
+		got = append(got, recs...)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("read %d records, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !proto.Equal(got[i], want[i]) {
+			t.Errorf("record %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWriteStampsTime(t *testing.T) {
+	w, err := NewWriter(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r := &pb.Record{Telemetry: &pb.Telemetry{}}
+	if err := w.Write(r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Timestamp == 0 {
+		t.Error("Write left record without timestamp")
+	}
+}
+
+func TestRotateAndPrune(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		r := &pb.Record{Timestamp: int64(i + 1), Telemetry: &pb.Telemetry{Cmd: "forward", DistFront: int32(i)}}
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("kept %d files, want 2", len(files))
+	}
+	// The newest records survive pruning, in order.
+	last := int64(0)
+	for _, f := range files {
+		recs, err := ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range recs {
+			if r.Timestamp <= last {
+				t.Errorf("record %d after %d", r.Timestamp, last)
+			}
+			last = r.Timestamp
+		}
+	}
+	if last != 20 {
+		t.Errorf("last record %d, want 20", last)
+	}
+}
+
+func TestReadCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	var n [binary.MaxVarintLen64]byte
+	buf.Write(n[:binary.PutUvarint(n[:], maxRecordSize+1)])
+	if _, err := NewReader(&buf).Read(); err == nil {
+		t.Error("Read of huge record length succeeded")
+	}
+
+	buf.Reset()
+	buf.Write(n[:binary.PutUvarint(n[:], 10)])
+	buf.WriteString("short")
+	if _, err := NewReader(&buf).Read(); err == nil {
+		t.Error("Read of truncated record succeeded")
+	}
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two clients driving at once, one rotating its file mid-way.
+	a, b := w.NewSession(), w.NewSession()
+	for i := 1; i <= 20; i++ {
+		if err := a.Write(&pb.Record{Timestamp: int64(i), Cmd: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		if i%5 == 0 {
+			if err := b.Write(&pb.Record{Timestamp: int64(i), Cmd: "b", Telemetry: &pb.Telemetry{Cmd: "forward"}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := map[string]int{}
+	for _, f := range files {
+		recs, err := ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range recs {
+			if r.Cmd != recs[0].Cmd {
+				t.Errorf("%s mixes records of sessions %q and %q", f, recs[0].Cmd, r.Cmd)
+			}
+			count[r.Cmd]++
+		}
+	}
+	if count["a"] != 20 || count["b"] != 4 {
+		t.Errorf("read %d records of a and %d of b, want 20 and 4", count["a"], count["b"])
+	}
+}
+
+func TestPruneKeepsOpenSessions(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, 64, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Long session opened first, its file is the oldest.
+	long := w.NewSession()
+	if err := long.Write(&pb.Record{Timestamp: 1, Cmd: "long"}); err != nil {
+		t.Fatal(err)
+	}
+	s := w.NewSession()
+	for i := 0; i < 20; i++ {
+		if err := s.Write(&pb.Record{Timestamp: int64(i + 2), Telemetry: &pb.Telemetry{Cmd: "forward", DistFront: int32(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := long.Write(&pb.Record{Timestamp: 30, Cmd: "long"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].Cmd != "long" {
+		t.Errorf("oldest file has %v, want both records of still open session", recs)
+	}
+}
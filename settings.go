@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mobile/event/size"
+	"golang.org/x/mobile/event/touch"
+	"golang.org/x/mobile/exp/f32"
+	"golang.org/x/mobile/exp/sprite"
+	"golang.org/x/mobile/exp/sprite/clock"
+)
+
+// curve maps stick deflection to power, both between -1 and 1. Curves other
+// than linear give finer control at low speed.
+type curve int
+
+const (
+	curveLinear curve = iota
+	curveExpo
+	curveCubic
+)
+
+// Curves in order of their previews in settings.
+var curves = []curve{curveLinear, curveExpo, curveCubic}
+
+var curveNames = []string{
+	curveLinear: "linear",
+	curveExpo:   "expo",
+	curveCubic:  "cubic",
+}
+
+// Steepness of exponential curve, higher is gentler near center.
+const expoK = 3
+
+func (c curve) apply(v float64) float64 {
+	a := math.Abs(v)
+	switch c {
+	case curveExpo:
+		a = (math.Exp(expoK*a) - 1) / (math.Exp(expoK) - 1)
+	case curveCubic:
+		a = a * a * a
+	}
+	return math.Copysign(a, v)
+}
+
+func (c curve) MarshalText() ([]byte, error) {
+	return marshalName(curveNames, int(c))
+}
+
+func (c *curve) UnmarshalText(b []byte) error {
+	i, err := unmarshalName(curveNames, b)
+	*c = curve(i)
+	return err
+}
+
+// marshalName returns name of i-th value of enum with given names, which keeps
+// saved settings readable and valid when values are reordered.
+func marshalName(names []string, i int) ([]byte, error) {
+	if i < 0 || i >= len(names) {
+		return nil, fmt.Errorf("unknown value %d of %v", i, names)
+	}
+	return []byte(names[i]), nil
+}
+
+func unmarshalName(names []string, b []byte) (int, error) {
+	for i, n := range names {
+		if n == string(b) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown value %q, want one of %v", b, names)
+}
+
+// Dead zones to choose from, in percent of stick deflection.
+var deadZones = []int{0, 5, 10, 15}
+
+// proximity holds distances in cm below which obstacles are near and
+// midway, red and orange on radar.
+type proximity struct {
+	Near int `json:"near"`
+	Far  int `json:"far"`
+}
+
+// Proximity thresholds to choose from, further ones for faster driving.
+var proximities = []proximity{{30, 60}, {50, 100}, {80, 160}, {120, 240}}
+
+// settings are driver's preferences, kept between launches.
+type settings struct {
+	Curve     curve       `json:"curve"`
+	DeadZone  int         `json:"deadZone"` // Percent of stick deflection treated as centered.
+	Layout    layout      `json:"layout"`
+	Proximity proximity   `json:"proximity"`
+	Feedback  sensitivity `json:"feedback"` // Beeps and haptic pulses about obstacles.
+
+	// Neutral position of tilt driving in radians, see tilt.
+	TiltPitch      float64 `json:"tiltPitch"`
+	TiltRoll       float64 `json:"tiltRoll"`
+	TiltCalibrated bool    `json:"tiltCalibrated"`
+}
+
+// shape returns power for stick deflection v. Deflection past dead zone is
+// stretched to the whole range, so full power is still reachable.
+func (s settings) shape(v float64) float64 {
+	dz := float64(s.DeadZone) / 100
+	a := math.Abs(v)
+	if a <= dz {
+		return 0
+	}
+	a = math.Min((a-dz)/(1-dz), 1)
+	return s.Curve.apply(math.Copysign(a, v))
+}
+
+func settingsPath() string {
+	return filepath.Join(appDir(), "settings.json")
+}
+
+func defaultSettings() settings {
+	return settings{Proximity: proximities[1], Feedback: sensitivityMedium}
+}
+
+// loadSettings reads settings saved before, or returns defaults. Settings
+// missing in older files keep their defaults.
+func loadSettings() settings {
+	s := defaultSettings()
+	b, err := os.ReadFile(settingsPath())
+	if os.IsNotExist(err) {
+		return s
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &s)
+	}
+	if err != nil {
+		log.Printf("Can't load settings: %v", err)
+		return defaultSettings()
+	}
+	return s
+}
+
+func saveSettings(s settings) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		log.Printf("Can't save settings: %v", err)
+		return
+	}
+	if err := os.MkdirAll(appDir(), 0755); err != nil {
+		log.Printf("Can't save settings: %v", err)
+		return
+	}
+	if err := os.WriteFile(settingsPath(), b, 0644); err != nil {
+		log.Printf("Can't save settings: %v", err)
+	}
+}
+
+// Layout of settings screen in points, curve previews in a row with dead zone,
+// proximity and feedback bars below and stick layout buttons in top left
+// corner.
+const (
+	previewPx     = 128 // Size of preview texture in pixels.
+	previewMargin = 20
+	previewFrame  = 3
+	barW          = 16
+	barH          = 40 // Height of the tallest bar.
+	barGap        = 6
+	barGroupGap   = 20 // Between groups of bars.
+)
+
+// Groups of bars below previews, from left.
+const (
+	barsDeadZone = iota
+	barsProximity
+	barsFeedback
+)
+
+var barCounts = []int{
+	barsDeadZone:  len(deadZones),
+	barsProximity: len(proximities),
+	barsFeedback:  len(sensitivities),
+}
+
+// previewRect returns top left corner and size of preview of i-th curve.
+func (a *App) previewRect(i int) (x, y, sz float32) {
+	w, h := a.screen.w, a.screen.h
+	sz = float32(math.Min(float64(w-4*previewMargin)/3, float64(h/2)))
+	x = (w-3*sz-2*previewMargin)/2 + float32(i)*(sz+previewMargin)
+	y = (h - sz - previewMargin - barH) / 2
+	return x, y, sz
+}
+
+// barRect returns top left corner and height of i-th bar of group, taller
+// for larger values.
+func (a *App) barRect(group, i int) (x, y, h float32) {
+	_, py, sz := a.previewRect(0)
+	groupW := func(n int) float32 { return float32(n)*(barW+barGap) - barGap }
+	w := float32(len(barCounts)-1) * barGroupGap
+	for g, n := range barCounts {
+		w += groupW(n)
+		if g < group {
+			x += groupW(n) + barGroupGap
+		}
+	}
+	x += (a.screen.w-w)/2 + float32(i)*(barW+barGap)
+	h = barH * float32(i+1) / float32(barCounts[group])
+	return x, py + sz + previewMargin + barH - h, h
+}
+
+// onBar tells whether point is on i-th bar of group. Short bars are easier to
+// hit with the whole row height.
+func (a *App) onBar(group, i int, xp, yp float32) bool {
+	x, y, h := a.barRect(group, i)
+	return xp >= x && xp < x+barW && yp >= y+h-barH && yp < y+h
+}
+
+// TouchSettings handles touches of settings button and the whole screen while
+// settings are open. It returns false for touches it leaves to others.
+func (a *App) TouchSettings(sz size.Event, e touch.Event) bool {
+	ui := &a.settingsUI
+	if ui.touched && e.Sequence == ui.touch {
+		if e.Type == touch.TypeEnd {
+			ui.touched = false
+		}
+		return true
+	}
+	if e.Type != touch.TypeBegin || sz.PixelsPerPt == 0 {
+		return ui.open
+	}
+	xp, yp := e.X/sz.PixelsPerPt, e.Y/sz.PixelsPerPt
+	if !ui.open {
+		a.gallery.mu.Lock()
+		galleryOpen := a.gallery.open
+		a.gallery.mu.Unlock()
+		if galleryOpen || !a.onIcon(iconSettings, xp, yp) {
+			return false
+		}
+		a.ResetStick(sz)
+		ui.open = true
+	} else {
+		a.touchSettingsAt(xp, yp)
+	}
+	ui.touch = e.Sequence
+	ui.touched = true
+	return true
+}
+
+// touchSettingsAt selects setting under point, or closes settings.
+func (a *App) touchSettingsAt(xp, yp float32) {
+	if a.onIcon(iconClose, xp, yp) {
+		a.settingsUI.open = false
+		return
+	}
+	old := a.settings
+	for _, l := range layouts {
+		if a.onIcon(layoutIcons[l], xp, yp) {
+			a.settings.Layout = l
+		}
+	}
+	for i, c := range curves {
+		x, y, sz := a.previewRect(i)
+		if xp >= x && xp < x+sz && yp >= y && yp < y+sz {
+			a.settings.Curve = c
+		}
+	}
+	for i, dz := range deadZones {
+		if a.onBar(barsDeadZone, i, xp, yp) {
+			a.settings.DeadZone = dz
+		}
+	}
+	for i, p := range proximities {
+		if a.onBar(barsProximity, i, xp, yp) {
+			a.settings.Proximity = p
+		}
+	}
+	for i, s := range sensitivities {
+		if a.onBar(barsFeedback, i, xp, yp) {
+			a.settings.Feedback = s
+		}
+	}
+	if a.settings != old {
+		a.settingsUI.dirty = true
+		a.UpdateSensors()
+		go saveSettings(a.settings)
+	}
+}
+
+// settingsNodes adds settings button and settings screen on top of the scene.
+func (a *App) settingsNodes(eng sprite.Engine, newNode func(arrangerFunc), icons, cols []sprite.SubTex) {
+	ui := &a.settingsUI
+	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+		a.gallery.mu.Lock()
+		galleryOpen := a.gallery.open
+		a.gallery.mu.Unlock()
+		if ui.open || galleryOpen {
+			eng.SetSubTex(n, sprite.SubTex{})
+			return
+		}
+		x, y := a.iconPos(iconSettings)
+		eng.SetSubTex(n, icons[iconSettings])
+		eng.SetTransform(n, f32.Affine{
+			{iconSize, 0, x},
+			{0, iconSize, y},
+		})
+	})
+
+	// Background hiding controls.
+	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+		if !ui.open {
+			eng.SetSubTex(n, sprite.SubTex{})
+			return
+		}
+		eng.SetSubTex(n, cols[colBlack])
+		eng.SetTransform(n, f32.Affine{
+			{a.screen.w, 0, 0},
+			{0, a.screen.h, 0},
+		})
+	})
+
+	// Previews of curves with current dead zone, the selected one framed.
+	m := image.NewRGBA(image.Rect(0, 0, previewPx*len(curves), previewPx))
+	tex, err := eng.LoadTexture(m)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for i, c := range curves {
+		newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+			if !ui.open {
+				eng.SetSubTex(n, sprite.SubTex{})
+				return
+			}
+			col := colGrey
+			if a.settings.Curve == c {
+				col = colGreen
+			}
+			x, y, sz := a.previewRect(i)
+			eng.SetSubTex(n, cols[col])
+			eng.SetTransform(n, f32.Affine{
+				{sz + 2*previewFrame, 0, x - previewFrame},
+				{0, sz + 2*previewFrame, y - previewFrame},
+			})
+		})
+		newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+			if !ui.open {
+				eng.SetSubTex(n, sprite.SubTex{})
+				return
+			}
+			if ui.dirty {
+				drawPreviews(m, a.settings)
+				tex.Upload(m.Bounds(), m)
+				ui.dirty = false
+			}
+			x, y, sz := a.previewRect(i)
+			eng.SetSubTex(n, sprite.SubTex{T: tex, R: image.Rect(i*previewPx, 0, (i+1)*previewPx, previewPx)})
+			eng.SetTransform(n, f32.Affine{
+				{sz, 0, x},
+				{0, sz, y},
+			})
+		})
+	}
+
+	// Bars of group lit in given color up to the current value.
+	barNodes := func(group, litCol int, lit func(i int) bool) {
+		for i := range barCounts[group] {
+			newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+				if !ui.open {
+					eng.SetSubTex(n, sprite.SubTex{})
+					return
+				}
+				col := colGrey
+				if lit(i) {
+					col = litCol
+				}
+				x, y, h := a.barRect(group, i)
+				eng.SetSubTex(n, cols[col])
+				eng.SetTransform(n, f32.Affine{
+					{barW, 0, x},
+					{0, h, y},
+				})
+			})
+		}
+	}
+	barNodes(barsDeadZone, colGreen, func(i int) bool { return deadZones[i] <= a.settings.DeadZone })
+	// Proximity in color of midway obstacles.
+	barNodes(barsProximity, colOrange, func(i int) bool { return proximities[i].Far <= a.settings.Proximity.Far })
+	barNodes(barsFeedback, colGreen, func(i int) bool { return sensitivities[i] <= a.settings.Feedback })
+
+	// Stick layout buttons, the selected one framed.
+	for _, l := range layouts {
+		newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+			if !ui.open {
+				eng.SetSubTex(n, sprite.SubTex{})
+				return
+			}
+			col := colGrey
+			if a.settings.Layout == l {
+				col = colGreen
+			}
+			x, y := a.iconPos(layoutIcons[l])
+			eng.SetSubTex(n, cols[col])
+			eng.SetTransform(n, f32.Affine{
+				{iconSize + 2*previewFrame, 0, x - previewFrame},
+				{0, iconSize + 2*previewFrame, y - previewFrame},
+			})
+		})
+		newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+			if !ui.open {
+				eng.SetSubTex(n, sprite.SubTex{})
+				return
+			}
+			x, y := a.iconPos(layoutIcons[l])
+			eng.SetSubTex(n, icons[layoutIcons[l]])
+			eng.SetTransform(n, f32.Affine{
+				{iconSize, 0, x},
+				{0, iconSize, y},
+			})
+		})
+	}
+
+	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+		if !ui.open {
+			eng.SetSubTex(n, sprite.SubTex{})
+			return
+		}
+		x, y := a.iconPos(iconClose)
+		eng.SetSubTex(n, icons[iconClose])
+		eng.SetTransform(n, f32.Affine{
+			{iconSize, 0, x},
+			{0, iconSize, y},
+		})
+	})
+}
+
+// drawPreviews plots each curve shaped by settings, with stick deflection on
+// x axis and power on y axis.
+func drawPreviews(m *image.RGBA, s settings) {
+	bg := color.RGBA{0x20, 0x20, 0x20, 0xff}
+	axis := color.RGBA{0x60, 0x60, 0x60, 0xff}
+	line := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	const mid = previewPx / 2
+	for i, c := range curves {
+		x0 := i * previewPx
+		draw.Draw(m, image.Rect(x0, 0, x0+previewPx, previewPx), &image.Uniform{bg}, image.Point{}, draw.Src)
+		for p := 0; p < previewPx; p++ {
+			m.SetRGBA(x0+p, mid, axis)
+			m.SetRGBA(x0+mid, p, axis)
+		}
+		cs := s
+		cs.Curve = c
+		prev := -1
+		for px := 0; px < previewPx; px++ {
+			v := 2*(float64(px)+0.5)/previewPx - 1
+			py := int((1 - cs.shape(v)) / 2 * (previewPx - 1))
+			if prev < 0 {
+				prev = py
+			}
+			// Join with previous column, steep parts would be dotted otherwise.
+			for y := min(prev, py) - 1; y <= max(prev, py)+1; y++ {
+				if y >= 0 && y < previewPx {
+					m.SetRGBA(x0+px, y, line)
+				}
+			}
+			prev = py
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestShape(t *testing.T) {
+	for _, tc := range []struct {
+		dz   int
+		v    float64
+		want float64
+	}{
+		{0, 0, 0},
+		{0, 0.5, 0.5},
+		{0, 1, 1},
+		{0, -1, -1},
+		{10, 0, 0},
+		{10, 0.1, 0},
+		{10, -0.1, 0},
+		{10, 0.55, 0.5},
+		{10, -0.55, -0.5},
+		{10, 1, 1},
+		{10, -1, -1},
+		{10, 1.5, 1}, // Past full deflection.
+		{10, -1.5, -1},
+		{15, 0.15, 0},
+		{15, 1, 1},
+	} {
+		s := settings{DeadZone: tc.dz}
+		if got := s.shape(tc.v); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("shape(%v) with dead zone %d = %v, want %v", tc.v, tc.dz, got, tc.want)
+		}
+	}
+	// Full power is reachable with any curve and dead zone.
+	for _, c := range curves {
+		for _, dz := range deadZones {
+			s := settings{Curve: c, DeadZone: dz}
+			if s.shape(1) != 1 || s.shape(-1) != -1 {
+				t.Errorf("%s curve with dead zone %d: full deflection gives %v and %v, want 1 and -1", curveNames[c], dz, s.shape(1), s.shape(-1))
+			}
+		}
+	}
+}
+
+func TestCurveApply(t *testing.T) {
+	for _, c := range curves {
+		name := curveNames[c]
+		for v, want := range map[float64]float64{0: 0, 1: 1, -1: -1} {
+			if got := c.apply(v); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: apply(%v) = %v, want %v", name, v, got, want)
+			}
+		}
+		prev := c.apply(-1)
+		for i := -99; i <= 100; i++ {
+			v := float64(i) / 100
+			got := c.apply(v)
+			if got < prev {
+				t.Errorf("%s: apply(%v) = %v, less than %v just before", name, v, got, prev)
+			}
+			if got != -c.apply(-v) {
+				t.Errorf("%s: apply(%v) = %v isn't symmetric to %v", name, v, got, c.apply(-v))
+			}
+			// Finer control at low speed.
+			if c != curveLinear && v > 0 && v < 1 && got >= v {
+				t.Errorf("%s: apply(%v) = %v, want below linear", name, v, got)
+			}
+			prev = got
+		}
+	}
+}
+
+func TestMarshalName(t *testing.T) {
+	names := []string{"linear", "expo", "cubic"}
+	for _, tc := range []struct {
+		i  int
+		ok bool
+	}{
+		{0, true},
+		{2, true},
+		{-1, false},
+		{3, false},
+	} {
+		b, err := marshalName(names, tc.i)
+		if (err == nil) != tc.ok {
+			t.Errorf("marshalName(%d) = %q, %v, want ok %v", tc.i, b, err, tc.ok)
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+		if i, err := unmarshalName(names, b); err != nil || i != tc.i {
+			t.Errorf("unmarshalName(%q) = %d, %v, want %d", b, i, err, tc.i)
+		}
+	}
+	for _, b := range []string{"", "quartic", "Linear", "0"} {
+		if i, err := unmarshalName(names, []byte(b)); err == nil {
+			t.Errorf("unmarshalName(%q) = %d, want error", b, i)
+		}
+	}
+}
+
+func TestSettingsJSON(t *testing.T) {
+	custom := settings{
+		Curve:          curveCubic,
+		DeadZone:       10,
+		Layout:         layoutTank,
+		Proximity:      proximities[3],
+		Feedback:       sensitivityHigh,
+		TiltPitch:      0.25,
+		TiltRoll:       -0.5,
+		TiltCalibrated: true,
+	}
+	for _, s := range []settings{defaultSettings(), custom} {
+		b, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got settings
+		if err := json.Unmarshal(b, &got); err != nil || got != s {
+			t.Errorf("settings %+v read back from %s as %+v, %v", s, b, got, err)
+		}
+	}
+	b, err := json.Marshal(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enums are saved by name.
+	for _, want := range []string{`"curve":"cubic"`, `"layout":"tank"`, `"feedback":"high"`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("saved settings %s, want %s in them", b, want)
+		}
+	}
+
+	for _, tc := range []struct {
+		name, json string
+		ok         bool
+	}{
+		{"older file", `{"curve":"expo"}`, true},
+		{"unknown curve", `{"curve":"quartic"}`, false},
+		{"unknown layout", `{"layout":"joystick"}`, false},
+		{"unknown feedback", `{"feedback":"loud"}`, false},
+		{"curve by number", `{"curve":1}`, false},
+	} {
+		s := defaultSettings()
+		err := json.Unmarshal([]byte(tc.json), &s)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: error %v, want ok %v", tc.name, err, tc.ok)
+		}
+		if tc.ok && (s.Curve != curveExpo || s.Proximity != defaultSettings().Proximity || s.Feedback != sensitivityMedium) {
+			t.Errorf("%s: read %+v, want expo curve and other defaults", tc.name, s)
+		}
+	}
+}
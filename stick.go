@@ -0,0 +1,258 @@
+package main
+
+import (
+	"math"
+
+	pb "github.com/pawelkowalak/berrybot/proto"
+	"github.com/pawelkowalak/berrybot/protocol"
+
+	"golang.org/x/mobile/event/size"
+	"golang.org/x/mobile/event/touch"
+	"golang.org/x/mobile/exp/f32"
+	"golang.org/x/mobile/exp/sprite"
+	"golang.org/x/mobile/exp/sprite/clock"
+)
+
+// layout of on-screen sticks.
+type layout int
+
+const (
+	layoutFixed    layout = iota // One stick at bottom center.
+	layoutFloating               // One stick centered wherever thumb lands.
+	layoutTank                   // Stick for each wheel in its half of screen, moving only up and down.
+	layoutTilt                   // Tilting the phone while holding button in place of stick.
+)
+
+// Layouts in order of their buttons in settings.
+var layouts = []layout{layoutFixed, layoutFloating, layoutTank, layoutTilt}
+
+var layoutNames = []string{
+	layoutFixed:    "fixed",
+	layoutFloating: "floating",
+	layoutTank:     "tank",
+	layoutTilt:     "tilt",
+}
+
+var layoutIcons = []int{
+	layoutFixed:    iconLayoutFixed,
+	layoutFloating: iconLayoutFloating,
+	layoutTank:     iconLayoutTank,
+	layoutTilt:     iconLayoutTilt,
+}
+
+func (l layout) MarshalText() ([]byte, error) {
+	return marshalName(layoutNames, int(l))
+}
+
+func (l *layout) UnmarshalText(b []byte) error {
+	i, err := unmarshalName(layoutNames, b)
+	*l = layout(i)
+	return err
+}
+
+// joystick is an on-screen stick following a single touch. Untouched stick
+// rests at home of its layout.
+type joystick struct {
+	x, y    float32 // Center in points, while touched.
+	dx, dy  float32 // Stick deflection in points, within ctrlRadius.
+	touch   touch.Sequence
+	touched bool
+}
+
+// set moves stick towards point, as far as ctrlRadius.
+func (j *joystick) set(xp, yp float32, vertical bool) {
+	dx, dy := xp-j.x, yp-j.y
+	if vertical {
+		dx = 0
+	}
+	if r := float32(math.Hypot(float64(dx), float64(dy))); r > ctrlRadius {
+		dx, dy = dx*ctrlRadius/r, dy*ctrlRadius/r
+	}
+	j.dx, j.dy = dx, dy
+}
+
+// deflection returns stick position between -1 and 1, with y pointing up.
+func (j *joystick) deflection() (x, y float64) {
+	return float64(j.dx / ctrlRadius), float64(-j.dy / ctrlRadius)
+}
+
+// layout returns layout in use. Bots not driving tank-style and phones
+// without accelerometer get floating stick instead.
+func (a *App) layout() layout {
+	switch {
+	case a.settings.Layout == layoutTank && !a.caps.Has(protocol.TankDrive):
+		return layoutFloating
+	case a.settings.Layout == layoutTilt && a.tilt.unavailable:
+		return layoutFloating
+	}
+	return a.settings.Layout
+}
+
+// stickHome returns center of i-th stick at rest.
+func (a *App) stickHome(i int) (x, y float32) {
+	w, h := a.screen.w, a.screen.h
+	if a.layout() != layoutTank {
+		return w / 2, 3 * h / 4
+	}
+	return w/4 + float32(i)*w/2, 3 * h / 4
+}
+
+// stickCenter returns center of i-th stick, where it's held or at rest.
+func (a *App) stickCenter(i int) (x, y float32) {
+	if j := &a.sticks[i]; j.touched {
+		return j.x, j.y
+	}
+	return a.stickHome(i)
+}
+
+// stickAt returns index of free stick taken by touch starting at point, or -1.
+// Fixed stick has to be touched, floating ones take anything in their part of
+// screen.
+func (a *App) stickAt(xp, yp float32) int {
+	i := 0
+	switch a.layout() {
+	case layoutFixed:
+		x, y := a.stickHome(0)
+		if math.Hypot(float64(xp-x), float64(yp-y)) > ctrlSize/2 {
+			return -1
+		}
+	case layoutTank:
+		if xp >= a.screen.w/2 {
+			i = 1
+		}
+	}
+	if a.sticks[i].touched {
+		return -1
+	}
+	return i
+}
+
+// TouchStick moves sticks with touches, each stick following its own touch
+// sequence, so tank layout can be driven with two thumbs.
+func (a *App) TouchStick(sz size.Event, e touch.Event) {
+	if sz.PixelsPerPt == 0 {
+		return
+	}
+	l := a.layout()
+	if l == layoutTilt {
+		a.touchTilt(sz, e)
+		return
+	}
+	xp, yp := e.X/sz.PixelsPerPt, e.Y/sz.PixelsPerPt
+	i := -1
+	for k := range a.sticks {
+		if a.sticks[k].touched && a.sticks[k].touch == e.Sequence {
+			i = k
+		}
+	}
+	if i < 0 {
+		if e.Type != touch.TypeBegin {
+			return
+		}
+		if i = a.stickAt(xp, yp); i < 0 {
+			return
+		}
+		j := &a.sticks[i]
+		j.touch, j.touched = e.Sequence, true
+		j.x, j.y = a.stickHome(i)
+		if l != layoutFixed {
+			// Whole ring stays on screen.
+			j.x = clampPt(xp, ctrlSize/2, a.screen.w-ctrlSize/2)
+			j.y = clampPt(yp, ctrlSize/2, a.screen.h-ctrlSize/2)
+		}
+	}
+	if e.Type == touch.TypeEnd {
+		a.sticks[i] = joystick{}
+	} else {
+		a.sticks[i].set(xp, yp, l == layoutTank)
+	}
+	a.SendDrive()
+}
+
+// ResetStick sets sticks to rest position, forgetting their touches.
+func (a *App) ResetStick(sz size.Event) {
+	for i := range a.sticks {
+		a.sticks[i] = joystick{}
+	}
+	a.tilt.held = false
+	a.SendDrive()
+}
+
+// stickDirection returns direction of sticks shaped by settings.
+func (a *App) stickDirection() *pb.Direction {
+	shape := func(v float64) int32 { return int32(a.settings.shape(v) * 100) }
+	switch a.layout() {
+	case layoutTank:
+		_, l := a.sticks[0].deflection()
+		_, r := a.sticks[1].deflection()
+		return &pb.Direction{Tank: true, Left: shape(l), Right: shape(r)}
+	case layoutTilt:
+		if !a.tilt.held {
+			return &pb.Direction{}
+		}
+		x, y := a.tiltDeflection()
+		return &pb.Direction{Dx: shape(x), Dy: shape(y)}
+	}
+	x, y := a.sticks[0].deflection()
+	return &pb.Direction{Dx: shape(x), Dy: shape(y)}
+}
+
+func clampPt(v, min, max float32) float32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// stickNodes adds controller rings and sticks, the second one only shown in
+// tank layout. In tilt layout the ring is hold button, with stick showing
+// tilt, and calibrate button next to it.
+func (a *App) stickNodes(newNode func(arrangerFunc), texs, icons []sprite.SubTex) {
+	for i := range a.sticks {
+		hidden := func() bool { return i > 0 && a.layout() != layoutTank }
+		newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+			if hidden() {
+				eng.SetSubTex(n, sprite.SubTex{})
+				return
+			}
+			x, y := a.stickCenter(i)
+			eng.SetSubTex(n, texs[texCtrl])
+			eng.SetTransform(n, f32.Affine{
+				{ctrlSize, 0, x - ctrlSize/2},
+				{0, ctrlSize, y - ctrlSize/2},
+			})
+		})
+		newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+			if hidden() {
+				eng.SetSubTex(n, sprite.SubTex{})
+				return
+			}
+			x, y := a.stickCenter(i)
+			dx, dy := a.sticks[i].dx, a.sticks[i].dy
+			if a.layout() == layoutTilt {
+				tx, ty := a.tiltDeflection()
+				dx, dy = float32(tx)*ctrlRadius, -float32(ty)*ctrlRadius
+			}
+			eng.SetSubTex(n, texs[texStick])
+			eng.SetTransform(n, f32.Affine{
+				{ctrlStickSize, 0, x + dx - ctrlStickSize/2},
+				{0, ctrlStickSize, y + dy - ctrlStickSize/2},
+			})
+		})
+	}
+	newNode(func(eng sprite.Engine, n *sprite.Node, t clock.Time) {
+		if a.layout() != layoutTilt {
+			eng.SetSubTex(n, sprite.SubTex{})
+			return
+		}
+		x, y := a.iconPos(iconCalibrate)
+		eng.SetSubTex(n, icons[iconCalibrate])
+		eng.SetTransform(n, f32.Affine{
+			{iconSize, 0, x},
+			{0, iconSize, y},
+		})
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"time"
+
+	"golang.org/x/mobile/event/size"
+	"golang.org/x/mobile/event/touch"
+	"golang.org/x/mobile/exp/sensor"
+)
+
+// Tilt from neutral position giving full power, and accelerometer event rate,
+// well below flood limit of the bot.
+const (
+	maxTilt    = 30 * math.Pi / 180
+	tiltPeriod = time.Millisecond * 50
+)
+
+// tilt drives by tilting the phone while hold button is held.
+type tilt struct {
+	enabled     bool // Accelerometer is on.
+	unavailable bool // Accelerometer failed to start, e.g. on desktop.
+	pitch, roll float64
+	touch       touch.Sequence // Touch holding the hold button.
+	held        bool
+}
+
+// UpdateSensors turns accelerometer on for tilt layout and off otherwise. It
+// has to be called from the event loop.
+func (a *App) UpdateSensors() {
+	t := &a.tilt
+	want := a.settings.Layout == layoutTilt && !t.unavailable
+	switch {
+	case want && !t.enabled:
+		if err := sensor.Enable(sensor.Accelerometer, tiltPeriod); err != nil {
+			log.Printf("No tilt driving: %v", err)
+			t.unavailable = true
+			return
+		}
+		t.enabled = true
+	case !want && t.enabled:
+		if err := sensor.Disable(sensor.Accelerometer); err != nil {
+			log.Printf("Can't stop accelerometer: %v", err)
+		}
+		t.enabled = false
+	}
+}
+
+// StopSensors turns accelerometer off, e.g. when the app goes to background.
+func (a *App) StopSensors() {
+	if a.tilt.enabled {
+		sensor.Disable(sensor.Accelerometer)
+		a.tilt.enabled = false
+	}
+}
+
+// Tilt takes tilt of the phone from accelerometer and drives with it while
+// hold button is held.
+func (a *App) Tilt(e sensor.Event) {
+	if e.Sensor != sensor.Accelerometer || len(e.Data) < 3 {
+		return
+	}
+	x, y, z := e.Data[0], e.Data[1], e.Data[2]
+	if runtime.GOOS == "ios" {
+		// Core Motion reports gravity, Android reaction to it.
+		x, y, z = -x, -y, -z
+	}
+	// Roll is measured against the whole yz plane, so it's stable also with
+	// the phone held upright.
+	a.tilt.pitch = math.Atan2(y, z)
+	a.tilt.roll = math.Atan2(x, math.Hypot(y, z))
+	if a.tilt.held {
+		a.SendDrive()
+	}
+}
+
+// Calibrate makes current tilt of the phone the neutral position.
+func (a *App) Calibrate() {
+	a.settings.TiltPitch, a.settings.TiltRoll = a.tilt.pitch, a.tilt.roll
+	a.settings.TiltCalibrated = true
+	go saveSettings(a.settings)
+	log.Print("Tilt calibrated")
+}
+
+// tiltDeflection returns tilt from neutral position as stick deflection,
+// between -1 and 1 with y pointing forward. Tilting the top away drives
+// forward and tilting right side down turns right.
+func (a *App) tiltDeflection() (x, y float64) {
+	clamp := func(v float64) float64 { return math.Max(-1, math.Min(1, v/maxTilt)) }
+	return clamp(a.settings.TiltRoll - a.tilt.roll), clamp(a.settings.TiltPitch - a.tilt.pitch)
+}
+
+// touchTilt handles touches of hold and calibrate buttons, drawn in place of
+// the stick. The bot drives only while hold button is held, the first hold
+// calibrates when it's never been done.
+func (a *App) touchTilt(sz size.Event, e touch.Event) {
+	t := &a.tilt
+	if t.held && e.Sequence == t.touch {
+		if e.Type == touch.TypeEnd {
+			t.held = false
+			a.SendDrive()
+		}
+		return
+	}
+	if e.Type != touch.TypeBegin || t.held {
+		return
+	}
+	xp, yp := e.X/sz.PixelsPerPt, e.Y/sz.PixelsPerPt
+	if a.onIcon(iconCalibrate, xp, yp) {
+		a.Calibrate()
+		return
+	}
+	x, y := a.stickHome(0)
+	if math.Hypot(float64(xp-x), float64(yp-y)) > ctrlSize/2 {
+		return
+	}
+	if !a.settings.TiltCalibrated {
+		a.Calibrate()
+	}
+	t.touch, t.held = e.Sequence, true
+	a.SendDrive()
+}